@@ -0,0 +1,501 @@
+// Package analysistest provides a mock of the Soracom analysis API for
+// offline development and tests.
+//
+// The mock implements /v1/auth, /v1/analysis/schemas, /v1/analysis/queries
+// and /v1/analysis/sql_assistant, and serves query results as gzip JSONL
+// downloads. Data comes from Fixtures, typically one JSONL file per table:
+//
+//	fixtures, err := analysistest.LoadFixtures("testdata")
+//	if err != nil {
+//		t.Fatal(err)
+//	}
+//	server := analysistest.NewServer(fixtures)
+//	defer server.Close()
+//
+//	client, err := analysis.New(ctx, analysis.Options{
+//		Config: &analysis.Config{AuthKeyId: "keyId-test", AuthKey: "secret-test", Endpoint: server.URL},
+//	})
+package analysistest
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"soraql/analysis"
+)
+
+// Fixtures is the data served by the mock.
+type Fixtures struct {
+	// Tables holds the rows of every table, keyed by upper-case table name.
+	Tables map[string][]map[string]interface{}
+
+	// Schema is returned by /v1/analysis/schemas. When nil, a schema in the
+	// Soracom format is generated from Tables.
+	Schema json.RawMessage
+}
+
+// LoadFixtures reads every <TABLE>.jsonl file in dir as the rows of TABLE,
+// and schema.json, if present, as the schema document.
+func LoadFixtures(dir string) (*Fixtures, error) {
+	fixtures := &Fixtures{Tables: make(map[string][]map[string]interface{})}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.jsonl"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		rows, err := loadRows(path)
+		if err != nil {
+			return nil, err
+		}
+		table := strings.ToUpper(strings.TrimSuffix(filepath.Base(path), ".jsonl"))
+		fixtures.Tables[table] = rows
+	}
+
+	schema, err := os.ReadFile(filepath.Join(dir, "schema.json"))
+	if err == nil {
+		if !json.Valid(schema) {
+			return nil, fmt.Errorf("schema file '%s' is not valid JSON", filepath.Join(dir, "schema.json"))
+		}
+		fixtures.Schema = schema
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if len(fixtures.Tables) == 0 {
+		return nil, fmt.Errorf("no *.jsonl fixtures found in '%s'", dir)
+	}
+	return fixtures, nil
+}
+
+func loadRows(path string) ([]map[string]interface{}, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	rows := analysis.NewRows(file, nil)
+	defer rows.Close()
+
+	var result []map[string]interface{}
+	for rows.Next() {
+		result = append(result, rows.Row())
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("fixture '%s': %v", path, err)
+	}
+	return result, nil
+}
+
+// DefaultFixtures returns a small SIM_SNAPSHOTS and CELL_TOWERS data set.
+func DefaultFixtures() *Fixtures {
+	return &Fixtures{Tables: map[string][]map[string]interface{}{
+		"SIM_SNAPSHOTS": {
+			{"SIM_ID": "8981100000000000001", "IMSI": "440100000000001", "STATUS": "active", "SPEED_CLASS": "s1.standard", "TAGS": map[string]interface{}{"name": "sensor-01"}},
+			{"SIM_ID": "8981100000000000002", "IMSI": "440100000000002", "STATUS": "inactive", "SPEED_CLASS": "s1.minimum", "TAGS": map[string]interface{}{"name": "sensor-02"}},
+			{"SIM_ID": "8981100000000000003", "IMSI": "440100000000003", "STATUS": "active", "SPEED_CLASS": "s1.fast", "TAGS": map[string]interface{}{"name": "gateway"}},
+		},
+		"CELL_TOWERS": {
+			{"MCC": float64(440), "MNC": float64(10), "LAC": float64(4352), "CID": float64(12345678), "LAT": 35.6812, "LON": 139.7671},
+			{"MCC": float64(440), "MNC": float64(20), "LAC": float64(8704), "CID": float64(87654321), "LAT": 34.7025, "LON": 135.4959},
+		},
+	}}
+}
+
+// DefaultStatuses are the states a query passes through, one per status
+// check, before it is COMPLETED.
+var DefaultStatuses = []string{"QUEUED", "RUNNING", "EXPORTING"}
+
+// Handler is an http.Handler implementing the mock API.
+type Handler struct {
+	// Statuses are reported by successive status checks of a query before
+	// it is COMPLETED. It defaults to DefaultStatuses.
+	Statuses []string
+
+	// MFACode, if set, is the one-time code every login must include, as for
+	// an account with multi-factor authentication.
+	MFACode string
+
+	fixtures *Fixtures
+	mux      *http.ServeMux
+
+	mu      sync.Mutex
+	tokens  map[string]bool
+	issued  int
+	queries map[string]*mockQuery
+	nextID  int
+}
+
+type mockQuery struct {
+	checks    int
+	cancelled bool
+	err       string
+	columns   []analysis.ColumnInfo
+	result    []byte // gzip JSONL
+	created   time.Time
+}
+
+// NewHandler returns a mock API serving fixtures.
+func NewHandler(fixtures *Fixtures) *Handler {
+	h := &Handler{
+		Statuses: DefaultStatuses,
+		fixtures: fixtures,
+		mux:      http.NewServeMux(),
+		tokens:   make(map[string]bool),
+		queries:  make(map[string]*mockQuery),
+	}
+
+	h.mux.HandleFunc("POST /v1/auth", h.auth)
+	h.mux.HandleFunc("GET /v1/analysis/schemas", h.authorized(h.schemas))
+	h.mux.HandleFunc("POST /v1/analysis/queries", h.authorized(h.submit))
+	h.mux.HandleFunc("GET /v1/analysis/queries/{id}", h.authorized(h.status))
+	h.mux.HandleFunc("DELETE /v1/analysis/queries/{id}", h.authorized(h.cancel))
+	h.mux.HandleFunc("POST /v1/analysis/sql_assistant", h.authorized(h.assistant))
+	h.mux.HandleFunc("GET /results/{file}", h.download)
+	return h
+}
+
+// NewServer starts a plain HTTP mock API serving fixtures. The caller must
+// close it.
+func NewServer(fixtures *Fixtures) *httptest.Server {
+	return httptest.NewServer(NewHandler(fixtures))
+}
+
+// ServeHTTP implements http.Handler.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+// ExpireTokens invalidates every issued API token, so the next API call
+// fails with 401 until the client logs in again.
+func (h *Handler) ExpireTokens() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.tokens = make(map[string]bool)
+}
+
+func (h *Handler) auth(w http.ResponseWriter, r *http.Request) {
+	var credentials struct {
+		AuthKeyId           string `json:"authKeyId"`
+		AuthKey             string `json:"authKey"`
+		Email               string `json:"email"`
+		OperatorId          string `json:"operatorId"`
+		UserName            string `json:"userName"`
+		Password            string `json:"password"`
+		MFAOTPCode          string `json:"mfaOTPCode"`
+		TokenTimeoutSeconds int    `json:"tokenTimeoutSeconds"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&credentials); err != nil {
+		writeError(w, http.StatusBadRequest, "COM0001", "invalid auth request")
+		return
+	}
+	if (credentials.AuthKeyId == "" || credentials.AuthKey == "") &&
+		(credentials.Email == "" || credentials.Password == "") &&
+		(credentials.OperatorId == "" || credentials.UserName == "" || credentials.Password == "") {
+		writeError(w, http.StatusUnauthorized, "AUT0001", "missing credentials")
+		return
+	}
+	if h.MFACode != "" && credentials.MFAOTPCode != h.MFACode {
+		writeError(w, http.StatusUnauthorized, "AUT0003", "MFA OTP code is required or invalid")
+		return
+	}
+
+	h.mu.Lock()
+	h.issued++
+	token := fmt.Sprintf("mock-token-%d", h.issued)
+	h.tokens[token] = true
+	h.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, analysis.AuthResponse{ApiKey: "mock-api-key", Token: token})
+}
+
+// authorized rejects requests without a token issued by /v1/auth.
+func (h *Handler) authorized(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h.mu.Lock()
+		valid := h.tokens[r.Header.Get("x-soracom-token")]
+		h.mu.Unlock()
+
+		if !valid || r.Header.Get("x-soracom-api-key") != "mock-api-key" {
+			writeError(w, http.StatusUnauthorized, "AUT0002", "invalid or expired API key and token")
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (h *Handler) schemas(w http.ResponseWriter, r *http.Request) {
+	if h.fixtures.Schema != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(h.fixtures.Schema)
+		return
+	}
+
+	var tables []map[string]interface{}
+	for _, name := range h.tableNames() {
+		tables = append(tables, map[string]interface{}{
+			"name":       name,
+			"columnInfo": h.columns(name, h.fixtures.Tables[name]),
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"tables": tables})
+}
+
+var (
+	fromPattern  = regexp.MustCompile(`(?i)\bfrom\s+([A-Za-z_][A-Za-z0-9_]*)`)
+	limitPattern = regexp.MustCompile(`(?i)\blimit\s+(\d+)`)
+	countPattern = regexp.MustCompile(`(?i)^\s*select\s+count\(\*\)\s+from\b`)
+)
+
+func (h *Handler) submit(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		SQL string `json:"sql"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || strings.TrimSpace(request.SQL) == "" {
+		writeError(w, http.StatusBadRequest, "ANA0001", "sql is required")
+		return
+	}
+
+	query := &mockQuery{created: time.Now()}
+	if err := h.run(query, request.SQL); err != nil {
+		query.err = err.Error()
+	}
+
+	h.mu.Lock()
+	h.nextID++
+	id := fmt.Sprintf("mock-query-%d", h.nextID)
+	h.queries[id] = query
+	h.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, analysis.QueryResponse{QueryId: id})
+}
+
+// run evaluates the small subset of SQL the mock understands: the table
+// after FROM, an optional LIMIT, and SELECT COUNT(*).
+func (h *Handler) run(query *mockQuery, sql string) error {
+	match := fromPattern.FindStringSubmatch(sql)
+	if match == nil {
+		return fmt.Errorf("SQL compilation error: missing FROM clause")
+	}
+	table := strings.ToUpper(match[1])
+	rows, ok := h.fixtures.Tables[table]
+	if !ok {
+		return fmt.Errorf("SQL compilation error: Object '%s' does not exist or not authorized.", table)
+	}
+
+	if countPattern.MatchString(sql) {
+		rows = []map[string]interface{}{{"COUNT(*)": float64(len(rows))}}
+		query.columns = []analysis.ColumnInfo{{Name: "COUNT(*)", Type: "number", DatabaseType: "NUMBER"}}
+	} else {
+		if limit := limitPattern.FindStringSubmatch(sql); limit != nil {
+			if n, err := strconv.Atoi(limit[1]); err == nil && n < len(rows) {
+				rows = rows[:n]
+			}
+		}
+		query.columns = h.columns(table, rows)
+	}
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	encoder := json.NewEncoder(gz)
+	for _, row := range rows {
+		if err := encoder.Encode(row); err != nil {
+			return err
+		}
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	query.result = buf.Bytes()
+	return nil
+}
+
+func (h *Handler) status(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	h.mu.Lock()
+	query, ok := h.queries[id]
+	var status string
+	if ok {
+		switch {
+		case query.cancelled:
+			status = "CANCELLED"
+		case query.err != "":
+			status = "FAILED"
+		case query.checks < len(h.Statuses):
+			status = h.Statuses[query.checks]
+		default:
+			status = "COMPLETED"
+		}
+		query.checks++
+	}
+	h.mu.Unlock()
+
+	if !ok {
+		writeError(w, http.StatusNotFound, "ANA0004", fmt.Sprintf("query '%s' not found", id))
+		return
+	}
+
+	response := map[string]interface{}{"status": status}
+	switch status {
+	case "FAILED":
+		response["error"] = query.err
+	case "COMPLETED":
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+		response["url"] = fmt.Sprintf("%s://%s/results/%s.jsonl.gz?signature=mock", scheme, r.Host, id)
+		response["columnInfo"] = query.columns
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (h *Handler) cancel(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	query, ok := h.queries[r.PathValue("id")]
+	if ok {
+		query.cancelled = true
+	}
+	h.mu.Unlock()
+
+	if !ok {
+		writeError(w, http.StatusNotFound, "ANA0004", fmt.Sprintf("query '%s' not found", r.PathValue("id")))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) download(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimSuffix(r.PathValue("file"), ".jsonl.gz")
+
+	h.mu.Lock()
+	query, ok := h.queries[id]
+	h.mu.Unlock()
+
+	if !ok || query.result == nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/gzip")
+	http.ServeContent(w, r, r.PathValue("file"), query.created, bytes.NewReader(query.result))
+}
+
+func (h *Handler) assistant(w http.ResponseWriter, r *http.Request) {
+	var request analysis.SQLAssistantRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || len(request.Messages) == 0 {
+		writeError(w, http.StatusBadRequest, "ANA0001", "messages are required")
+		return
+	}
+
+	// Suggest the first table the question mentions, or the first table
+	question := strings.ToUpper(request.Messages[len(request.Messages)-1].Context)
+	names := h.tableNames()
+	if len(names) == 0 {
+		writeError(w, http.StatusNotFound, "ANA0004", "no tables to suggest a query for")
+		return
+	}
+	table := names[0]
+	for _, name := range names {
+		if strings.Contains(question, name) {
+			table = name
+			break
+		}
+	}
+
+	writeJSON(w, http.StatusOK, analysis.SQLAssistantResponse{
+		ID:       fmt.Sprintf("mock-assistant-%d", time.Now().UnixNano()),
+		SQLQuery: fmt.Sprintf("SELECT * FROM %s LIMIT 10", table),
+		Context:  fmt.Sprintf("This mock assistant always suggests a sample of %s.", table),
+	})
+}
+
+// columns returns the column info of a table: from the fixture schema if it
+// describes the table, otherwise inferred from the rows.
+func (h *Handler) columns(table string, rows []map[string]interface{}) []analysis.ColumnInfo {
+	if h.fixtures.Schema != nil {
+		var schema map[string]interface{}
+		if err := json.Unmarshal(h.fixtures.Schema, &schema); err == nil {
+			if tableColumns, ok := analysis.ExtractTableSchemas(schema)[table]; ok {
+				var columns []analysis.ColumnInfo
+				for _, col := range tableColumns {
+					columns = append(columns, analysis.ColumnInfo{Name: col.Name, Type: jsonType(nil, col.Type), DatabaseType: col.Type})
+				}
+				return columns
+			}
+		}
+	}
+
+	names := make(map[string]interface{})
+	for _, row := range rows {
+		for key, value := range row {
+			if names[key] == nil {
+				names[key] = value
+			}
+		}
+	}
+
+	var columns []analysis.ColumnInfo
+	for name, sample := range names {
+		databaseType := map[string]string{"number": "NUMBER", "boolean": "BOOLEAN", "object": "VARIANT", "string": "VARCHAR"}[jsonType(sample, "")]
+		columns = append(columns, analysis.ColumnInfo{Name: name, Type: jsonType(sample, ""), DatabaseType: databaseType})
+	}
+	sort.Slice(columns, func(i, j int) bool { return columns[i].Name < columns[j].Name })
+	return columns
+}
+
+// jsonType names the API column type of a sample value, or of a database
+// type when there is no sample.
+func jsonType(sample interface{}, databaseType string) string {
+	if sample == nil {
+		switch strings.ToUpper(databaseType) {
+		case "NUMBER", "FLOAT", "INTEGER", "DECIMAL":
+			return "number"
+		case "BOOLEAN":
+			return "boolean"
+		case "VARIANT", "OBJECT", "ARRAY":
+			return "object"
+		}
+		return "string"
+	}
+
+	switch sample.(type) {
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	case map[string]interface{}, []interface{}:
+		return "object"
+	}
+	return "string"
+}
+
+func (h *Handler) tableNames() []string {
+	var names []string
+	for name := range h.fixtures.Tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, analysis.ErrorResponse{Code: code, Message: message})
+}
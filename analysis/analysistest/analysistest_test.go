@@ -0,0 +1,311 @@
+package analysistest
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"soraql/analysis"
+)
+
+func newTestClient(t *testing.T, endpoint string) *analysis.Client {
+	t.Helper()
+
+	client, err := analysis.New(context.Background(), analysis.Options{
+		Config: &analysis.Config{AuthKeyId: "keyId-test", AuthKey: "secret-test", Endpoint: endpoint},
+	})
+	if err != nil {
+		t.Fatalf("analysis.New() error = %v", err)
+	}
+	return client
+}
+
+func TestMockServerQuery(t *testing.T) {
+	fixtures, err := LoadFixtures("testdata")
+	if err != nil {
+		t.Fatalf("LoadFixtures() error = %v", err)
+	}
+	server := NewServer(fixtures)
+	defer server.Close()
+
+	client := newTestClient(t, server.URL)
+
+	var seen []string
+	rows, err := client.Query(context.Background(), "SELECT * FROM sim_snapshots LIMIT 2", &analysis.QueryOptions{
+		Wait: &analysis.WaitOptions{
+			Interval: time.Millisecond,
+			OnStatus: func(status string) { seen = append(seen, status) },
+		},
+	})
+	if err != nil {
+		t.Fatalf("Query() error = %v", err)
+	}
+	defer rows.Close()
+
+	if got := strings.Join(seen, ","); got != "QUEUED,RUNNING,EXPORTING,COMPLETED" {
+		t.Errorf("Query() went through %s, want every mock status", got)
+	}
+	if len(rows.Columns) != 3 || rows.Columns[0].Name != "SIM_ID" || rows.Columns[0].DatabaseType != "VARCHAR" {
+		t.Errorf("Query() columns = %v, want the schema file's columns", rows.Columns)
+	}
+
+	count := 0
+	for rows.Next() {
+		count++
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("Rows.Err() = %v", err)
+	}
+	if count != 2 {
+		t.Errorf("Query() yielded %d rows, want 2", count)
+	}
+}
+
+func TestMockServerCount(t *testing.T) {
+	server := NewServer(DefaultFixtures())
+	defer server.Close()
+
+	client := newTestClient(t, server.URL)
+	rows, err := client.Query(context.Background(), "select count(*) from CELL_TOWERS", &analysis.QueryOptions{
+		Wait: &analysis.WaitOptions{Interval: time.Millisecond},
+	})
+	if err != nil {
+		t.Fatalf("Query() error = %v", err)
+	}
+	defer rows.Close()
+
+	if !rows.Next() || rows.Row()["COUNT(*)"] != float64(2) {
+		t.Errorf("Query() row = %v, want COUNT(*) = 2", rows.Row())
+	}
+}
+
+func TestMockServerAssistantWithoutTables(t *testing.T) {
+	server := NewServer(&Fixtures{})
+	defer server.Close()
+
+	client := newTestClient(t, server.URL)
+	_, err := client.AskSQLAssistant(context.Background(), "How many SIMs are active?", "")
+	if err == nil || !strings.Contains(err.Error(), "no tables") {
+		t.Errorf("AskSQLAssistant() error = %v, want no tables", err)
+	}
+}
+
+func TestMockServerFailedQuery(t *testing.T) {
+	server := NewServer(DefaultFixtures())
+	defer server.Close()
+
+	client := newTestClient(t, server.URL)
+	_, err := client.Query(context.Background(), "SELECT * FROM NO_SUCH_TABLE", &analysis.QueryOptions{
+		Wait: &analysis.WaitOptions{Interval: time.Millisecond},
+	})
+	if err == nil || !strings.Contains(err.Error(), "NO_SUCH_TABLE") {
+		t.Errorf("Query() error = %v, want a failed query naming the table", err)
+	}
+}
+
+func TestQueryCancelsQueryAfterMaxWait(t *testing.T) {
+	handler := NewHandler(DefaultFixtures())
+	handler.Statuses = make([]string, 1000)
+	for i := range handler.Statuses {
+		handler.Statuses[i] = "RUNNING"
+	}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	client := newTestClient(t, server.URL)
+	_, err := client.Query(context.Background(), "SELECT * FROM SIM_SNAPSHOTS", &analysis.QueryOptions{
+		Wait: &analysis.WaitOptions{Interval: time.Millisecond, MaxWait: 20 * time.Millisecond},
+	})
+	if err == nil || !strings.Contains(err.Error(), "did not complete within") {
+		t.Fatalf("Query() error = %v, want a max-wait error", err)
+	}
+
+	status, err := client.Status(context.Background(), "mock-query-1")
+	if err != nil {
+		t.Fatalf("Status() error = %v", err)
+	}
+	if status.Status != "CANCELLED" {
+		t.Errorf("query status after giving up = %s, want CANCELLED", status.Status)
+	}
+}
+
+func TestMockServerSchemaAndAssistant(t *testing.T) {
+	server := NewServer(DefaultFixtures())
+	defer server.Close()
+
+	client := newTestClient(t, server.URL)
+	tables, err := client.Tables(context.Background())
+	if err != nil {
+		t.Fatalf("Tables() error = %v", err)
+	}
+	if strings.Join(tables, ",") != "CELL_TOWERS,SIM_SNAPSHOTS" {
+		t.Errorf("Tables() = %v, want the fixture tables", tables)
+	}
+
+	response, err := client.AskSQLAssistant(context.Background(), "How many SIM_SNAPSHOTS are active?", "")
+	if err != nil {
+		t.Fatalf("AskSQLAssistant() error = %v", err)
+	}
+	if !strings.Contains(response.SQLQuery, "SIM_SNAPSHOTS") {
+		t.Errorf("AskSQLAssistant() suggested %q, want a SIM_SNAPSHOTS query", response.SQLQuery)
+	}
+}
+
+func TestMockServerRequiresToken(t *testing.T) {
+	server := NewServer(DefaultFixtures())
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/v1/analysis/schemas")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("GET without a token = HTTP %d, want 401", resp.StatusCode)
+	}
+}
+
+func TestClientReauthenticatesExpiredToken(t *testing.T) {
+	handler := NewHandler(DefaultFixtures())
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	client := newTestClient(t, server.URL)
+	handler.ExpireTokens()
+
+	if _, err := client.Tables(context.Background()); err != nil {
+		t.Errorf("Tables() after the token expired: error = %v, want a fresh login", err)
+	}
+}
+
+func TestMockServerSAMUserWithMFA(t *testing.T) {
+	handler := NewHandler(DefaultFixtures())
+	handler.MFACode = "123456"
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	config := &analysis.Config{OperatorId: "OP0000000000", Username: "analyst", Password: "pw", Endpoint: server.URL}
+	if _, err := analysis.New(context.Background(), analysis.Options{Config: config}); err == nil || !strings.Contains(err.Error(), "multi-factor") {
+		t.Errorf("New() without an MFA prompt: error = %v, want a multi-factor authentication error", err)
+	}
+
+	prompts := 0
+	client, err := analysis.New(context.Background(), analysis.Options{
+		Config: config,
+		PromptMFA: func(ctx context.Context) (string, error) {
+			prompts++
+			return "123456", nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("New() with an MFA prompt: error = %v", err)
+	}
+	if prompts != 1 {
+		t.Errorf("PromptMFA called %d times, want 1", prompts)
+	}
+	if _, err := client.Tables(context.Background()); err != nil {
+		t.Errorf("Tables() error = %v", err)
+	}
+}
+
+func TestMockServerCustomCA(t *testing.T) {
+	server := httptest.NewUnstartedServer(NewHandler(DefaultFixtures()))
+	// The rejected handshake is expected
+	server.Config.ErrorLog = log.New(io.Discard, "", 0)
+	server.StartTLS()
+	defer server.Close()
+
+	caCert := filepath.Join(t.TempDir(), "ca.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(caCert, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	config := &analysis.Config{AuthKeyId: "keyId-test", AuthKey: "secret-test", Endpoint: server.URL}
+	if _, err := analysis.New(context.Background(), analysis.Options{Config: config}); err == nil || !strings.Contains(err.Error(), "certificate") {
+		t.Errorf("New() without the CA certificate: error = %v, want a certificate error", err)
+	}
+
+	// The profile's caCert applies to the login, the API and the downloads
+	config.CACert = caCert
+	client, err := analysis.New(context.Background(), analysis.Options{Config: config})
+	if err != nil {
+		t.Fatalf("New() with the profile's caCert: error = %v", err)
+	}
+	rows, err := client.Query(context.Background(), "SELECT * FROM SIM_SNAPSHOTS", &analysis.QueryOptions{Wait: &analysis.WaitOptions{Interval: time.Millisecond}})
+	if err != nil {
+		t.Fatalf("Query() error = %v", err)
+	}
+	defer rows.Close()
+	count := 0
+	for rows.Next() {
+		count++
+	}
+	if err := rows.Err(); err != nil || count != 3 {
+		t.Errorf("Query() returned %d rows, error = %v, want 3", count, err)
+	}
+}
+
+// TestRecorderUsesEachProfilesClient is meant for go test -race as well:
+// -profiles logs in under every profile at once with one Recorder.
+func TestRecorderUsesEachProfilesClient(t *testing.T) {
+	server := httptest.NewUnstartedServer(NewHandler(DefaultFixtures()))
+	// The rejected handshake is expected
+	server.Config.ErrorLog = log.New(io.Discard, "", 0)
+	server.StartTLS()
+	defer server.Close()
+
+	caCert := filepath.Join(t.TempDir(), "ca.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(caCert, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var recording strings.Builder
+	recorder := analysis.NewRecorder(nil, &recording)
+	login := func(caCert string) error {
+		config := &analysis.Config{AuthKeyId: "keyId-test", AuthKey: "secret-test", Endpoint: server.URL, CACert: caCert}
+		_, err := analysis.New(context.Background(), analysis.Options{Config: config, HTTPClient: recorder})
+		return err
+	}
+
+	// A profile without the CA certificate must not decide the client of the
+	// next one, as after .profile
+	if err := login(""); err == nil {
+		t.Fatal("New() without the CA certificate succeeded")
+	}
+	if err := login(caCert); err != nil {
+		t.Fatalf("New() with the profile's caCert after another profile: error = %v", err)
+	}
+
+	errs := make(chan error, 4)
+	for i := 0; i < cap(errs); i++ {
+		go func() { errs <- login(caCert) }()
+	}
+	for i := 0; i < cap(errs); i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("concurrent New() error = %v", err)
+		}
+	}
+
+	// Every exchange is a whole line of its own
+	lines := strings.Split(strings.TrimSpace(recording.String()), "\n")
+	if len(lines) != 2+cap(errs) {
+		t.Errorf("recorded %d exchanges, want %d:\n%s", len(lines), 2+cap(errs), recording.String())
+	}
+	for _, line := range lines {
+		var exchange analysis.Exchange
+		if err := json.Unmarshal([]byte(line), &exchange); err != nil {
+			t.Errorf("invalid recorded line %q: %v", line, err)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package analysis
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// SQLAssistantMessage is one turn of a conversation with the SQL assistant.
+type SQLAssistantMessage struct {
+	Role      string `json:"role"`
+	Context   string `json:"context"`
+	AgentMode bool   `json:"agentMode"`
+}
+
+// SQLAssistantTimeRange bounds the data the assistant considers.
+type SQLAssistantTimeRange struct {
+	Hours int `json:"hours"`
+}
+
+// SQLAssistantRequest is the body sent to /v1/analysis/sql_assistant.
+type SQLAssistantRequest struct {
+	Messages      []SQLAssistantMessage `json:"messages"`
+	TimeRange     SQLAssistantTimeRange `json:"timeRange"`
+	ExistingQuery string                `json:"existing_query"`
+}
+
+// SQLAssistantResponse is the assistant's answer and suggested SQL.
+type SQLAssistantResponse struct {
+	ID            string                 `json:"id"`
+	SQLQuery      string                 `json:"sql_query"`
+	Context       string                 `json:"context"`
+	Visualization map[string]interface{} `json:"visualization"`
+}
+
+// AskSQLAssistant sends a natural language question to the SQL assistant.
+// existingQuery, when not empty, is offered as the query to refine.
+func (c *Client) AskSQLAssistant(ctx context.Context, question, existingQuery string) (*SQLAssistantResponse, error) {
+	request := SQLAssistantRequest{
+		Messages: []SQLAssistantMessage{
+			{
+				Role:      "user",
+				Context:   question,
+				AgentMode: false,
+			},
+		},
+		TimeRange: SQLAssistantTimeRange{
+			Hours: 2,
+		},
+		ExistingQuery: existingQuery,
+	}
+
+	// The assistant is only routed when the SQL helper flag is present
+	headers := map[string]string{"x-soracom-dynamicroutes": "add-sql-helper"}
+	response, err := c.makeRequestWithHeaders(ctx, "POST", c.apiURL("/v1/analysis/sql_assistant"), request, headers)
+	if err != nil {
+		return nil, err
+	}
+
+	var sqlAssistantResponse SQLAssistantResponse
+	if err := json.Unmarshal(response, &sqlAssistantResponse); err != nil {
+		return nil, fmt.Errorf("failed to parse SQL assistant response: %w", err)
+	}
+
+	return &sqlAssistantResponse, nil
+}
@@ -0,0 +1,70 @@
+package analysis
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestSQLAssistantRequestStructure(t *testing.T) {
+	request := SQLAssistantRequest{
+		Messages: []SQLAssistantMessage{
+			{
+				Role:      "user",
+				Context:   "Show me SIM counts by status",
+				AgentMode: false,
+			},
+		},
+		TimeRange: SQLAssistantTimeRange{
+			Hours: 2,
+		},
+		ExistingQuery: "SELECT * FROM SIM_SNAPSHOTS",
+	}
+
+	// Test JSON marshaling
+	data, err := json.Marshal(request)
+	if err != nil {
+		t.Fatalf("Failed to marshal SQL assistant request: %v", err)
+	}
+
+	// Test that it contains expected fields
+	var parsed map[string]interface{}
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		t.Fatalf("Failed to parse marshalled request: %v", err)
+	}
+
+	// Verify structure
+	if parsed["messages"] == nil {
+		t.Error("Expected 'messages' field in request")
+	}
+	if parsed["timeRange"] == nil {
+		t.Error("Expected 'timeRange' field in request")
+	}
+	if parsed["existing_query"] == nil {
+		t.Error("Expected 'existing_query' field in request")
+	}
+}
+
+func TestSQLAssistantResponseParsing(t *testing.T) {
+	// Mock response JSON matching actual API format
+	responseJSON := `{
+		"id": "test-id-123",
+		"sql_query": "SELECT status, COUNT(*) FROM SIM_SNAPSHOTS GROUP BY status",
+		"context": "This query counts SIMs by their status",
+		"visualization": {"display": true, "type": "bar"}
+	}`
+
+	var response SQLAssistantResponse
+	if err := json.Unmarshal([]byte(responseJSON), &response); err != nil {
+		t.Fatalf("Failed to parse SQL assistant response: %v", err)
+	}
+
+	if response.SQLQuery != "SELECT status, COUNT(*) FROM SIM_SNAPSHOTS GROUP BY status" {
+		t.Errorf("Expected SQL to be parsed correctly, got: %s", response.SQLQuery)
+	}
+	if response.Context != "This query counts SIMs by their status" {
+		t.Errorf("Expected context to be parsed correctly, got: %s", response.Context)
+	}
+	if response.ID != "test-id-123" {
+		t.Errorf("Expected ID to be parsed correctly, got: %s", response.ID)
+	}
+}
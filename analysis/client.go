@@ -0,0 +1,566 @@
+// Package analysis is a client for the Soracom Query (data warehouse) API.
+//
+// A Client authenticates with the credentials of a Soracom CLI profile or an
+// explicit Config, submits SQL to /v1/analysis/queries and returns the
+// results as a row iterator together with their column metadata:
+//
+//	client, err := analysis.New(ctx, analysis.Options{Profile: "default"})
+//	if err != nil {
+//		return err
+//	}
+//	rows, err := client.Query(ctx, "SELECT COUNT(*) FROM SIM_SNAPSHOTS", nil)
+//	if err != nil {
+//		return err
+//	}
+//	defer rows.Close()
+//	for rows.Next() {
+//		fmt.Println(rows.Row())
+//	}
+//	return rows.Err()
+package analysis
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// AuthResponse is the body returned by /v1/auth.
+type AuthResponse struct {
+	ApiKey string `json:"apiKey"`
+	Token  string `json:"token"`
+}
+
+// ErrorResponse is the structured error body returned by the Soracom API.
+type ErrorResponse struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// HTTPClient sends HTTP requests. *http.Client implements it; wrappers such
+// as a Recorder or Replayer can be substituted to capture or replay traffic.
+type HTTPClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// Options configures a Client created by New.
+type Options struct {
+	// Profile is the Soracom CLI profile to load with LoadConfig when Config
+	// is nil. It defaults to "default".
+	Profile string
+
+	// Config supplies credentials and endpoint settings directly instead of
+	// reading them from a profile and the environment.
+	Config *Config
+
+	// APIKey and APIToken, if both set, are used for requests instead of
+	// logging in to /v1/auth. The profile and environment then only need to
+	// supply the endpoint; credentials, if present, are used to log in again
+	// when the token expires.
+	APIKey   string
+	APIToken string
+
+	// CredentialProcess, if set, replaces the credentialProcess of the
+	// profile and any credentials from the profile or environment.
+	CredentialProcess string
+
+	// PromptMFA is called for a one-time code when logging in to an account
+	// with multi-factor authentication. Without it such logins fail.
+	PromptMFA func(ctx context.Context) (string, error)
+
+	// HTTPClient is used for every API request and result download. When it
+	// is nil, New builds one with NewHTTPClient from Network and the profile.
+	HTTPClient HTTPClient
+
+	// Network holds the TLS, proxy and timeout settings of the HTTP client
+	// built by New. Settings left empty are taken from the profile.
+	Network NetworkOptions
+
+	// Retries is how many times an API request that failed transiently is
+	// retried, honoring any Retry-After header. Requests that may change
+	// data, such as submitting a query, are only retried when they could not
+	// reach the API or were rejected with 429. Zero means DefaultRetries; a
+	// negative value disables retries.
+	Retries int
+
+	// TokenCache, if set, keeps the API key and token between runs so New
+	// only logs in when the cached token is missing or expired.
+	TokenCache *TokenCache
+
+	// Logger receives diagnostics: every HTTP request with its status and
+	// latency at debug level, and recoverable problems as warnings. When it
+	// is nil, nothing is logged unless Debug is set.
+	Logger *slog.Logger
+
+	// Debug logs debug diagnostics to stderr when Logger is nil.
+	Debug bool
+
+	// DebugUnsafe disables the masking of passwords, auth keys, tokens and
+	// presigned URL signatures in debug output and error messages. Only use
+	// it when the raw values are needed and the output will not be shared.
+	DebugUnsafe bool
+}
+
+// Client talks to the Soracom analysis API on behalf of one operator.
+type Client struct {
+	httpClient    HTTPClient
+	scheme        string // "https" unless the profile endpoint is plain http://
+	baseURL       string
+	authBaseURL   string
+	config        *Config
+	tokenCache    *TokenCache
+	customHeaders map[string]string
+	promptMFA     func(ctx context.Context) (string, error)
+	retries       int // Retries of transient API failures, 0 for none
+	logger        *slog.Logger
+	debug         atomic.Bool // Set by SetDebug while requests are in flight
+	unsafeDebug   bool        // Print secrets in debug output and errors unmasked
+
+	mu             sync.Mutex // Guards the fields below, which change on re-login
+	apiKey         string
+	token          string
+	processConfig  *Config   // Credentials printed by the credential process
+	processExpires time.Time // When processConfig expires, zero for never
+}
+
+// New loads the configured credentials, authenticates against /v1/auth and
+// returns a Client that is ready to run queries.
+func New(ctx context.Context, opts Options) (*Client, error) {
+	c := &Client{
+		httpClient:  opts.HTTPClient,
+		tokenCache:  opts.TokenCache,
+		promptMFA:   opts.PromptMFA,
+		retries:     opts.Retries,
+		logger:      opts.Logger,
+		unsafeDebug: opts.DebugUnsafe,
+	}
+	c.debug.Store(opts.Debug)
+	if c.retries == 0 {
+		c.retries = DefaultRetries
+	} else if c.retries < 0 {
+		c.retries = 0
+	}
+
+	config := opts.Config
+	source := "the given config"
+	if config == nil {
+		profile := opts.Profile
+		if profile == "" {
+			profile = "default"
+		}
+		source = fmt.Sprintf("profile '%s' (%s) and the SORACOM_* environment", profile, ProfilePath(profile))
+
+		c.log().Debug("Loading profile", "profile", profile, "path", ProfilePath(profile))
+
+		var err error
+		config, err = LoadConfig(opts.Profile)
+		if err != nil {
+			if opts.APIKey == "" || opts.APIToken == "" || !errors.Is(err, fs.ErrNotExist) {
+				return nil, err
+			}
+			// An API key and token need no profile, only an endpoint
+			config = &Config{}
+			config.applyEnv()
+		}
+	}
+
+	if opts.CredentialProcess != "" {
+		config.CredentialProcess = opts.CredentialProcess
+		config.Email, config.Password = "", ""
+		config.OperatorId, config.Username = "", ""
+		config.AuthKeyId, config.AuthKey = "", ""
+	}
+
+	c.configure(config)
+
+	if err := c.setupHTTPClient(opts.Network); err != nil {
+		return nil, err
+	}
+
+	if opts.APIKey != "" && opts.APIToken != "" {
+		c.log().Debug("Using the given API key and token")
+		c.setCredentials(opts.APIKey, opts.APIToken)
+		return c, nil
+	}
+
+	if err := config.validate(); err != nil {
+		return nil, fmt.Errorf("incomplete credentials in %s: %v", source, err)
+	}
+
+	if auth, ok := c.tokenCache.load(config); ok {
+		c.log().Debug("Using cached API token", "expires", auth.ExpiresAt.Format(time.RFC3339))
+		c.setCredentials(auth.ApiKey, auth.Token)
+		return c, nil
+	}
+
+	if err := c.authenticate(ctx); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// SetDebug turns debug logging to stderr on or off. It has no effect when
+// the Client was created with a Logger.
+func (c *Client) SetDebug(debug bool) {
+	c.debug.Store(debug)
+}
+
+// configure applies the endpoint and header settings of config.
+func (c *Client) configure(config *Config) {
+	c.config = config
+
+	// Set base URLs based on profile configuration
+	c.scheme = "https"
+	if config.Endpoint != "" {
+		// Use endpoint from profile if specified (remove https:// prefix if present).
+		// An explicit http:// endpoint, e.g. a local mock server, is used as is.
+		if strings.HasPrefix(config.Endpoint, "http://") {
+			c.scheme = "http"
+		}
+		endpoint := strings.TrimPrefix(config.Endpoint, "https://")
+		endpoint = strings.TrimPrefix(endpoint, "http://")
+		c.baseURL = endpoint
+		c.authBaseURL = endpoint
+	} else {
+		// Default to production endpoints based on coverage type
+		if config.CoverageType == "g" {
+			c.baseURL = "g.api.soracom.io"
+			c.authBaseURL = "g.api.soracom.io"
+		} else {
+			// Default to JP coverage
+			c.baseURL = "jp.api.soracom.io"
+			c.authBaseURL = "jp.api.soracom.io"
+		}
+	}
+
+	// Store custom headers from profile
+	c.customHeaders = make(map[string]string)
+	for key, value := range config.Headers {
+		c.customHeaders[key] = value
+	}
+
+	if logger := c.log(); logger.Enabled(context.Background(), slog.LevelDebug) {
+		headers := make(map[string]string)
+		for key, value := range c.customHeaders {
+			if secretHeaders[http.CanonicalHeaderKey(key)] {
+				value = c.redactSecret(value)
+			}
+			headers[key] = value
+		}
+		logger.Debug("Configured API endpoint",
+			"coverageType", config.CoverageType,
+			"endpoint", config.Endpoint,
+			"baseURL", c.baseURL,
+			"authBaseURL", c.authBaseURL,
+			"headers", headers,
+			"email", config.Email,
+			"operatorId", config.OperatorId,
+			"username", config.Username,
+			"credentialProcess", config.CredentialProcess != "",
+			"password", c.redactSecret(config.Password),
+			"authKeyId", c.redactSecret(config.AuthKeyId),
+			"authKey", c.redactSecret(config.AuthKey))
+	}
+}
+
+// setupHTTPClient builds the HTTP client from network and the profile unless
+// one was given. A Recorder created without a client is left as it is, so
+// that every Client gets its own; the Client records with a Recorder
+// wrapping the client it built. The
+// caller warns about an InsecureSkipVerify it set itself; one that comes from
+// the profile is logged as a warning.
+func (c *Client) setupHTTPClient(network NetworkOptions) error {
+	recorder, _ := c.httpClient.(*Recorder)
+	if c.httpClient != nil && (recorder == nil || recorder.client != nil) {
+		return nil
+	}
+
+	merged, err := network.withConfig(c.config)
+	if err != nil {
+		return err
+	}
+	httpClient, err := NewHTTPClient(merged)
+	if err != nil {
+		return err
+	}
+
+	if merged.InsecureSkipVerify && !network.InsecureSkipVerify {
+		c.log().Warn("The profile disables TLS certificate verification; credentials and results can be intercepted and altered")
+	}
+	c.log().Debug("Configured HTTP client",
+		"caCert", merged.CACert,
+		"clientCert", merged.ClientCert,
+		"insecureSkipVerify", merged.InsecureSkipVerify,
+		"proxy", redactProxyURL(merged.Proxy),
+		"noProxy", merged.NoProxy,
+		"connectTimeout", merged.ConnectTimeout,
+		"readTimeout", merged.ReadTimeout)
+
+	if recorder != nil {
+		c.httpClient = recorder.withClient(httpClient)
+	} else {
+		c.httpClient = httpClient
+	}
+	return nil
+}
+
+// authenticate logs in with the configured credentials, stores the new API
+// key and token, and caches them if a token cache is set.
+func (c *Client) authenticate(ctx context.Context) error {
+	config, err := c.loginConfig(ctx)
+	if err != nil {
+		return err
+	}
+
+	authPayload, err := config.authPayload()
+	if err != nil {
+		return fmt.Errorf("incomplete credentials: %v", err)
+	}
+
+	authResp, err := c.login(ctx, authPayload)
+	var apiErr *apiError
+	if errors.As(err, &apiErr) && apiErr.mfaRequired() {
+		if c.promptMFA == nil {
+			return fmt.Errorf("%v (the account requires a multi-factor authentication code)", err)
+		}
+		code, perr := c.promptMFA(ctx)
+		if perr != nil {
+			return fmt.Errorf("failed to read MFA code: %v", perr)
+		}
+		authPayload["mfaOTPCode"] = code
+		authResp, err = c.login(ctx, authPayload)
+	}
+	if err != nil {
+		if errors.As(err, &apiErr) {
+			// The credentials were rejected: get fresh ones next time
+			c.forgetProcessCredentials()
+		}
+		return err
+	}
+
+	c.setCredentials(authResp.ApiKey, authResp.Token)
+	c.tokenCache.store(c.config, authResp)
+
+	return nil
+}
+
+// login sends one /v1/auth request.
+func (c *Client) login(ctx context.Context, authPayload map[string]interface{}) (*AuthResponse, error) {
+	payloadBytes, err := json.Marshal(authPayload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal auth payload: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("%s://%s/v1/auth", c.scheme, c.authBaseURL), bytes.NewBuffer(payloadBytes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create auth request: %v", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	// Add custom headers from profile
+	for key, value := range c.customHeaders {
+		req.Header.Set(key, value)
+	}
+
+	c.log().Debug("Auth request", "payload", c.redact(string(payloadBytes)))
+
+	start := time.Now()
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		c.logHTTP(ctx, req.Method, req.URL.String(), 0, start, err)
+		return nil, fmt.Errorf("auth request failed: %s", c.redact(err.Error()))
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	c.logHTTP(ctx, req.Method, req.URL.String(), resp.StatusCode, start, err)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read auth response: %v", err)
+	}
+
+	c.log().Debug("Auth response", "body", c.redact(string(body)))
+
+	// Check for HTTP error status codes
+	if resp.StatusCode >= 400 {
+		var errorResp ErrorResponse
+		if err := json.Unmarshal(body, &errorResp); err == nil && errorResp.Code != "" {
+			return nil, &apiError{ErrorResponse: errorResp}
+		}
+		return nil, fmt.Errorf("HTTP %d error: %s", resp.StatusCode, c.redact(string(body)))
+	}
+
+	var authResp AuthResponse
+	if err := json.Unmarshal(body, &authResp); err != nil {
+		return nil, fmt.Errorf("failed to parse auth response: %v", err)
+	}
+	return &authResp, nil
+}
+
+// apiError is a structured error response of the API.
+type apiError struct {
+	ErrorResponse
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("API error [%s]: %s", e.Code, e.Message)
+}
+
+// mfaRequired reports whether a login failed only because the account needs
+// a multi-factor authentication one-time code.
+func (e *apiError) mfaRequired() bool {
+	message := strings.ToLower(e.Message)
+	return strings.Contains(message, "mfa") || strings.Contains(message, "one-time") || strings.Contains(message, "otp")
+}
+
+func (c *Client) setCredentials(apiKey, token string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.apiKey = apiKey
+	c.token = token
+}
+
+func (c *Client) credentials() (apiKey, token string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.apiKey, c.token
+}
+
+// redact masks the credentials in text for debug output or an error message,
+// unless unsafe debugging was requested.
+func (c *Client) redact(text string) string {
+	if c.unsafeDebug {
+		return text
+	}
+	return RedactText(text)
+}
+
+// redactSecret masks a credential value for debug output. Empty values are
+// kept so the output still shows which credentials are configured.
+func (c *Client) redactSecret(value string) string {
+	if c.unsafeDebug || value == "" {
+		return value
+	}
+	return Redacted
+}
+
+// apiURL returns the absolute URL of an API path such as "/v1/analysis/schemas".
+func (c *Client) apiURL(path string) string {
+	scheme := c.scheme
+	if scheme == "" {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s%s", scheme, c.baseURL, path)
+}
+
+func (c *Client) makeRequest(ctx context.Context, method, url string, payload interface{}) ([]byte, error) {
+	return c.makeRequestWithHeaders(ctx, method, url, payload, nil)
+}
+
+// makeRequestWithHeaders sends an authenticated API request. The extra
+// headers are applied after the profile's custom headers. Transient failures
+// are retried as described by Options.Retries. If the API key and token have
+// expired, it logs in again and retries the request once.
+func (c *Client) makeRequestWithHeaders(ctx context.Context, method, url string, payload interface{}, headers map[string]string) ([]byte, error) {
+	var payloadBytes []byte
+	if payload != nil {
+		var err error
+		payloadBytes, err = json.Marshal(payload)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal payload: %v", err)
+		}
+		c.log().Debug("Request payload", "payload", c.redact(string(payloadBytes)))
+	}
+
+	resp, err := c.sendWithRetry(ctx, method, url, payloadBytes, headers)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.status == http.StatusUnauthorized && c.config != nil && c.config.hasCredentials() {
+		c.log().Info("API key and token rejected, logging in again")
+		c.tokenCache.remove(c.config)
+		if err := c.authenticate(ctx); err != nil {
+			return nil, fmt.Errorf("re-authentication failed: %v", err)
+		}
+		if resp, err = c.sendWithRetry(ctx, method, url, payloadBytes, headers); err != nil {
+			return nil, err
+		}
+	}
+
+	// Check for HTTP error status codes
+	if resp.status >= 400 {
+		var errorResp ErrorResponse
+		if err := json.Unmarshal(resp.body, &errorResp); err == nil && errorResp.Code != "" {
+			return nil, fmt.Errorf("API error [%s]: %s", errorResp.Code, errorResp.Message)
+		}
+		return nil, fmt.Errorf("HTTP %d error: %s", resp.status, c.redact(string(resp.body)))
+	}
+
+	return resp.body, nil
+}
+
+// apiResponse is the status, headers and body of an API response.
+type apiResponse struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+// send issues one authenticated request. Failures to get a complete
+// response are returned as a *transportError.
+func (c *Client) send(ctx context.Context, method, url string, payloadBytes []byte, headers map[string]string) (*apiResponse, error) {
+	var body io.Reader
+	if payloadBytes != nil {
+		body = bytes.NewReader(payloadBytes)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+
+	apiKey, token := c.credentials()
+	req.Header.Set("x-soracom-api-key", apiKey)
+	req.Header.Set("x-soracom-token", token)
+
+	// Add custom headers from profile
+	for key, value := range c.customHeaders {
+		req.Header.Set(key, value)
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	if payloadBytes != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	start := time.Now()
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		c.logHTTP(ctx, method, url, 0, start, err)
+		return nil, &transportError{err: err, message: "request failed: " + c.redact(err.Error())}
+	}
+	defer resp.Body.Close()
+
+	responseBody, err := io.ReadAll(resp.Body)
+	c.logHTTP(ctx, method, url, resp.StatusCode, start, err)
+	if err != nil {
+		return nil, &transportError{err: err, message: "failed to read response: " + c.redact(err.Error())}
+	}
+
+	c.log().Debug("Response body", "body", c.redact(string(responseBody)))
+
+	return &apiResponse{status: resp.StatusCode, header: resp.Header, body: responseBody}, nil
+}
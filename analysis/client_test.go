@@ -0,0 +1,259 @@
+package analysis
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestClient_MakeRequest(t *testing.T) {
+	// Create a test server
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Check headers
+		if r.Header.Get("x-soracom-api-key") != "test-api-key" {
+			t.Errorf("Missing or incorrect x-soracom-api-key header")
+		}
+		if r.Header.Get("x-soracom-token") != "test-token" {
+			t.Errorf("Missing or incorrect x-soracom-token header")
+		}
+		if r.Header.Get("x-test-header") != "test-value" {
+			t.Errorf("Missing or incorrect x-test-header")
+		}
+
+		// Return test response
+		response := map[string]string{"status": "success"}
+		json.NewEncoder(w).Encode(response)
+	}))
+	defer server.Close()
+
+	client := &Client{
+		httpClient:    &http.Client{},
+		apiKey:        "test-api-key",
+		token:         "test-token",
+		customHeaders: map[string]string{"x-test-header": "test-value"},
+	}
+
+	body, err := client.makeRequest(context.Background(), "GET", server.URL, nil)
+	if err != nil {
+		t.Errorf("makeRequest() error = %v", err)
+		return
+	}
+
+	var response map[string]string
+	if err := json.Unmarshal(body, &response); err != nil {
+		t.Errorf("Failed to unmarshal response: %v", err)
+		return
+	}
+
+	if response["status"] != "success" {
+		t.Errorf("makeRequest() response status = %v, want success", response["status"])
+	}
+}
+
+func TestClient_MakeRequestWithPayload(t *testing.T) {
+	// Create a test server that expects JSON payload
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			t.Errorf("Expected POST method, got %s", r.Method)
+		}
+
+		if r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("Expected Content-Type application/json, got %s", r.Header.Get("Content-Type"))
+		}
+
+		// Read and verify payload
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("Failed to read request body: %v", err)
+		}
+
+		var payload map[string]string
+		if err := json.Unmarshal(body, &payload); err != nil {
+			t.Errorf("Failed to unmarshal request payload: %v", err)
+		}
+
+		if payload["sql"] != "SELECT 1" {
+			t.Errorf("Expected SQL payload 'SELECT 1', got %s", payload["sql"])
+		}
+
+		// Return test response
+		response := map[string]string{"queryId": "test-query-id"}
+		json.NewEncoder(w).Encode(response)
+	}))
+	defer server.Close()
+
+	client := &Client{
+		httpClient:    &http.Client{},
+		apiKey:        "test-api-key",
+		token:         "test-token",
+		customHeaders: map[string]string{"x-test-header": "test-value"},
+	}
+
+	payload := map[string]string{"sql": "SELECT 1"}
+	body, err := client.makeRequest(context.Background(), "POST", server.URL, payload)
+	if err != nil {
+		t.Errorf("makeRequest() error = %v", err)
+		return
+	}
+
+	var response map[string]string
+	if err := json.Unmarshal(body, &response); err != nil {
+		t.Errorf("Failed to unmarshal response: %v", err)
+		return
+	}
+
+	if response["queryId"] != "test-query-id" {
+		t.Errorf("makeRequest() response queryId = %v, want test-query-id", response["queryId"])
+	}
+}
+
+func TestErrorResponseHandling(t *testing.T) {
+	// Test server that returns HTTP 400 with error response
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		errorResp := ErrorResponse{
+			Code:    "ANA0005",
+			Message: "SQL compilation error: invalid identifier 'TIMESTAMP'",
+		}
+		json.NewEncoder(w).Encode(errorResp)
+	}))
+	defer server.Close()
+
+	client := &Client{
+		httpClient:    &http.Client{},
+		apiKey:        "test-api-key",
+		token:         "test-token",
+		customHeaders: map[string]string{"x-test-header": "test-value"},
+	}
+
+	_, err := client.makeRequest(context.Background(), "GET", server.URL, nil)
+	if err == nil {
+		t.Error("Expected error for HTTP 400 response, got nil")
+	}
+
+	expectedError := "API error [ANA0005]: SQL compilation error: invalid identifier 'TIMESTAMP'"
+	if err.Error() != expectedError {
+		t.Errorf("Expected error message '%s', got '%s'", expectedError, err.Error())
+	}
+}
+
+func TestHTTPErrorHandling(t *testing.T) {
+	// Test server that returns HTTP 500 without structured error
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Internal Server Error"))
+	}))
+	defer server.Close()
+
+	client := &Client{
+		httpClient:    &http.Client{},
+		apiKey:        "test-api-key",
+		token:         "test-token",
+		customHeaders: map[string]string{"x-test-header": "test-value"},
+	}
+
+	_, err := client.makeRequest(context.Background(), "GET", server.URL, nil)
+	if err == nil {
+		t.Error("Expected error for HTTP 500 response, got nil")
+	}
+
+	expectedError := "HTTP 500 error: Internal Server Error"
+	if err.Error() != expectedError {
+		t.Errorf("Expected error message '%s', got '%s'", expectedError, err.Error())
+	}
+}
+
+func TestHTTPErrorRedacted(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte(`{"message":"upstream failed","token":"leaked-token"}`))
+	}))
+	defer server.Close()
+
+	client := &Client{httpClient: &http.Client{}}
+	_, err := client.makeRequest(context.Background(), "GET", server.URL, nil)
+	if err == nil || strings.Contains(err.Error(), "leaked-token") {
+		t.Errorf("makeRequest() error = %v, want the token masked", err)
+	}
+
+	client.unsafeDebug = true
+	_, err = client.makeRequest(context.Background(), "GET", server.URL, nil)
+	if err == nil || !strings.Contains(err.Error(), "leaked-token") {
+		t.Errorf("makeRequest() with unsafe debugging: error = %v, want the raw body", err)
+	}
+}
+
+func TestAuthResponseParsing(t *testing.T) {
+	jsonResponse := `{"apiKey": "test-api-key", "token": "test-token"}`
+
+	var authResp AuthResponse
+	err := json.Unmarshal([]byte(jsonResponse), &authResp)
+	if err != nil {
+		t.Errorf("Failed to unmarshal AuthResponse: %v", err)
+	}
+
+	if authResp.ApiKey != "test-api-key" {
+		t.Errorf("AuthResponse.ApiKey = %v, want test-api-key", authResp.ApiKey)
+	}
+
+	if authResp.Token != "test-token" {
+		t.Errorf("AuthResponse.Token = %v, want test-token", authResp.Token)
+	}
+}
+
+func TestErrorResponseParsing(t *testing.T) {
+	jsonResponse := `{"code": "ANA0005", "message": "SQL compilation error"}`
+
+	var errorResp ErrorResponse
+	err := json.Unmarshal([]byte(jsonResponse), &errorResp)
+	if err != nil {
+		t.Errorf("Failed to unmarshal ErrorResponse: %v", err)
+	}
+
+	if errorResp.Code != "ANA0005" {
+		t.Errorf("ErrorResponse.Code = %v, want ANA0005", errorResp.Code)
+	}
+
+	if errorResp.Message != "SQL compilation error" {
+		t.Errorf("ErrorResponse.Message = %v, want 'SQL compilation error'", errorResp.Message)
+	}
+}
+
+// Benchmark tests
+func BenchmarkAuthResponseParsing(b *testing.B) {
+	jsonData := `{"apiKey": "test-key", "token": "test-token"}`
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		var authResp AuthResponse
+		json.Unmarshal([]byte(jsonData), &authResp)
+	}
+}
+
+func TestNewWithAPIToken(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/auth" {
+			t.Error("New() logged in although an API key and token were given")
+		}
+		if r.Header.Get("x-soracom-api-key") != "given-key" || r.Header.Get("x-soracom-token") != "given-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	t.Setenv(EnvProfileDir, t.TempDir())
+	t.Setenv(EnvEndpoint, server.URL)
+	client, err := New(context.Background(), Options{APIKey: "given-key", APIToken: "given-token"})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if _, err := client.makeRequest(context.Background(), "GET", client.apiURL("/v1/analysis/schemas"), nil); err != nil {
+		t.Errorf("makeRequest() error = %v", err)
+	}
+}
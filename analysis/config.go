@@ -0,0 +1,230 @@
+package analysis
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Config holds the credentials and endpoint settings of a Soracom CLI profile.
+//
+// It supports three kinds of credentials: the email and password of the
+// root user, the operator ID, user name and password of a SAM user, and an
+// auth key. Instead of storing them, a profile can name a CredentialProcess,
+// a shell command that prints them as JSON with the same field names plus an
+// optional RFC 3339 "expiration". It replaces any stored credentials.
+//
+// The network settings from CACert to ReadTimeout are specific to soraql and
+// apply where the corresponding NetworkOptions are empty. The timeouts are
+// durations such as "10s".
+type Config struct {
+	Email              string            `json:"email"`
+	Password           string            `json:"password"`
+	OperatorId         string            `json:"operatorId"`
+	Username           string            `json:"username"`
+	AuthKeyId          string            `json:"authKeyId"`
+	AuthKey            string            `json:"authKey"`
+	CredentialProcess  string            `json:"credentialProcess"`
+	CoverageType       string            `json:"coverageType"`
+	Endpoint           string            `json:"endpoint"`
+	Headers            map[string]string `json:"headers"`
+	CACert             string            `json:"caCert"`
+	ClientCert         string            `json:"clientCert"`
+	ClientKey          string            `json:"clientKey"`
+	InsecureSkipVerify bool              `json:"insecureSkipVerify"`
+	Proxy              string            `json:"proxy"`
+	NoProxy            string            `json:"noProxy"`
+	ConnectTimeout     string            `json:"connectTimeout"`
+	ReadTimeout        string            `json:"readTimeout"`
+}
+
+// Environment variables that override profile settings.
+const (
+	EnvAuthKeyId    = "SORACOM_AUTH_KEY_ID"
+	EnvAuthKey      = "SORACOM_AUTH_KEY"
+	EnvEmail        = "SORACOM_EMAIL"
+	EnvOperatorId   = "SORACOM_OPERATOR_ID"
+	EnvUsername     = "SORACOM_USER_NAME"
+	EnvPassword     = "SORACOM_PASSWORD"
+	EnvCoverageType = "SORACOM_COVERAGE_TYPE"
+	EnvEndpoint     = "SORACOM_ENDPOINT"
+	EnvProfileDir   = "SORACOM_PROFILE_DIR"
+)
+
+// ProfileDir returns the directory holding the Soracom CLI profiles:
+// $SORACOM_PROFILE_DIR if set, otherwise ~/.soracom.
+func ProfileDir() string {
+	if dir := os.Getenv(EnvProfileDir); dir != "" {
+		return dir
+	}
+	return filepath.Join(os.Getenv("HOME"), ".soracom")
+}
+
+// ProfilePath returns the location of the named profile in ProfileDir.
+func ProfilePath(profile string) string {
+	return filepath.Join(ProfileDir(), profile+".json")
+}
+
+// ListProfiles returns the names of the profiles in ProfileDir, sorted. A
+// missing directory has no profiles.
+func ListProfiles() ([]string, error) {
+	entries, err := os.ReadDir(ProfileDir())
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var profiles []string
+	for _, entry := range entries {
+		if name, ok := strings.CutSuffix(entry.Name(), ".json"); ok && !entry.IsDir() {
+			profiles = append(profiles, name)
+		}
+	}
+	return profiles, nil
+}
+
+// LoadProfile reads the named Soracom CLI profile from ProfileDir.
+func LoadProfile(profile string) (*Config, error) {
+	configPath := ProfilePath(profile)
+
+	configFile, err := os.Open(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("config file '%s' not found: %w", configPath, err)
+	}
+	defer configFile.Close()
+
+	var config Config
+	if err := json.NewDecoder(configFile).Decode(&config); err != nil {
+		return nil, fmt.Errorf("failed to parse config file: %v", err)
+	}
+
+	return &config, nil
+}
+
+// LoadConfig returns the settings of the named profile with the SORACOM_*
+// environment variables applied on top. A complete set of credentials in the
+// environment (an auth key, a SAM user, or an email and password) replaces
+// the profile's credentials as a whole; SORACOM_COVERAGE_TYPE and
+// SORACOM_ENDPOINT replace single settings.
+//
+// An empty profile means "default". The default profile may be missing when
+// the environment supplies credentials, so containers and CI jobs need no
+// profile file; a profile that was asked for by name must exist.
+func LoadConfig(profile string) (*Config, error) {
+	explicit := profile != ""
+	if !explicit {
+		profile = "default"
+	}
+
+	config, err := LoadProfile(profile)
+	if err != nil {
+		if explicit || !envHasCredentials() || !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		config = &Config{}
+	}
+
+	config.applyEnv()
+	return config, nil
+}
+
+// envCredentials returns the credentials set in the environment, or nil if
+// it holds no complete set.
+func envCredentials() *Config {
+	env := &Config{
+		AuthKeyId:  os.Getenv(EnvAuthKeyId),
+		AuthKey:    os.Getenv(EnvAuthKey),
+		Email:      os.Getenv(EnvEmail),
+		OperatorId: os.Getenv(EnvOperatorId),
+		Username:   os.Getenv(EnvUsername),
+		Password:   os.Getenv(EnvPassword),
+	}
+	switch {
+	case env.AuthKeyId != "" && env.AuthKey != "":
+		return &Config{AuthKeyId: env.AuthKeyId, AuthKey: env.AuthKey}
+	case env.OperatorId != "" && env.Username != "" && env.Password != "":
+		return &Config{OperatorId: env.OperatorId, Username: env.Username, Password: env.Password}
+	case env.Email != "" && env.Password != "":
+		return &Config{Email: env.Email, Password: env.Password}
+	}
+	return nil
+}
+
+// envHasCredentials reports whether the environment holds a complete set of
+// credentials.
+func envHasCredentials() bool {
+	return envCredentials() != nil
+}
+
+// applyEnv overrides the settings of c with the SORACOM_* environment
+// variables.
+func (c *Config) applyEnv() {
+	if env := envCredentials(); env != nil {
+		c.Email, c.Password = env.Email, env.Password
+		c.OperatorId, c.Username = env.OperatorId, env.Username
+		c.AuthKeyId, c.AuthKey = env.AuthKeyId, env.AuthKey
+		c.CredentialProcess = ""
+	}
+	if coverageType := os.Getenv(EnvCoverageType); coverageType != "" {
+		c.CoverageType = coverageType
+	}
+	if endpoint := os.Getenv(EnvEndpoint); endpoint != "" {
+		c.Endpoint = endpoint
+	}
+}
+
+// hasCredentials reports whether c can log in to /v1/auth.
+func (c *Config) hasCredentials() bool {
+	return c.validate() == nil
+}
+
+// validate reports what is missing when c holds no complete set of
+// credentials.
+func (c *Config) validate() error {
+	switch {
+	case c.CredentialProcess != "",
+		c.Email != "" && c.Password != "",
+		c.OperatorId != "" && c.Username != "" && c.Password != "",
+		c.AuthKeyId != "" && c.AuthKey != "":
+		return nil
+	case c.AuthKeyId != "" || c.AuthKey != "":
+		return errors.New("authKeyId and authKey must both be set")
+	case c.OperatorId != "" || c.Username != "":
+		return errors.New("a SAM user needs operatorId, username and password")
+	case c.Email != "":
+		return errors.New("email is set but password is missing")
+	case c.Password != "":
+		return errors.New("password is set without an email, or an operatorId and username")
+	default:
+		return errors.New("no credentials: set authKeyId and authKey, email and password, operatorId, username and password, or credentialProcess")
+	}
+}
+
+// authPayload builds the /v1/auth request body. Email and password take
+// precedence over a SAM user, which takes precedence over an auth key.
+func (c *Config) authPayload() (map[string]interface{}, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+
+	payload := map[string]interface{}{"tokenTimeoutSeconds": int(tokenTimeout.Seconds())}
+	switch {
+	case c.Email != "" && c.Password != "":
+		payload["email"] = c.Email
+		payload["password"] = c.Password
+	case c.OperatorId != "" && c.Username != "" && c.Password != "":
+		payload["operatorId"] = c.OperatorId
+		payload["userName"] = c.Username
+		payload["password"] = c.Password
+	default:
+		payload["authKeyId"] = c.AuthKeyId
+		payload["authKey"] = c.AuthKey
+	}
+	return payload, nil
+}
@@ -0,0 +1,133 @@
+package analysis
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConfigParsing(t *testing.T) {
+	jsonConfig := `{
+		"email": "test@example.com",
+		"password": "testpass",
+		"authKeyId": "key123",
+		"authKey": "secret456"
+	}`
+
+	var config Config
+	err := json.Unmarshal([]byte(jsonConfig), &config)
+	if err != nil {
+		t.Errorf("Failed to unmarshal Config: %v", err)
+	}
+
+	if config.Email != "test@example.com" {
+		t.Errorf("Config.Email = %v, want test@example.com", config.Email)
+	}
+
+	if config.Password != "testpass" {
+		t.Errorf("Config.Password = %v, want testpass", config.Password)
+	}
+
+	if config.AuthKeyId != "key123" {
+		t.Errorf("Config.AuthKeyId = %v, want key123", config.AuthKeyId)
+	}
+
+	if config.AuthKey != "secret456" {
+		t.Errorf("Config.AuthKey = %v, want secret456", config.AuthKey)
+	}
+}
+
+func TestLoadConfigEnv(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv(EnvProfileDir, dir)
+	for _, name := range []string{EnvAuthKeyId, EnvAuthKey, EnvEmail, EnvPassword, EnvCoverageType, EnvEndpoint} {
+		t.Setenv(name, "")
+	}
+
+	if _, err := LoadConfig(""); err == nil {
+		t.Error("LoadConfig() without a profile or environment credentials should fail")
+	}
+
+	t.Setenv(EnvAuthKeyId, "keyId-env")
+	t.Setenv(EnvAuthKey, "secret-env")
+	config, err := LoadConfig("")
+	if err != nil {
+		t.Fatalf("LoadConfig() with environment credentials and no profile: %v", err)
+	}
+	if config.AuthKeyId != "keyId-env" || config.AuthKey != "secret-env" {
+		t.Errorf("LoadConfig() = %+v, want the environment credentials", config)
+	}
+	if _, err := LoadConfig("missing"); err == nil {
+		t.Error("LoadConfig() should fail for a named profile that does not exist")
+	}
+
+	profile := `{"email": "user@example.com", "password": "pw", "coverageType": "jp", "endpoint": "https://example.com"}`
+	if err := os.WriteFile(filepath.Join(dir, "ci.json"), []byte(profile), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(EnvCoverageType, "g")
+	config, err = LoadConfig("ci")
+	if err != nil {
+		t.Fatalf("LoadConfig(\"ci\") error = %v", err)
+	}
+	if config.Email != "" || config.Password != "" || config.AuthKeyId != "keyId-env" {
+		t.Errorf("environment credentials did not replace the profile's: %+v", config)
+	}
+	if config.CoverageType != "g" || config.Endpoint != "https://example.com" {
+		t.Errorf("LoadConfig() coverage %q, endpoint %q, want g from the environment and the profile endpoint", config.CoverageType, config.Endpoint)
+	}
+}
+
+func TestConfigAuthPayload(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  Config
+		want    map[string]interface{}
+		wantErr string
+	}{
+		{
+			name:   "SAM user",
+			config: Config{OperatorId: "OP0000000000", Username: "analyst", Password: "pw"},
+			want:   map[string]interface{}{"operatorId": "OP0000000000", "userName": "analyst", "password": "pw"},
+		},
+		{
+			name:   "email",
+			config: Config{Email: "user@example.com", Password: "pw", AuthKeyId: "keyId-1", AuthKey: "secret"},
+			want:   map[string]interface{}{"email": "user@example.com", "password": "pw"},
+		},
+		{
+			name:   "auth key",
+			config: Config{AuthKeyId: "keyId-1", AuthKey: "secret"},
+			want:   map[string]interface{}{"authKeyId": "keyId-1", "authKey": "secret"},
+		},
+		{name: "auth key without secret", config: Config{AuthKeyId: "keyId-1"}, wantErr: "authKey"},
+		{name: "SAM user without password", config: Config{OperatorId: "OP0000000000", Username: "analyst"}, wantErr: "operatorId, username and password"},
+		{name: "empty", config: Config{}, wantErr: "no credentials"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload, err := tt.config.authPayload()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("authPayload() error = %v, want it to mention %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("authPayload() error = %v", err)
+			}
+			delete(payload, "tokenTimeoutSeconds")
+			if len(payload) != len(tt.want) {
+				t.Errorf("authPayload() = %v, want %v", payload, tt.want)
+			}
+			for key, value := range tt.want {
+				if payload[key] != value {
+					t.Errorf("authPayload()[%q] = %v, want %v", key, payload[key], value)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package analysis
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// credentialProcessTimeout bounds how long a credential process may run
+// before it is killed.
+var credentialProcessTimeout = 30 * time.Second
+
+// maxCredentialProcessStderr is how much of a failed credential process's
+// stderr is quoted in the error.
+const maxCredentialProcessStderr = 1024
+
+// processCredentials is the JSON a credential process prints on stdout. It
+// holds one set of credentials, using the same fields as a profile, and may
+// say when they expire.
+type processCredentials struct {
+	Email      string     `json:"email"`
+	Password   string     `json:"password"`
+	OperatorId string     `json:"operatorId"`
+	Username   string     `json:"username"`
+	AuthKeyId  string     `json:"authKeyId"`
+	AuthKey    string     `json:"authKey"`
+	Expiration *time.Time `json:"expiration"`
+}
+
+// runCredentialProcess runs command with the shell and parses the
+// credentials it prints. expires is the zero time if the process did not
+// say when they expire.
+func runCredentialProcess(ctx context.Context, command string) (config *Config, expires time.Time, err error) {
+	name := credentialProcessName(command)
+
+	ctx, cancel := context.WithTimeout(ctx, credentialProcessTimeout)
+	defer cancel()
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "/bin/sh", "-c", command)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	// Don't wait forever for children of the shell that keep the pipes open
+	cmd.WaitDelay = time.Second
+
+	if err := cmd.Run(); err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, time.Time{}, fmt.Errorf("credential process %s timed out after %s", name, credentialProcessTimeout)
+		}
+		if ctx.Err() != nil {
+			return nil, time.Time{}, ctx.Err()
+		}
+		return nil, time.Time{}, fmt.Errorf("credential process %s failed: %v%s", name, err, stderrSuffix(stderr.Bytes()))
+	}
+
+	var creds processCredentials
+	if err := json.Unmarshal(stdout.Bytes(), &creds); err != nil {
+		return nil, time.Time{}, fmt.Errorf("credential process %s printed invalid JSON: %v%s", name, err, stderrSuffix(stderr.Bytes()))
+	}
+
+	config = &Config{
+		Email:      creds.Email,
+		Password:   creds.Password,
+		OperatorId: creds.OperatorId,
+		Username:   creds.Username,
+		AuthKeyId:  creds.AuthKeyId,
+		AuthKey:    creds.AuthKey,
+	}
+	if err := config.validate(); err != nil {
+		return nil, time.Time{}, fmt.Errorf("credential process %s printed incomplete credentials: %v", name, err)
+	}
+	if creds.Expiration != nil {
+		expires = *creds.Expiration
+		if !expires.After(time.Now()) {
+			return nil, time.Time{}, fmt.Errorf("credential process %s printed credentials that expired at %s", name, expires.Format(time.RFC3339))
+		}
+	}
+	return config, expires, nil
+}
+
+// credentialProcessName returns the program a credential process command
+// runs, for messages that should not repeat arguments which may be secret.
+func credentialProcessName(command string) string {
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return "\"\""
+	}
+	return fmt.Sprintf("%q", fields[0])
+}
+
+// stderrSuffix formats the end of a credential process's stderr for an error
+// message, with credentials masked.
+func stderrSuffix(stderr []byte) string {
+	text := strings.TrimSpace(string(stderr))
+	if text == "" {
+		return ""
+	}
+	if len(text) > maxCredentialProcessStderr {
+		text = "..." + text[len(text)-maxCredentialProcessStderr:]
+	}
+	return ": " + RedactText(text)
+}
+
+// loginConfig returns the credentials to log in with: those of the profile,
+// or those printed by its credential process. The process output is kept
+// until it expires, so logging in again does not rerun the process.
+func (c *Client) loginConfig(ctx context.Context) (*Config, error) {
+	if c.config.CredentialProcess == "" {
+		return c.config, nil
+	}
+
+	c.mu.Lock()
+	cached, expires := c.processConfig, c.processExpires
+	c.mu.Unlock()
+	if cached != nil && (expires.IsZero() || time.Now().Before(expires)) {
+		return cached, nil
+	}
+
+	c.log().Debug("Running credential process", "program", credentialProcessName(c.config.CredentialProcess))
+	config, expires, err := runCredentialProcess(ctx, c.config.CredentialProcess)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.processConfig, c.processExpires = config, expires
+	c.mu.Unlock()
+	return config, nil
+}
+
+// forgetProcessCredentials drops the cached output of the credential process
+// so the next login runs it again, e.g. after the credentials were rejected.
+func (c *Client) forgetProcessCredentials() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.processConfig = nil
+}
@@ -0,0 +1,101 @@
+package analysis
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunCredentialProcess(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("credential process tests use /bin/sh")
+	}
+
+	config, expires, err := runCredentialProcess(context.Background(), `echo '{"authKeyId":"keyId-1","authKey":"secret","expiration":"2099-01-01T00:00:00Z"}'`)
+	if err != nil {
+		t.Fatalf("runCredentialProcess() error = %v", err)
+	}
+	if config.AuthKeyId != "keyId-1" || config.AuthKey != "secret" || expires.Year() != 2099 {
+		t.Errorf("runCredentialProcess() = %+v, %v", config, expires)
+	}
+
+	tests := []struct {
+		name    string
+		command string
+		wantErr string
+	}{
+		{"failure", `echo "vault: permission denied" >&2; exit 3`, "exit status 3: vault: permission denied"},
+		{"invalid JSON", `echo not json`, "invalid JSON"},
+		{"incomplete", `echo '{"authKeyId":"keyId-1"}'`, "incomplete credentials"},
+		{"expired", `echo '{"authKeyId":"keyId-1","authKey":"secret","expiration":"2000-01-01T00:00:00Z"}'`, "expired"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := runCredentialProcess(context.Background(), tt.command)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("runCredentialProcess() error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	defer func(timeout time.Duration) { credentialProcessTimeout = timeout }(credentialProcessTimeout)
+	credentialProcessTimeout = 50 * time.Millisecond
+	if _, _, err := runCredentialProcess(context.Background(), "sleep 5"); err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("runCredentialProcess() error = %v, want a timeout", err)
+	}
+}
+
+func TestCredentialProcessLogin(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("credential process tests use /bin/sh")
+	}
+
+	logins := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&payload)
+		if payload["authKeyId"] != "keyId-vault" || payload["authKey"] != "secret-vault" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		logins++
+		json.NewEncoder(w).Encode(AuthResponse{ApiKey: "api-key", Token: "token"})
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	countFile := filepath.Join(dir, "runs")
+	command := `echo run >> ` + countFile + `; echo '{"authKeyId":"keyId-vault","authKey":"secret-vault"}'`
+	cache := &TokenCache{Dir: dir}
+	opts := Options{Config: &Config{Endpoint: server.URL, CredentialProcess: command}, TokenCache: cache}
+
+	client, err := New(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if err := client.authenticate(context.Background()); err != nil {
+		t.Fatalf("authenticate() error = %v", err)
+	}
+	opts.Config = &Config{Endpoint: server.URL, CredentialProcess: command}
+	if _, err := New(context.Background(), opts); err != nil {
+		t.Fatalf("New() with a cached token: error = %v", err)
+	}
+
+	if logins != 2 {
+		t.Errorf("logins = %d, want 2", logins)
+	}
+	runs, err := os.ReadFile(countFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(runs), "run"); n != 1 {
+		t.Errorf("credential process ran %d times, want 1", n)
+	}
+}
@@ -0,0 +1,267 @@
+package analysis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultDownloadRetries is how many times an interrupted result download is
+// resumed before giving up.
+const DefaultDownloadRetries = 3
+
+// downloadRetryDelay is the delay before the first download retry. It
+// doubles, with jitter, for every further retry up to maxRetryDelay.
+var downloadRetryDelay = time.Second
+
+// DownloadOptions tunes how query results are downloaded.
+type DownloadOptions struct {
+	// Retries is how many times a failed or interrupted download is retried,
+	// resuming where it stopped. Zero means DefaultDownloadRetries; a
+	// negative value disables retries.
+	Retries int
+
+	// MaxSize aborts downloads of more than this many (compressed) bytes.
+	// Zero means no limit.
+	MaxSize int64
+
+	// OnProgress, if set, is called as the download advances with the
+	// number of bytes received so far and the total size, or -1 when the
+	// server did not report it.
+	OnProgress func(downloaded, total int64)
+}
+
+func (o *DownloadOptions) settings() (retries int, maxSize int64, onProgress func(int64, int64)) {
+	if o == nil {
+		return DefaultDownloadRetries, 0, nil
+	}
+	retries = o.Retries
+	if retries == 0 {
+		retries = DefaultDownloadRetries
+	} else if retries < 0 {
+		retries = 0
+	}
+	return retries, o.MaxSize, o.OnProgress
+}
+
+// download is the compressed body of a result download. Reads that fail
+// because of a transient network or server error transparently re-request
+// the rest of the file with a Range header.
+type download struct {
+	ctx        context.Context
+	client     *Client
+	url        string
+	body       io.ReadCloser
+	offset     int64 // Bytes delivered so far
+	total      int64 // Full size, or -1 if unknown
+	retries    int
+	maxSize    int64
+	onProgress func(downloaded, total int64)
+}
+
+// openDownload starts downloading url, retrying transient failures.
+func (c *Client) openDownload(ctx context.Context, url string, opts *DownloadOptions) (*download, error) {
+	retries, maxSize, onProgress := opts.settings()
+	d := &download{
+		ctx:        ctx,
+		client:     c,
+		url:        url,
+		total:      -1,
+		retries:    retries,
+		maxSize:    maxSize,
+		onProgress: onProgress,
+	}
+
+	if err := d.resume(nil); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Read implements io.Reader, resuming the download when it is interrupted.
+func (d *download) Read(p []byte) (int, error) {
+	for {
+		n, err := d.body.Read(p)
+		d.offset += int64(n)
+		if n > 0 {
+			if d.maxSize > 0 && d.offset > d.maxSize {
+				return 0, fmt.Errorf("download exceeds the maximum download size of %d bytes", d.maxSize)
+			}
+			if d.onProgress != nil {
+				d.onProgress(d.offset, d.total)
+			}
+		}
+
+		if err == nil || err == io.EOF {
+			return n, err
+		}
+		if n > 0 {
+			// Deliver what arrived and report the error on the next read
+			return n, nil
+		}
+
+		d.body.Close()
+		if rerr := d.resume(err); rerr != nil {
+			return 0, rerr
+		}
+	}
+}
+
+// Close releases the current response body.
+func (d *download) Close() error {
+	if d.body == nil {
+		return nil
+	}
+	return d.body.Close()
+}
+
+// resume (re)opens the download at the current offset. lastErr is the error
+// that interrupted the previous attempt, or nil for the first request.
+func (d *download) resume(lastErr error) error {
+	for attempt := 0; ; attempt++ {
+		if lastErr != nil {
+			if !isTransientDownloadError(d.ctx, lastErr) || d.retries == 0 {
+				return fmt.Errorf("failed to download file: %s", d.client.redact(lastErr.Error()))
+			}
+			d.retries--
+
+			delay := jitter(backoff(downloadRetryDelay, attempt))
+			d.client.log().Warn("Download interrupted, retrying",
+				"received", d.offset,
+				"error", d.client.redact(lastErr.Error()),
+				"delay", delay.Round(time.Millisecond))
+			if err := sleepContext(d.ctx, delay); err != nil {
+				return err
+			}
+		}
+
+		lastErr = d.request()
+		if lastErr == nil {
+			return nil
+		}
+	}
+}
+
+// request issues one GET for the bytes from the current offset onwards.
+func (d *download) request() error {
+	req, err := http.NewRequestWithContext(d.ctx, "GET", d.url, nil)
+	if err != nil {
+		return err
+	}
+	if d.offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", d.offset))
+	}
+
+	start := time.Now()
+	resp, err := d.client.httpClient.Do(req)
+	if err != nil {
+		d.client.logHTTP(d.ctx, req.Method, d.url, 0, start, err)
+		return err
+	}
+	d.client.logHTTP(d.ctx, req.Method, d.url, resp.StatusCode, start, nil)
+
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && d.offset > 0:
+		start, total, ok := parseContentRange(resp.Header.Get("Content-Range"))
+		if !ok || start != d.offset {
+			resp.Body.Close()
+			return &permanentError{fmt.Errorf("unexpected Content-Range %q when resuming at byte %d", resp.Header.Get("Content-Range"), d.offset)}
+		}
+		d.total = total
+	case resp.StatusCode == http.StatusOK:
+		d.total = resp.ContentLength
+		if d.offset > 0 {
+			// The server ignored the Range header: skip what we already have
+			if _, err := io.CopyN(io.Discard, resp.Body, d.offset); err != nil {
+				resp.Body.Close()
+				return err
+			}
+		}
+	default:
+		snippet, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		resp.Body.Close()
+		return &downloadStatusError{code: resp.StatusCode, body: strings.TrimSpace(string(snippet))}
+	}
+
+	if d.maxSize > 0 && d.total > d.maxSize {
+		resp.Body.Close()
+		return &permanentError{fmt.Errorf("result is %d bytes, more than the maximum download size of %d bytes", d.total, d.maxSize)}
+	}
+
+	d.client.log().Debug("Downloading results", "received", d.offset, "total", d.total)
+
+	d.body = resp.Body
+	return nil
+}
+
+// downloadStatusError is an HTTP error response to a download request.
+type downloadStatusError struct {
+	code int
+	body string
+}
+
+func (e *downloadStatusError) Error() string {
+	if e.body == "" {
+		return fmt.Sprintf("HTTP %d", e.code)
+	}
+	return fmt.Sprintf("HTTP %d: %s", e.code, e.body)
+}
+
+// permanentError is a download failure that retrying cannot fix.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// isTransientDownloadError reports whether a download failure is worth
+// retrying: network errors, truncated bodies, 408, 429 and 5xx responses.
+func isTransientDownloadError(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+
+	var statusErr *downloadStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.code == http.StatusRequestTimeout || statusErr.code == http.StatusTooManyRequests || statusErr.code >= 500
+	}
+
+	var permanent *permanentError
+	return !errors.As(err, &permanent)
+}
+
+// parseContentRange parses a "bytes start-end/total" header. total is -1 when
+// the server reports it as "*".
+func parseContentRange(header string) (start, total int64, ok bool) {
+	rangeSpec, found := strings.CutPrefix(header, "bytes ")
+	if !found {
+		return 0, 0, false
+	}
+	span, size, found := strings.Cut(rangeSpec, "/")
+	if !found {
+		return 0, 0, false
+	}
+	first, _, found := strings.Cut(span, "-")
+	if !found {
+		return 0, 0, false
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	if size == "*" {
+		return start, -1, true
+	}
+	total, err = strconv.ParseInt(size, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return start, total, true
+}
@@ -0,0 +1,121 @@
+package analysis
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func gzipJSONL(t *testing.T, lines int) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	for i := 0; i < lines; i++ {
+		fmt.Fprintf(gz, "{\"ICCID\": \"89811000000000%05d\", \"COUNT\": %d}\n", i, i)
+	}
+	gz.Close()
+	return buf.Bytes()
+}
+
+func TestOpenResultsResumesInterruptedDownload(t *testing.T) {
+	downloadRetryDelay = time.Millisecond
+	defer func() { downloadRetryDelay = time.Second }()
+
+	payload := gzipJSONL(t, 1000)
+	var ranges []string
+	results := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		if len(ranges) == 1 {
+			// Promise the whole file but drop the connection half way
+			w.Header().Set("Content-Length", fmt.Sprint(len(payload)))
+			w.Write(payload[:len(payload)/2])
+			return
+		}
+		http.ServeContent(w, r, "result.jsonl.gz", time.Time{}, bytes.NewReader(payload))
+	}))
+	defer results.Close()
+
+	client := &Client{httpClient: results.Client()}
+	var progress int64
+	rows, err := client.Fetch(context.Background(), &QueryStatusResponse{URL: results.URL + "/result.jsonl.gz"}, &DownloadOptions{
+		OnProgress: func(downloaded, total int64) { progress = downloaded },
+	})
+	if err != nil {
+		t.Fatalf("Fetch() error = %v", err)
+	}
+	defer rows.Close()
+
+	count := 0
+	for rows.Next() {
+		count++
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("Rows.Err() = %v", err)
+	}
+	if count != 1000 {
+		t.Errorf("Fetch() yielded %d rows, want 1000", count)
+	}
+	if len(ranges) != 2 || ranges[1] != fmt.Sprintf("bytes=%d-", len(payload)/2) {
+		t.Errorf("download requests used ranges %q, want a resume from the middle", ranges)
+	}
+	if progress != int64(len(payload)) {
+		t.Errorf("OnProgress last reported %d bytes, want %d", progress, len(payload))
+	}
+}
+
+func TestOpenResultsHTTPError(t *testing.T) {
+	results := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		io.WriteString(w, "<Error><Code>AccessDenied</Code></Error>")
+	}))
+	defer results.Close()
+
+	client := &Client{httpClient: results.Client()}
+	_, err := client.OpenResults(context.Background(), &QueryStatusResponse{URL: results.URL + "/result.jsonl.gz"}, nil)
+	if err == nil || !strings.Contains(err.Error(), "HTTP 403") {
+		t.Errorf("OpenResults() error = %v, want an HTTP 403 error", err)
+	}
+}
+
+func TestOpenResultsMaxSize(t *testing.T) {
+	payload := gzipJSONL(t, 1000)
+	results := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(payload)
+	}))
+	defer results.Close()
+
+	client := &Client{httpClient: results.Client()}
+	_, err := client.OpenResults(context.Background(), &QueryStatusResponse{URL: results.URL + "/result.jsonl.gz"}, &DownloadOptions{MaxSize: 100})
+	if err == nil || !strings.Contains(err.Error(), "maximum download size") {
+		t.Errorf("OpenResults() error = %v, want a size limit error", err)
+	}
+}
+
+func TestParseContentRange(t *testing.T) {
+	testCases := []struct {
+		header string
+		start  int64
+		total  int64
+		ok     bool
+	}{
+		{"bytes 100-199/200", 100, 200, true},
+		{"bytes 0-99/*", 0, -1, true},
+		{"bytes */200", 0, 0, false},
+		{"items 0-1/2", 0, 0, false},
+	}
+
+	for _, tc := range testCases {
+		start, total, ok := parseContentRange(tc.header)
+		if start != tc.start || total != tc.total || ok != tc.ok {
+			t.Errorf("parseContentRange(%q) = %d, %d, %v, want %d, %d, %v", tc.header, start, total, ok, tc.start, tc.total, tc.ok)
+		}
+	}
+}
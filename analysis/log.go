@@ -0,0 +1,62 @@
+package analysis
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+)
+
+var (
+	// debugLogger is used when Options.Debug is set without a Logger.
+	debugLogger = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	// discardLogger drops every record. Its level is above every level in
+	// use, so records are not even formatted.
+	discardLogger = slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{Level: slog.LevelError + 1}))
+)
+
+// log returns the logger for diagnostics.
+func (c *Client) log() *slog.Logger {
+	if c.logger != nil {
+		return c.logger
+	}
+	if c.debug.Load() {
+		return debugLogger
+	}
+	return discardLogger
+}
+
+type queryIDKey struct{}
+
+// WithQueryID tags ctx with the query that the requests made with it belong
+// to, so that they are logged with its ID. Query, Wait and Cancel do this
+// themselves; use it for OpenResults and Fetch.
+func WithQueryID(ctx context.Context, queryID string) context.Context {
+	return context.WithValue(ctx, queryIDKey{}, queryID)
+}
+
+// logHTTP logs one HTTP exchange with its method, URL, status, latency and,
+// if ctx carries one, query ID. status is 0 and err is set when no response
+// arrived.
+func (c *Client) logHTTP(ctx context.Context, method, url string, status int, start time.Time, err error) {
+	logger := c.log()
+	if !logger.Enabled(ctx, slog.LevelDebug) {
+		return
+	}
+
+	attrs := []slog.Attr{
+		slog.String("method", method),
+		slog.String("url", c.redact(url)),
+		slog.Int("status", status),
+		slog.Duration("latency", time.Since(start)),
+	}
+	if queryID, ok := ctx.Value(queryIDKey{}).(string); ok {
+		attrs = append(attrs, slog.String("queryId", queryID))
+	}
+	if err != nil {
+		attrs = append(attrs, slog.String("error", c.redact(err.Error())))
+	}
+	logger.LogAttrs(ctx, slog.LevelDebug, "HTTP request", attrs...)
+}
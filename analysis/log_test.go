@@ -0,0 +1,86 @@
+package analysis
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLogHTTP(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status":"RUNNING","token":"secret-token"}`))
+	}))
+	defer server.Close()
+
+	var logs bytes.Buffer
+	client := &Client{
+		httpClient: &http.Client{},
+		logger:     slog.New(slog.NewJSONHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug})),
+	}
+
+	ctx := WithQueryID(context.Background(), "query-1")
+	if _, err := client.makeRequest(ctx, "GET", server.URL+"/v1/analysis/queries/query-1", nil); err != nil {
+		t.Fatalf("makeRequest() error = %v", err)
+	}
+
+	if strings.Contains(logs.String(), "secret-token") {
+		t.Errorf("log contains an unmasked token: %s", logs.String())
+	}
+
+	var record map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		var r map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &r); err != nil {
+			t.Fatalf("invalid log line %q: %v", line, err)
+		}
+		if r["msg"] == "HTTP request" {
+			record = r
+		}
+	}
+	if record == nil {
+		t.Fatalf("no HTTP request record in %s", logs.String())
+	}
+
+	if record["method"] != "GET" || record["status"] != float64(200) || record["queryId"] != "query-1" {
+		t.Errorf("HTTP request record = %v, want GET, 200 and query-1", record)
+	}
+	if url, _ := record["url"].(string); !strings.HasSuffix(url, "/v1/analysis/queries/query-1") {
+		t.Errorf("HTTP request url = %v", record["url"])
+	}
+	if _, ok := record["latency"]; !ok {
+		t.Errorf("HTTP request record has no latency: %v", record)
+	}
+}
+
+func TestNoLoggerDiscards(t *testing.T) {
+	client := &Client{}
+	if client.log().Enabled(context.Background(), slog.LevelError) {
+		t.Error("a Client without Logger or Debug logs errors")
+	}
+	client.SetDebug(true)
+	if !client.log().Enabled(context.Background(), slog.LevelDebug) {
+		t.Error("a Client with Debug does not log debug records")
+	}
+}
+
+// TestSetDebugWhileLogging is meant for go test -race: .debug toggles
+// debug logging while queries log from other goroutines.
+func TestSetDebugWhileLogging(t *testing.T) {
+	client := &Client{}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			client.log().Enabled(context.Background(), slog.LevelDebug)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		client.SetDebug(i%2 == 0)
+	}
+	<-done
+}
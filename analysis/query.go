@@ -0,0 +1,348 @@
+package analysis
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"path"
+	"strings"
+	"time"
+)
+
+// QueryResponse is the body returned when a query is submitted.
+type QueryResponse struct {
+	QueryId string `json:"queryId"`
+}
+
+// ColumnInfo describes one column of a query result.
+type ColumnInfo struct {
+	Name         string `json:"name"`
+	Type         string `json:"type"`
+	DatabaseType string `json:"databaseType"`
+}
+
+// QueryStatusResponse is the body returned by /v1/analysis/queries/{queryId}.
+type QueryStatusResponse struct {
+	Status     string       `json:"status"`
+	URL        string       `json:"url"`
+	ColumnInfo []ColumnInfo `json:"columnInfo"`
+}
+
+// QueryEndedError is returned by Wait when a query stops without a result:
+// its status is FAILED or CANCELLED. The server is no longer running it.
+type QueryEndedError struct {
+	Status string // FAILED or CANCELLED
+	Body   string // Status response, with credentials redacted
+}
+
+func (e *QueryEndedError) Error() string {
+	if e.Status == "CANCELLED" {
+		return "query was cancelled"
+	}
+	return fmt.Sprintf("query failed: %s", e.Body)
+}
+
+// cancelTimeout bounds the best-effort cancellation of an abandoned query.
+const cancelTimeout = 10 * time.Second
+
+// Default polling settings used by Wait.
+const (
+	DefaultPollInterval    = 250 * time.Millisecond
+	DefaultMaxPollInterval = 10 * time.Second
+)
+
+// QueryOptions restricts the time window a query scans and tunes how Query
+// waits for it. Zero values leave the corresponding bound to the API default.
+type QueryOptions struct {
+	From int64 // Unix seconds
+	To   int64 // Unix seconds
+
+	// Wait is passed to Wait while the query runs.
+	Wait *WaitOptions
+
+	// Download is passed to Fetch once the query has completed.
+	Download *DownloadOptions
+}
+
+// WaitOptions tunes how Wait polls a query.
+type WaitOptions struct {
+	// Interval is the delay before the second status check. It doubles
+	// after every check. Defaults to DefaultPollInterval.
+	Interval time.Duration
+
+	// MaxInterval caps the delay between status checks. Defaults to
+	// DefaultMaxPollInterval.
+	MaxInterval time.Duration
+
+	// MaxWait gives up on the query after this long. Zero means wait until
+	// the context is done.
+	MaxWait time.Duration
+
+	// OnStatus, if set, is called with the query status every time it
+	// changes, starting with the first status check.
+	OnStatus func(status string)
+}
+
+func (o *WaitOptions) settings() (interval, maxInterval, maxWait time.Duration, onStatus func(string)) {
+	interval, maxInterval = DefaultPollInterval, DefaultMaxPollInterval
+	if o == nil {
+		return interval, maxInterval, 0, nil
+	}
+	if o.Interval > 0 {
+		interval = o.Interval
+	}
+	if o.MaxInterval > 0 {
+		maxInterval = o.MaxInterval
+	}
+	return min(interval, maxInterval), maxInterval, o.MaxWait, o.OnStatus
+}
+
+// Query submits sqlQuery, waits for it to complete and returns its rows.
+// The caller must close the returned Rows.
+func (c *Client) Query(ctx context.Context, sqlQuery string, opts *QueryOptions) (*Rows, error) {
+	queryID, err := c.Submit(ctx, sqlQuery, opts)
+	if err != nil {
+		return nil, err
+	}
+	ctx = WithQueryID(ctx, queryID)
+
+	var waitOpts *WaitOptions
+	var downloadOpts *DownloadOptions
+	if opts != nil {
+		waitOpts = opts.Wait
+		downloadOpts = opts.Download
+	}
+
+	status, err := c.Wait(ctx, queryID, waitOpts)
+	if err != nil {
+		if Abandoned(err) {
+			// Don't leave the abandoned query running in the warehouse
+			if cancelErr := c.CancelAbandoned(ctx, queryID); cancelErr != nil {
+				c.log().Warn("Failed to cancel query", "queryId", queryID, "error", cancelErr)
+			}
+		}
+		return nil, err
+	}
+
+	return c.Fetch(ctx, status, downloadOpts)
+}
+
+// Submit starts sqlQuery and returns its query ID without waiting for it.
+func (c *Client) Submit(ctx context.Context, sqlQuery string, opts *QueryOptions) (string, error) {
+	c.log().Debug("Submitting query", "sql", sqlQuery)
+
+	// Create payload with optional time parameters
+	payload := map[string]interface{}{"sql": sqlQuery}
+	if opts != nil {
+		if opts.From > 0 {
+			c.log().Debug("Query window start", "from", opts.From, "time", time.Unix(opts.From, 0).Format(time.RFC3339))
+			payload["from"] = opts.From
+		}
+		if opts.To > 0 {
+			c.log().Debug("Query window end", "to", opts.To, "time", time.Unix(opts.To, 0).Format(time.RFC3339))
+			payload["to"] = opts.To
+		}
+	}
+
+	body, err := c.makeRequest(ctx, "POST", c.apiURL("/v1/analysis/queries"), payload)
+	if err != nil {
+		return "", err
+	}
+
+	var queryResp QueryResponse
+	if err := json.Unmarshal(body, &queryResp); err != nil {
+		return "", fmt.Errorf("failed to parse query response: %v", err)
+	}
+
+	c.log().Info("Query submitted", "queryId", queryResp.QueryId)
+
+	return queryResp.QueryId, nil
+}
+
+// Status fetches the current state of a query.
+func (c *Client) Status(ctx context.Context, queryID string) (*QueryStatusResponse, error) {
+	statusResp, _, err := c.status(ctx, queryID)
+	return statusResp, err
+}
+
+// Cancel asks the API to stop a running query. A nil error means the server
+// accepted the cancellation.
+func (c *Client) Cancel(ctx context.Context, queryID string) error {
+	ctx = WithQueryID(ctx, queryID)
+	_, err := c.makeRequest(ctx, "DELETE", c.apiURL(fmt.Sprintf("/v1/analysis/queries/%s", queryID)), nil)
+	return err
+}
+
+// Abandoned reports whether a query whose Wait returned err may still be
+// running on the server: the wait was cancelled, timed out or failed to
+// check the status, rather than the query itself failing or being cancelled.
+func Abandoned(err error) bool {
+	var ended *QueryEndedError
+	return err != nil && !errors.As(err, &ended)
+}
+
+// CancelAbandoned cancels a query the caller has given up on. It is sent
+// even if ctx is already done, and waits at most 10 seconds for the server.
+func (c *Client) CancelAbandoned(ctx context.Context, queryID string) error {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), cancelTimeout)
+	defer cancel()
+	return c.Cancel(ctx, queryID)
+}
+
+// status fetches the state of a query along with the raw response body.
+func (c *Client) status(ctx context.Context, queryID string) (*QueryStatusResponse, []byte, error) {
+	ctx = WithQueryID(ctx, queryID)
+	body, err := c.makeRequest(ctx, "GET", c.apiURL(fmt.Sprintf("/v1/analysis/queries/%s?exportFormat=jsonl", queryID)), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var statusResp QueryStatusResponse
+	if err := json.Unmarshal(body, &statusResp); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse status response: %v", err)
+	}
+
+	return &statusResp, body, nil
+}
+
+// Wait polls a query until it completes, fails, is cancelled, or
+// opts.MaxWait elapses. A failed or cancelled query is a *QueryEndedError.
+// The delay between status checks starts at opts.Interval and doubles, with
+// jitter, up to opts.MaxInterval. A nil opts uses the defaults.
+func (c *Client) Wait(ctx context.Context, queryID string, opts *WaitOptions) (*QueryStatusResponse, error) {
+	interval, maxInterval, maxWait, onStatus := opts.settings()
+
+	start := time.Now()
+	var lastStatus string
+	for checks := 1; ; checks++ {
+		statusResp, body, err := c.status(ctx, queryID)
+		if err != nil {
+			return nil, err
+		}
+
+		c.log().Debug("Status check", "queryId", queryID, "check", checks, "status", statusResp.Status)
+
+		// Report status transitions
+		if statusResp.Status != lastStatus {
+			c.log().Debug("Query status changed",
+				"queryId", queryID,
+				"from", displayStatus(lastStatus),
+				"to", statusResp.Status,
+				"elapsed", time.Since(start).Round(time.Millisecond))
+			if onStatus != nil {
+				onStatus(statusResp.Status)
+			}
+			lastStatus = statusResp.Status
+		}
+
+		// Check if query is completed
+		if statusResp.Status == "COMPLETED" {
+			c.log().Info("Query completed", "queryId", queryID, "checks", checks, "elapsed", time.Since(start).Round(time.Millisecond))
+			return statusResp, nil
+		}
+
+		// FAILED and CANCELLED queries will never complete
+		if statusResp.Status == "FAILED" || statusResp.Status == "CANCELLED" {
+			return nil, &QueryEndedError{Status: statusResp.Status, Body: c.redact(string(body))}
+		}
+
+		// RUNNING, EXPORTING or any other status: back off and retry
+		elapsed := time.Since(start)
+		if maxWait > 0 && elapsed >= maxWait {
+			return nil, fmt.Errorf("query did not complete within %s, final status: %s", maxWait, statusResp.Status)
+		}
+		delay := jitter(interval)
+		if maxWait > 0 {
+			// Check one last time when the wait runs out rather than giving up early
+			delay = min(delay, maxWait-elapsed)
+		}
+		c.log().Debug("Waiting for query", "queryId", queryID, "status", statusResp.Status, "delay", delay.Round(time.Millisecond))
+
+		if err := sleepContext(ctx, delay); err != nil {
+			return nil, err
+		}
+		interval = min(interval*2, maxInterval)
+	}
+}
+
+// Fetch streams the results of a completed query and returns an iterator
+// over its rows. Rows are decoded as they are downloaded, so nothing is
+// written to disk and memory use does not grow with the result size. A nil
+// opts uses the default download settings. The caller must close the
+// returned Rows.
+func (c *Client) Fetch(ctx context.Context, status *QueryStatusResponse, opts *DownloadOptions) (*Rows, error) {
+	body, err := c.OpenResults(ctx, status, opts)
+	if err != nil {
+		return nil, err
+	}
+	return NewRows(body, status.ColumnInfo), nil
+}
+
+// OpenResults starts downloading the results of a completed query and
+// returns them as a stream of decompressed JSONL records. Interrupted
+// downloads are resumed as described by opts. The caller must close the
+// returned reader.
+func (c *Client) OpenResults(ctx context.Context, status *QueryStatusResponse, opts *DownloadOptions) (io.ReadCloser, error) {
+	c.log().Debug("Opening results", "file", ResultFileName(status))
+
+	body, err := c.openDownload(ctx, status.URL, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	gzReader, err := gzip.NewReader(body)
+	if err != nil {
+		body.Close()
+		return nil, fmt.Errorf("failed to decompress file: %v", err)
+	}
+
+	return &resultReader{Reader: gzReader, body: body}, nil
+}
+
+// ResultFileName returns the name of the decompressed result file of a
+// query, e.g. "0123456789abcdef.jsonl".
+func ResultFileName(status *QueryStatusResponse) string {
+	return strings.TrimSuffix(path.Base(strings.Split(status.URL, "?")[0]), ".gz")
+}
+
+// resultReader decompresses a result download and closes both the gzip
+// stream and the HTTP body.
+type resultReader struct {
+	*gzip.Reader
+	body io.ReadCloser
+}
+
+func (r *resultReader) Close() error {
+	r.Reader.Close()
+	return r.body.Close()
+}
+
+// jitter spreads a poll delay over [d/2, d] so many clients don't poll in lockstep.
+func jitter(d time.Duration) time.Duration {
+	return d/2 + rand.N(d/2+1)
+}
+
+// displayStatus names the state before the first status check.
+func displayStatus(status string) string {
+	if status == "" {
+		return "SUBMITTED"
+	}
+	return status
+}
+
+// sleepContext waits for d or until ctx is done, whichever comes first.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,370 @@
+package analysis
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQueryResponseParsing(t *testing.T) {
+	jsonResponse := `{"queryId": "test-123", "status": "submitted"}`
+
+	var queryResp QueryResponse
+	err := json.Unmarshal([]byte(jsonResponse), &queryResp)
+	if err != nil {
+		t.Errorf("Failed to unmarshal QueryResponse: %v", err)
+	}
+
+	if queryResp.QueryId != "test-123" {
+		t.Errorf("QueryResponse.QueryId = %v, want test-123", queryResp.QueryId)
+	}
+}
+
+func TestQueryStatusResponseParsing(t *testing.T) {
+	jsonResponse := `{"status": "COMPLETED", "url": "https://example.com/result.jsonl.gz"}`
+
+	var statusResp QueryStatusResponse
+	err := json.Unmarshal([]byte(jsonResponse), &statusResp)
+	if err != nil {
+		t.Errorf("Failed to unmarshal QueryStatusResponse: %v", err)
+	}
+
+	if statusResp.Status != "COMPLETED" {
+		t.Errorf("QueryStatusResponse.Status = %v, want COMPLETED", statusResp.Status)
+	}
+
+	if statusResp.URL != "https://example.com/result.jsonl.gz" {
+		t.Errorf("QueryStatusResponse.URL = %v, want https://example.com/result.jsonl.gz", statusResp.URL)
+	}
+}
+
+func TestQueryStatusHandling(t *testing.T) {
+	// Test different query status scenarios
+	testCases := []struct {
+		name     string
+		status   string
+		expected bool // whether it should be considered complete
+	}{
+		{"completed", "COMPLETED", true},
+		{"exporting", "EXPORTING", false},
+		{"running", "RUNNING", false},
+		{"failed", "FAILED", false},
+		{"unknown", "UNKNOWN_STATUS", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Test that we can parse status responses correctly
+			statusJSON := fmt.Sprintf(`{"status":"%s","columnInfo":[{"name":"SIM_ID","type":"string","databaseType":"TEXT"}]}`, tc.status)
+
+			var statusResp QueryStatusResponse
+			err := json.Unmarshal([]byte(statusJSON), &statusResp)
+			if err != nil {
+				t.Fatalf("Failed to parse status response: %v", err)
+			}
+
+			if statusResp.Status != tc.status {
+				t.Errorf("Expected status %s, got %s", tc.status, statusResp.Status)
+			}
+
+			// Test completion logic
+			isComplete := statusResp.Status == "COMPLETED"
+			if isComplete != tc.expected {
+				t.Errorf("Expected completion status %v for %s, got %v", tc.expected, tc.status, isComplete)
+			}
+		})
+	}
+}
+
+func BenchmarkJSONParsing(b *testing.B) {
+	jsonData := `{"queryId": "test-123", "status": "submitted"}`
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		var queryResp QueryResponse
+		json.Unmarshal([]byte(jsonData), &queryResp)
+	}
+}
+
+func TestClient_Query(t *testing.T) {
+	// Serve the gzipped JSONL result over plain HTTP like the export bucket
+	results := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gz := gzip.NewWriter(w)
+		gz.Write([]byte("{\"ICCID\": \"8981100000000000001\", \"COUNT\": 3}\n{\"ICCID\": \"8981100000000000002\", \"COUNT\": 5}\n"))
+		gz.Close()
+	}))
+	defer results.Close()
+
+	api := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/v1/auth":
+			json.NewEncoder(w).Encode(AuthResponse{ApiKey: "test-api-key", Token: "test-token"})
+		case r.URL.Path == "/v1/analysis/queries" && r.Method == "POST":
+			json.NewEncoder(w).Encode(QueryResponse{QueryId: "test-query-id"})
+		case r.URL.Path == "/v1/analysis/queries/test-query-id":
+			json.NewEncoder(w).Encode(QueryStatusResponse{
+				Status:     "COMPLETED",
+				URL:        results.URL + "/soraql-test-query.jsonl.gz?signature=abc",
+				ColumnInfo: []ColumnInfo{{Name: "ICCID", Type: "string"}, {Name: "COUNT", Type: "number"}},
+			})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer api.Close()
+
+	ctx := context.Background()
+	client, err := New(ctx, Options{
+		Config:     &Config{AuthKeyId: "keyId-test", AuthKey: "secret-test", Endpoint: api.URL},
+		HTTPClient: api.Client(),
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	rows, err := client.Query(ctx, "SELECT ICCID, COUNT FROM SIM_SNAPSHOTS", &QueryOptions{From: 1700000000})
+	if err != nil {
+		t.Fatalf("Query() error = %v", err)
+	}
+	defer rows.Close()
+
+	if len(rows.Columns) != 2 || rows.Columns[0].Name != "ICCID" {
+		t.Errorf("Query() columns = %v, want ICCID and COUNT", rows.Columns)
+	}
+
+	var iccids []string
+	for rows.Next() {
+		iccids = append(iccids, rows.Row()["ICCID"].(string))
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("Rows.Err() = %v", err)
+	}
+	if len(iccids) != 2 || iccids[1] != "8981100000000000002" {
+		t.Errorf("Query() rows = %v, want two ICCIDs", iccids)
+	}
+}
+
+func TestClient_WaitCancelled(t *testing.T) {
+	api := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(QueryStatusResponse{Status: "RUNNING"})
+	}))
+	defer api.Close()
+
+	client := &Client{
+		httpClient:    api.Client(),
+		baseURL:       strings.TrimPrefix(api.URL, "https://"),
+		customHeaders: map[string]string{},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := client.Wait(ctx, "test-query-id", nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Wait() error = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Wait() returned after %s, want it to stop at the deadline", elapsed)
+	}
+}
+
+func TestClient_QueryCancelsAbandonedQuery(t *testing.T) {
+	cancelled := make(chan string, 1)
+	api := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/v1/analysis/queries" && r.Method == "POST":
+			json.NewEncoder(w).Encode(QueryResponse{QueryId: "test-query-id"})
+		case r.Method == "DELETE":
+			cancelled <- r.URL.Path
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			json.NewEncoder(w).Encode(QueryStatusResponse{Status: "RUNNING"})
+		}
+	}))
+	defer api.Close()
+
+	client := &Client{
+		httpClient:    api.Client(),
+		baseURL:       strings.TrimPrefix(api.URL, "https://"),
+		customHeaders: map[string]string{},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if _, err := client.Query(ctx, "SELECT * FROM SIM_SESSION_EVENTS", nil); err == nil {
+		t.Fatal("Query() succeeded, want a deadline error")
+	}
+
+	select {
+	case path := <-cancelled:
+		if path != "/v1/analysis/queries/test-query-id" {
+			t.Errorf("cancellation sent to %s, want /v1/analysis/queries/test-query-id", path)
+		}
+	default:
+		t.Error("Query() did not cancel the abandoned query on the server")
+	}
+}
+
+func TestClient_CancelAbandonedAfterContextDone(t *testing.T) {
+	var method string
+	api := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method = r.Method
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer api.Close()
+
+	client := &Client{
+		httpClient:    api.Client(),
+		baseURL:       strings.TrimPrefix(api.URL, "https://"),
+		customHeaders: map[string]string{},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := client.CancelAbandoned(ctx, "test-query-id"); err != nil {
+		t.Fatalf("CancelAbandoned() error = %v", err)
+	}
+	if method != "DELETE" {
+		t.Errorf("CancelAbandoned() sent %q, want DELETE despite the cancelled context", method)
+	}
+}
+
+func TestClient_WaitReportsStatusTransitions(t *testing.T) {
+	statuses := []string{"QUEUED", "RUNNING", "RUNNING", "EXPORTING", "COMPLETED"}
+	checks := 0
+	api := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(QueryStatusResponse{Status: statuses[checks]})
+		checks++
+	}))
+	defer api.Close()
+
+	client := &Client{
+		httpClient:    api.Client(),
+		baseURL:       strings.TrimPrefix(api.URL, "https://"),
+		customHeaders: map[string]string{},
+	}
+
+	var seen []string
+	start := time.Now()
+	status, err := client.Wait(context.Background(), "test-query-id", &WaitOptions{
+		Interval:    time.Millisecond,
+		MaxInterval: 4 * time.Millisecond,
+		OnStatus:    func(status string) { seen = append(seen, status) },
+	})
+	if err != nil {
+		t.Fatalf("Wait() error = %v", err)
+	}
+	if status.Status != "COMPLETED" {
+		t.Errorf("Wait() status = %s, want COMPLETED", status.Status)
+	}
+	if got := strings.Join(seen, ","); got != "QUEUED,RUNNING,EXPORTING,COMPLETED" {
+		t.Errorf("OnStatus saw %s, want each transition once", got)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Wait() took %s with millisecond intervals", elapsed)
+	}
+}
+
+func TestClient_WaitMaxWait(t *testing.T) {
+	api := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(QueryStatusResponse{Status: "RUNNING"})
+	}))
+	defer api.Close()
+
+	client := &Client{
+		httpClient:    api.Client(),
+		baseURL:       strings.TrimPrefix(api.URL, "https://"),
+		customHeaders: map[string]string{},
+	}
+
+	_, err := client.Wait(context.Background(), "test-query-id", &WaitOptions{
+		Interval: 10 * time.Millisecond,
+		MaxWait:  50 * time.Millisecond,
+	})
+	if err == nil || !strings.Contains(err.Error(), "did not complete within 50ms, final status: RUNNING") {
+		t.Errorf("Wait() error = %v, want a max-wait error", err)
+	}
+}
+
+func TestClient_WaitMaxWaitChecksAtDeadline(t *testing.T) {
+	checks := 0
+	api := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		checks++
+		status := "RUNNING"
+		if checks > 1 {
+			status = "COMPLETED"
+		}
+		json.NewEncoder(w).Encode(QueryStatusResponse{Status: status})
+	}))
+	defer api.Close()
+
+	client := &Client{
+		httpClient:    api.Client(),
+		baseURL:       strings.TrimPrefix(api.URL, "https://"),
+		customHeaders: map[string]string{},
+	}
+
+	// The first delay is far longer than MaxWait, so only a check at the
+	// deadline can see the query complete
+	start := time.Now()
+	status, err := client.Wait(context.Background(), "test-query-id", &WaitOptions{
+		Interval:    time.Minute,
+		MaxInterval: time.Minute,
+		MaxWait:     50 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("Wait() error = %v, want the query to complete at the deadline", err)
+	}
+	if status.Status != "COMPLETED" {
+		t.Errorf("Wait() status = %s, want COMPLETED", status.Status)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Wait() returned after %s, want it to check again after MaxWait", elapsed)
+	}
+}
+
+func TestClient_WaitQueryEnded(t *testing.T) {
+	for _, status := range []string{"FAILED", "CANCELLED"} {
+		t.Run(status, func(t *testing.T) {
+			checks := 0
+			api := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				checks++
+				json.NewEncoder(w).Encode(QueryStatusResponse{Status: status})
+			}))
+			defer api.Close()
+
+			client := &Client{
+				httpClient:    api.Client(),
+				baseURL:       strings.TrimPrefix(api.URL, "https://"),
+				customHeaders: map[string]string{},
+			}
+
+			_, err := client.Wait(context.Background(), "test-query-id", &WaitOptions{MaxWait: time.Minute})
+			var ended *QueryEndedError
+			if !errors.As(err, &ended) || ended.Status != status {
+				t.Fatalf("Wait() error = %v, want a QueryEndedError with status %s", err, status)
+			}
+			if checks != 1 {
+				t.Errorf("Wait() checked the status %d times, want it to stop at the first %s", checks, status)
+			}
+		})
+	}
+}
+
+func TestJitter(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		if d := jitter(time.Second); d < 500*time.Millisecond || d > time.Second {
+			t.Fatalf("jitter(1s) = %s, want a value in [500ms, 1s]", d)
+		}
+	}
+}
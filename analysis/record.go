@@ -0,0 +1,226 @@
+package analysis
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"unicode/utf8"
+)
+
+// Exchange is one recorded HTTP request and its response. Credentials are
+// redacted before an exchange is written.
+type Exchange struct {
+	Method         string      `json:"method"`
+	URL            string      `json:"url"`
+	RequestHeader  http.Header `json:"requestHeader,omitempty"`
+	RequestBody    string      `json:"requestBody,omitempty"`
+	Status         int         `json:"status,omitempty"`
+	ResponseHeader http.Header `json:"responseHeader,omitempty"`
+	ResponseBody   string      `json:"responseBody,omitempty"`
+
+	// Base64 is set when ResponseBody is base64 encoded binary data, such as
+	// a gzip result download.
+	Base64 bool `json:"base64,omitempty"`
+
+	// Error is the transport error of a request that got no response.
+	Error string `json:"error,omitempty"`
+}
+
+// Recorder is an HTTPClient that passes requests to another HTTPClient and
+// writes every exchange as a line of JSON, with tokens, passwords, auth keys
+// and URL signatures redacted.
+type Recorder struct {
+	client HTTPClient
+	sink   *recordSink
+}
+
+// recordSink is where a Recorder writes. It is shared by the Recorders that
+// New derives from one without a client, so their lines never interleave.
+type recordSink struct {
+	mu sync.Mutex
+	w  *bufio.Writer
+}
+
+// NewRecorder returns a Recorder sending requests with client and writing the
+// exchanges to w. If client is nil, the Recorder can only be passed as
+// Options.HTTPClient: each New then records with the client it would use
+// without a Recorder, built from its own profile, into the same w.
+func NewRecorder(client HTTPClient, w io.Writer) *Recorder {
+	return &Recorder{client: client, sink: &recordSink{w: bufio.NewWriter(w)}}
+}
+
+// withClient returns a Recorder sending requests with client and writing to
+// the same sink as r.
+func (r *Recorder) withClient(client HTTPClient) *Recorder {
+	return &Recorder{client: client, sink: r.sink}
+}
+
+// Do implements HTTPClient. The exchange is written once the response body
+// has been read and closed.
+func (r *Recorder) Do(req *http.Request) (*http.Response, error) {
+	exchange := &Exchange{
+		Method:        req.Method,
+		URL:           RedactURL(req.URL.String()),
+		RequestHeader: RedactHeader(req.Header),
+	}
+
+	if req.Body != nil {
+		body, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		exchange.RequestBody = string(RedactJSON(body))
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		// A *url.Error holds the full URL, signature included
+		exchange.Error = RedactText(err.Error())
+		r.write(exchange)
+		return nil, err
+	}
+
+	exchange.Status = resp.StatusCode
+	exchange.ResponseHeader = RedactHeader(resp.Header)
+	resp.Body = &recordingBody{ReadCloser: resp.Body, exchange: exchange, recorder: r}
+	return resp, nil
+}
+
+func (r *Recorder) write(exchange *Exchange) error {
+	line, err := json.Marshal(exchange)
+	if err != nil {
+		return err
+	}
+
+	r.sink.mu.Lock()
+	defer r.sink.mu.Unlock()
+	r.sink.w.Write(line)
+	r.sink.w.WriteByte('\n')
+	return r.sink.w.Flush()
+}
+
+// recordingBody captures a response body as it is read and records the
+// exchange when the body is closed.
+type recordingBody struct {
+	io.ReadCloser
+	buf      bytes.Buffer
+	exchange *Exchange
+	recorder *Recorder
+	closed   bool
+}
+
+func (b *recordingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.buf.Write(p[:n])
+	return n, err
+}
+
+func (b *recordingBody) Close() error {
+	err := b.ReadCloser.Close()
+	if b.closed {
+		return err
+	}
+	b.closed = true
+
+	body := b.buf.Bytes()
+	if utf8.Valid(body) {
+		b.exchange.ResponseBody = string(RedactJSON(body))
+	} else {
+		b.exchange.ResponseBody = base64.StdEncoding.EncodeToString(body)
+		b.exchange.Base64 = true
+	}
+	if werr := b.recorder.write(b.exchange); werr != nil && err == nil {
+		err = fmt.Errorf("failed to record exchange: %v", werr)
+	}
+	return err
+}
+
+// Replayer is an HTTPClient that answers requests from a recording instead
+// of the network. Requests must arrive in the recorded order; each one is
+// matched by method and URL path.
+type Replayer struct {
+	mu        sync.Mutex
+	exchanges []*Exchange
+	next      int
+}
+
+// NewReplayer reads a recording written by a Recorder.
+func NewReplayer(r io.Reader) (*Replayer, error) {
+	replayer := &Replayer{}
+	decoder := json.NewDecoder(r)
+	for {
+		var exchange Exchange
+		if err := decoder.Decode(&exchange); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to parse recording: %v", err)
+		}
+		replayer.exchanges = append(replayer.exchanges, &exchange)
+	}
+	return replayer, nil
+}
+
+// Do implements HTTPClient.
+func (r *Replayer) Do(req *http.Request) (*http.Response, error) {
+	if err := req.Context().Err(); err != nil {
+		return nil, err
+	}
+	if req.Body != nil {
+		req.Body.Close()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.next >= len(r.exchanges) {
+		return nil, fmt.Errorf("replay: unexpected %s %s after the end of the recording", req.Method, req.URL.Path)
+	}
+	exchange := r.exchanges[r.next]
+
+	recorded, err := url.Parse(exchange.URL)
+	if err != nil {
+		return nil, fmt.Errorf("replay: invalid recorded URL %q: %v", exchange.URL, err)
+	}
+	if exchange.Method != req.Method || recorded.Path != req.URL.Path {
+		return nil, fmt.Errorf("replay: request %d is %s %s, but the recording has %s %s", r.next+1, req.Method, req.URL.Path, exchange.Method, recorded.Path)
+	}
+	r.next++
+
+	if exchange.Error != "" {
+		return nil, fmt.Errorf("replay: %s", exchange.Error)
+	}
+
+	body := []byte(exchange.ResponseBody)
+	if exchange.Base64 {
+		if body, err = base64.StdEncoding.DecodeString(exchange.ResponseBody); err != nil {
+			return nil, fmt.Errorf("replay: invalid response body of request %d: %v", r.next, err)
+		}
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", exchange.Status, http.StatusText(exchange.Status)),
+		StatusCode:    exchange.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        exchange.ResponseHeader,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+// Remaining returns how many recorded exchanges have not been replayed.
+func (r *Replayer) Remaining() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.exchanges) - r.next
+}
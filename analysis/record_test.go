@@ -0,0 +1,122 @@
+package analysis
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	results := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gz := gzip.NewWriter(w)
+		gz.Write([]byte("{\"ICCID\": \"8981100000000000001\"}\n"))
+		gz.Close()
+	}))
+	defer results.Close()
+
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/v1/auth":
+			json.NewEncoder(w).Encode(AuthResponse{ApiKey: "secret-api-key", Token: "secret-token"})
+		case r.URL.Path == "/v1/analysis/queries" && r.Method == "POST":
+			json.NewEncoder(w).Encode(QueryResponse{QueryId: "test-query-id"})
+		default:
+			json.NewEncoder(w).Encode(QueryStatusResponse{
+				Status:     "COMPLETED",
+				URL:        results.URL + "/result.jsonl.gz?X-Amz-Signature=secret-signature",
+				ColumnInfo: []ColumnInfo{{Name: "ICCID", Type: "string"}},
+			})
+		}
+	}))
+	defer api.Close()
+
+	config := &Config{Email: "user@example.com", Password: "secret-password", Endpoint: api.URL}
+	query := func(client HTTPClient) string {
+		t.Helper()
+		c, err := New(context.Background(), Options{Config: config, HTTPClient: client})
+		if err != nil {
+			t.Fatalf("New() error = %v", err)
+		}
+		rows, err := c.Query(context.Background(), "SELECT ICCID FROM SIM_SNAPSHOTS", nil)
+		if err != nil {
+			t.Fatalf("Query() error = %v", err)
+		}
+		defer rows.Close()
+		if !rows.Next() {
+			t.Fatalf("Query() returned no rows: %v", rows.Err())
+		}
+		return rows.Row()["ICCID"].(string)
+	}
+
+	var recording bytes.Buffer
+	if got := query(NewRecorder(&http.Client{}, &recording)); got != "8981100000000000001" {
+		t.Fatalf("recorded query returned %s", got)
+	}
+
+	for _, secret := range []string{"secret-password", "secret-api-key", "secret-token", "secret-signature"} {
+		if strings.Contains(recording.String(), secret) {
+			t.Errorf("recording contains %q:\n%s", secret, recording.String())
+		}
+	}
+
+	replayer, err := NewReplayer(bytes.NewReader(recording.Bytes()))
+	if err != nil {
+		t.Fatalf("NewReplayer() error = %v", err)
+	}
+	results.Close()
+	api.Close()
+
+	if got := query(replayer); got != "8981100000000000001" {
+		t.Errorf("replayed query returned %s", got)
+	}
+	if replayer.Remaining() != 0 {
+		t.Errorf("%d recorded exchanges were not replayed", replayer.Remaining())
+	}
+}
+
+func TestRecorderRedactsTransportErrors(t *testing.T) {
+	// A presigned download from a storage server that is gone
+	storage := httptest.NewServer(http.NotFoundHandler())
+	storage.Close()
+	url := storage.URL + "/result.jsonl.gz?X-Amz-Credential=secret-credential&X-Amz-Signature=secret-signature"
+
+	var recording bytes.Buffer
+	recorder := NewRecorder(&http.Client{}, &recording)
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := recorder.Do(req); err == nil {
+		t.Fatal("Do() to a closed server succeeded")
+	}
+
+	var exchange Exchange
+	if err := json.Unmarshal(recording.Bytes(), &exchange); err != nil {
+		t.Fatalf("invalid recording %q: %v", recording.String(), err)
+	}
+	if exchange.Error == "" {
+		t.Errorf("recording has no transport error: %s", recording.String())
+	}
+	for _, secret := range []string{"secret-credential", "secret-signature"} {
+		if strings.Contains(recording.String(), secret) {
+			t.Errorf("recording contains %q:\n%s", secret, recording.String())
+		}
+	}
+}
+
+func TestReplayerRejectsUnexpectedRequest(t *testing.T) {
+	replayer, err := NewReplayer(strings.NewReader(`{"method":"POST","url":"https://jp.api.soracom.io/v1/auth","status":200,"responseBody":"{}"}` + "\n"))
+	if err != nil {
+		t.Fatalf("NewReplayer() error = %v", err)
+	}
+
+	req, _ := http.NewRequest("GET", "https://jp.api.soracom.io/v1/analysis/schemas", nil)
+	if _, err := replayer.Do(req); err == nil || !strings.Contains(err.Error(), "POST /v1/auth") {
+		t.Errorf("Do() error = %v, want a mismatch naming the recorded request", err)
+	}
+}
@@ -0,0 +1,140 @@
+package analysis
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Redacted replaces secret values in recordings, debug output and errors.
+const Redacted = "REDACTED"
+
+// secretFields are JSON fields that hold credentials in API requests and
+// responses.
+var secretFields = map[string]bool{
+	"password":  true,
+	"authkey":   true,
+	"authkeyid": true,
+	"apikey":    true,
+	"token":     true,
+
+	"mfaotpcode": true,
+}
+
+// secretHeaders are request and response headers that carry credentials.
+var secretHeaders = map[string]bool{
+	"X-Soracom-Api-Key": true,
+	"X-Soracom-Token":   true,
+	"Authorization":     true,
+	"Cookie":            true,
+	"Set-Cookie":        true,
+}
+
+// RedactHeader returns a copy of h with credential headers masked.
+func RedactHeader(h http.Header) http.Header {
+	redacted := h.Clone()
+	for name := range redacted {
+		if secretHeaders[http.CanonicalHeaderKey(name)] {
+			redacted[name] = []string{Redacted}
+		}
+	}
+	return redacted
+}
+
+// RedactURL masks the query parameters of rawURL that look like credentials,
+// such as the signature of a presigned result URL.
+func RedactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.RawQuery == "" {
+		return rawURL
+	}
+
+	query := u.Query()
+	changed := false
+	for name := range query {
+		if isSecretName(name) {
+			query.Set(name, Redacted)
+			changed = true
+		}
+	}
+	if !changed {
+		return rawURL
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// isSecretName reports whether a query parameter or XML element name looks
+// like it holds a credential.
+func isSecretName(name string) bool {
+	lower := strings.ToLower(name)
+	return strings.Contains(lower, "signature") || strings.Contains(lower, "credential") ||
+		strings.Contains(lower, "token") || strings.Contains(lower, "key") ||
+		strings.Contains(lower, "password")
+}
+
+// RedactJSON masks credential fields and presigned URLs in a JSON document.
+// Bodies that are not JSON are returned unchanged.
+func RedactJSON(body []byte) []byte {
+	var doc interface{}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return body
+	}
+
+	redacted, err := json.Marshal(redactValue("", doc))
+	if err != nil {
+		return body
+	}
+	return redacted
+}
+
+func redactValue(key string, value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for k, item := range v {
+			v[k] = redactValue(k, item)
+		}
+		return v
+	case []interface{}:
+		for i, item := range v {
+			v[i] = redactValue(key, item)
+		}
+		return v
+	case string:
+		if secretFields[strings.ToLower(key)] {
+			return Redacted
+		}
+		if strings.HasPrefix(v, "http://") || strings.HasPrefix(v, "https://") {
+			return RedactURL(v)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+var (
+	urlPattern        = regexp.MustCompile(`https?://[^\s"'<>]+`)
+	xmlElementPattern = regexp.MustCompile(`<(\w+)>[^<]*</(\w+)>`)
+)
+
+// RedactText masks credentials in free-form text such as error messages and
+// response bodies: JSON documents are passed to RedactJSON, and in other text
+// the signatures of URLs and XML elements like the <SignatureProvided> of a
+// storage error response are masked.
+func RedactText(text string) string {
+	if json.Valid([]byte(text)) {
+		return string(RedactJSON([]byte(text)))
+	}
+
+	text = urlPattern.ReplaceAllStringFunc(text, RedactURL)
+	return xmlElementPattern.ReplaceAllStringFunc(text, func(element string) string {
+		names := xmlElementPattern.FindStringSubmatch(element)
+		if names[1] != names[2] || !isSecretName(names[1]) {
+			return element
+		}
+		return "<" + names[1] + ">" + Redacted + "</" + names[1] + ">"
+	})
+}
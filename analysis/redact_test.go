@@ -0,0 +1,79 @@
+package analysis
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestRedactJSON(t *testing.T) {
+	body := `{"email":"user@example.com","password":"pw","nested":{"token":"t","authKey":"k"},"url":"https://bucket.example.com/r.gz?X-Amz-Signature=sig&X-Amz-Date=20240101"}`
+	redacted := string(RedactJSON([]byte(body)))
+
+	for _, secret := range []string{`"pw"`, `"t"`, `"k"`, "sig&"} {
+		if strings.Contains(redacted, secret) {
+			t.Errorf("RedactJSON() left %s in %s", secret, redacted)
+		}
+	}
+	if !strings.Contains(redacted, "user@example.com") || !strings.Contains(redacted, "X-Amz-Date=20240101") {
+		t.Errorf("RedactJSON() removed non-secret values: %s", redacted)
+	}
+
+	if got := string(RedactJSON([]byte("not json"))); got != "not json" {
+		t.Errorf("RedactJSON() changed a non-JSON body to %q", got)
+	}
+}
+
+func TestRedactHeader(t *testing.T) {
+	header := http.Header{}
+	header.Set("X-Soracom-Token", "t")
+	header.Set("Content-Type", "application/json")
+
+	redacted := RedactHeader(header)
+	if redacted.Get("X-Soracom-Token") != Redacted || redacted.Get("Content-Type") != "application/json" {
+		t.Errorf("RedactHeader() = %v", redacted)
+	}
+	if header.Get("X-Soracom-Token") != "t" {
+		t.Error("RedactHeader() modified its argument")
+	}
+}
+
+func TestRedactText(t *testing.T) {
+	tests := []struct {
+		name   string
+		text   string
+		secret string
+		keep   string
+	}{
+		{
+			name:   "url in error",
+			text:   `Get "https://bucket.example.com/r.gz?X-Amz-Signature=sig&X-Amz-Date=20240101": dial tcp: timeout`,
+			secret: "sig",
+			keep:   "dial tcp: timeout",
+		},
+		{
+			name:   "storage error",
+			text:   `<Error><Code>SignatureDoesNotMatch</Code><SignatureProvided>sig</SignatureProvided></Error>`,
+			secret: ">sig<",
+			keep:   "SignatureDoesNotMatch",
+		},
+		{
+			name:   "json body",
+			text:   `{"apiKey":"api-key","token":"secret-token","status":"ok"}`,
+			secret: "secret-token",
+			keep:   `"status":"ok"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			redacted := RedactText(tt.text)
+			if strings.Contains(redacted, tt.secret) {
+				t.Errorf("RedactText() left %q in %s", tt.secret, redacted)
+			}
+			if !strings.Contains(redacted, tt.keep) {
+				t.Errorf("RedactText() removed %q from %s", tt.keep, redacted)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package analysis
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// DefaultRetries is how many times an API request that failed transiently is
+// retried before giving up.
+const DefaultRetries = 3
+
+// retryDelay is the delay before the first retry of an API request. It
+// doubles, with jitter, for every further retry.
+var retryDelay = 500 * time.Millisecond
+
+// maxRetryDelay caps the delay between retries. A Retry-After header asking
+// to wait longer ends the retries instead.
+var maxRetryDelay = 30 * time.Second
+
+// transportError is an API request that got no complete response. Its message
+// has the credentials masked.
+type transportError struct {
+	err     error
+	message string
+}
+
+func (e *transportError) Error() string { return e.message }
+func (e *transportError) Unwrap() error { return e.err }
+
+// sendWithRetry sends a request like send and retries transient failures up
+// to c.retries times. Idempotent requests are retried after network errors,
+// 408, 429 and 5xx responses. Other requests, such as submitting a query,
+// could run twice if they reached the API, so they are only retried when the
+// connection could not be established or the API answered 429.
+func (c *Client) sendWithRetry(ctx context.Context, method, url string, payloadBytes []byte, headers map[string]string) (*apiResponse, error) {
+	for attempt := 0; ; attempt++ {
+		resp, err := c.send(ctx, method, url, payloadBytes, headers)
+		if attempt >= c.retries || ctx.Err() != nil || !retryable(method, resp, err) {
+			return resp, err
+		}
+
+		delay := jitter(backoff(retryDelay, attempt))
+		attrs := []any{"method", method, "url", c.redact(url), "attempt", attempt + 1}
+		if err != nil {
+			attrs = append(attrs, "error", err.Error())
+		} else {
+			attrs = append(attrs, "status", resp.status)
+			if after, ok := parseRetryAfter(resp.header.Get("Retry-After"), time.Now()); ok {
+				if after > maxRetryDelay {
+					c.log().Warn("API asked to retry too late, giving up", append(attrs, "retryAfter", after)...)
+					return resp, nil
+				}
+				delay = after
+			}
+		}
+
+		c.log().Warn("API request failed, retrying", append(attrs, "delay", delay.Round(time.Millisecond))...)
+		if err := sleepContext(ctx, delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// retryable reports whether a request with the given method that ended with
+// resp or err is worth retrying.
+func retryable(method string, resp *apiResponse, err error) bool {
+	if err != nil {
+		var transport *transportError
+		if !errors.As(err, &transport) {
+			return false
+		}
+		return idempotent(method) || connectionFailed(err)
+	}
+
+	switch {
+	case resp.status == http.StatusTooManyRequests:
+		// The API rejected the request without processing it
+		return true
+	case resp.status == http.StatusRequestTimeout || resp.status >= 500:
+		return idempotent(method)
+	}
+	return false
+}
+
+// backoff returns initial doubled attempt times, capped at maxRetryDelay.
+func backoff(initial time.Duration, attempt int) time.Duration {
+	// Stop shifting long before the duration could overflow
+	return min(initial<<min(attempt, 16), maxRetryDelay)
+}
+
+// idempotent reports whether sending a request with method twice has the
+// same effect as sending it once.
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// connectionFailed reports whether err happened before the request could be
+// sent: the API's name did not resolve or the connection was refused.
+func connectionFailed(err error) bool {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// parseRetryAfter parses a Retry-After header, either a number of seconds or
+// an HTTP date, into the time to wait from now.
+func parseRetryAfter(header string, now time.Time) (time.Duration, bool) {
+	if header == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(header); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	when, err := http.ParseTime(header)
+	if err != nil {
+		return 0, false
+	}
+	return max(when.Sub(now), 0), true
+}
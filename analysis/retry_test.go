@@ -0,0 +1,153 @@
+package analysis
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryIdempotentRequest(t *testing.T) {
+	retryDelay = time.Millisecond
+	defer func() { retryDelay = 500 * time.Millisecond }()
+
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch requests.Add(1) {
+		case 1:
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case 2:
+			w.WriteHeader(http.StatusBadGateway)
+		default:
+			w.Write([]byte(`{"status":"RUNNING"}`))
+		}
+	}))
+	defer server.Close()
+
+	client := &Client{httpClient: server.Client(), retries: 3}
+	body, err := client.makeRequest(context.Background(), "GET", server.URL, nil)
+	if err != nil {
+		t.Fatalf("makeRequest() error = %v", err)
+	}
+	if string(body) != `{"status":"RUNNING"}` || requests.Load() != 3 {
+		t.Errorf("makeRequest() = %s after %d requests, want the third response", body, requests.Load())
+	}
+
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	retryDelay = time.Millisecond
+	defer func() { retryDelay = 500 * time.Millisecond }()
+
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	client := &Client{httpClient: server.Client(), retries: 2}
+	if _, err := client.makeRequest(context.Background(), "GET", server.URL, nil); err == nil || !strings.Contains(err.Error(), "HTTP 503") {
+		t.Errorf("makeRequest() error = %v, want the last failure after the retries ran out", err)
+	}
+	if requests.Load() != 3 {
+		t.Errorf("makeRequest() sent %d requests, want 3", requests.Load())
+	}
+
+	requests.Store(0)
+	client.retries = 0
+	client.makeRequest(context.Background(), "GET", server.URL, nil)
+	if requests.Load() != 1 {
+		t.Errorf("makeRequest() without retries sent %d requests, want 1", requests.Load())
+	}
+}
+
+func TestRetrySubmit(t *testing.T) {
+	retryDelay = time.Millisecond
+	defer func() { retryDelay = 500 * time.Millisecond }()
+
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	// A POST that may have reached the API is not sent again
+	client := &Client{httpClient: server.Client(), retries: 3}
+	if _, err := client.makeRequest(context.Background(), "POST", server.URL, map[string]string{"sql": "SELECT 1"}); err == nil {
+		t.Fatal("makeRequest() succeeded on a 502")
+	}
+	if requests.Load() != 1 {
+		t.Errorf("POST was sent %d times after a 502, want 1", requests.Load())
+	}
+
+	// One that could not connect is
+	dialFailures := 2
+	client.httpClient = httpClientFunc(func(req *http.Request) (*http.Response, error) {
+		if dialFailures > 0 {
+			dialFailures--
+			return nil, &net.OpError{Op: "dial", Net: "tcp", Err: &net.AddrError{Err: "connection refused", Addr: req.URL.Host}}
+		}
+		rec := httptest.NewRecorder()
+		rec.WriteString(`{"queryId":"q1"}`)
+		return rec.Result(), nil
+	})
+	body, err := client.makeRequest(context.Background(), "POST", server.URL, map[string]string{"sql": "SELECT 1"})
+	if err != nil {
+		t.Fatalf("makeRequest() error = %v after connection failures", err)
+	}
+	if string(body) != `{"queryId":"q1"}` {
+		t.Errorf("makeRequest() = %s", body)
+	}
+}
+
+func TestRetryAfterTooLong(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Retry-After", "3600")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	client := &Client{httpClient: server.Client(), retries: 3}
+	if _, err := client.makeRequest(context.Background(), "GET", server.URL, nil); err == nil || !strings.Contains(err.Error(), "HTTP 429") {
+		t.Errorf("makeRequest() error = %v, want the 429", err)
+	}
+	if requests.Load() != 1 {
+		t.Errorf("makeRequest() sent %d requests, want no retry an hour later", requests.Load())
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		header string
+		want   time.Duration
+		ok     bool
+	}{
+		{"", 0, false},
+		{"5", 5 * time.Second, true},
+		{"-1", 0, false},
+		{"Mon, 01 Jan 2024 00:00:10 GMT", 10 * time.Second, true},
+		{"Sun, 31 Dec 2023 23:59:00 GMT", 0, true},
+		{"soon", 0, false},
+	}
+	for _, tc := range testCases {
+		got, ok := parseRetryAfter(tc.header, now)
+		if got != tc.want || ok != tc.ok {
+			t.Errorf("parseRetryAfter(%q) = %v, %v, want %v, %v", tc.header, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+// httpClientFunc adapts a function to HTTPClient.
+type httpClientFunc func(req *http.Request) (*http.Response, error)
+
+func (f httpClientFunc) Do(req *http.Request) (*http.Response, error) { return f(req) }
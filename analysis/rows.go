@@ -0,0 +1,86 @@
+package analysis
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Rows iterates over the JSONL records of a query result.
+//
+//	for rows.Next() {
+//		row := rows.Row()
+//		...
+//	}
+//	if err := rows.Err(); err != nil {
+//		...
+//	}
+type Rows struct {
+	// Columns describes the result columns in the order the API reported
+	// them. It may be empty, in which case the row keys are the only source
+	// of column names.
+	Columns []ColumnInfo
+
+	src io.ReadCloser
+	dec *json.Decoder
+	row map[string]interface{}
+	err error
+}
+
+// NewRows returns an iterator over the JSONL records read from r. Close
+// closes r.
+func NewRows(r io.ReadCloser, columns []ColumnInfo) *Rows {
+	return &Rows{
+		Columns: columns,
+		src:     r,
+		dec:     json.NewDecoder(r),
+	}
+}
+
+// Next advances to the next row. It returns false at the end of the result
+// or on error; Err distinguishes the two.
+func (r *Rows) Next() bool {
+	if r.err != nil {
+		return false
+	}
+
+	var row map[string]interface{}
+	if err := r.dec.Decode(&row); err != nil {
+		switch {
+		case err == io.EOF:
+		case errors.Is(err, io.ErrUnexpectedEOF):
+			// Truncated gzip stream or a row cut off mid-way
+			r.err = fmt.Errorf("result download incomplete: %v", err)
+		default:
+			r.err = fmt.Errorf("failed to parse result row: %v", err)
+		}
+		r.row = nil
+		return false
+	}
+
+	r.row = row
+	return true
+}
+
+// UseNumber makes the rows hold numbers as json.Number instead of float64,
+// so that large integers and decimals keep every digit. Call it before the
+// first Next.
+func (r *Rows) UseNumber() {
+	r.dec.UseNumber()
+}
+
+// Row returns the current row keyed by column name.
+func (r *Rows) Row() map[string]interface{} {
+	return r.row
+}
+
+// Err returns the error, if any, that stopped the iteration.
+func (r *Rows) Err() error {
+	return r.err
+}
+
+// Close releases the underlying reader.
+func (r *Rows) Close() error {
+	return r.src.Close()
+}
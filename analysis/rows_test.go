@@ -0,0 +1,66 @@
+package analysis
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestRows(t *testing.T) {
+	input := "{\"name\": \"a\", \"value\": 1}\n\n{\"name\": \"b\", \"value\": 2}\n"
+	rows := NewRows(io.NopCloser(strings.NewReader(input)), nil)
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		names = append(names, rows.Row()["name"].(string))
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("Rows.Err() = %v", err)
+	}
+	if strings.Join(names, ",") != "a,b" {
+		t.Errorf("Rows yielded %v, want [a b]", names)
+	}
+}
+
+func TestRowsUseNumber(t *testing.T) {
+	rows := NewRows(io.NopCloser(strings.NewReader(`{"id": 12345678901234567890, "price": 0.10}`)), nil)
+	defer rows.Close()
+	rows.UseNumber()
+
+	if !rows.Next() {
+		t.Fatalf("Rows.Next() = false: %v", rows.Err())
+	}
+	row := rows.Row()
+	if row["id"] != json.Number("12345678901234567890") || row["price"] != json.Number("0.10") {
+		t.Errorf("Rows.Row() = %v, want the numbers as written", row)
+	}
+}
+
+func TestRowsMalformed(t *testing.T) {
+	rows := NewRows(io.NopCloser(strings.NewReader("{\"name\": \"a\"}\nnot json\n")), nil)
+	defer rows.Close()
+
+	count := 0
+	for rows.Next() {
+		count++
+	}
+	if count != 1 {
+		t.Errorf("Rows yielded %d rows before the malformed line, want 1", count)
+	}
+	if rows.Err() == nil {
+		t.Error("Expected an error for a malformed result line")
+	}
+}
+
+func TestRowsTruncated(t *testing.T) {
+	rows := NewRows(io.NopCloser(strings.NewReader("{\"name\": \"a\"}\n{\"name\": ")), nil)
+	defer rows.Close()
+
+	for rows.Next() {
+	}
+	if err := rows.Err(); err == nil || !strings.Contains(err.Error(), "incomplete") {
+		t.Errorf("Rows.Err() = %v, want an incomplete download error", err)
+	}
+}
@@ -0,0 +1,346 @@
+package analysis
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// TableColumn describes one column of a table in the analysis schema.
+type TableColumn struct {
+	Name        string
+	Type        string
+	Description string
+}
+
+// Schemas fetches the raw schema document from /v1/analysis/schemas.
+func (c *Client) Schemas(ctx context.Context) (json.RawMessage, error) {
+	body, err := c.makeRequest(ctx, "GET", c.apiURL("/v1/analysis/schemas"), nil)
+	if err != nil {
+		return nil, err
+	}
+	return json.RawMessage(body), nil
+}
+
+// Tables returns the sorted names of the tables available for querying.
+func (c *Client) Tables(ctx context.Context) ([]string, error) {
+	schema, err := c.decodedSchemas(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return ExtractTableNames(schema), nil
+}
+
+// TableSchemas returns the columns of every table, keyed by table name.
+func (c *Client) TableSchemas(ctx context.Context) (map[string][]TableColumn, error) {
+	schema, err := c.decodedSchemas(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return ExtractTableSchemas(schema), nil
+}
+
+func (c *Client) decodedSchemas(ctx context.Context) (map[string]interface{}, error) {
+	body, err := c.Schemas(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var schema map[string]interface{}
+	if err := json.Unmarshal(body, &schema); err != nil {
+		return nil, fmt.Errorf("failed to parse schema response: %v", err)
+	}
+	return schema, nil
+}
+
+// QueryPlans fetches the raw query plan document from /v1/analysis/plans/query.
+func (c *Client) QueryPlans(ctx context.Context) (json.RawMessage, error) {
+	body, err := c.makeRequest(ctx, "GET", c.apiURL("/v1/analysis/plans/query"), nil)
+	if err != nil {
+		return nil, err
+	}
+	return json.RawMessage(body), nil
+}
+
+// ExtractTableNames returns the sorted table names found in a decoded
+// schema document.
+func ExtractTableNames(schema map[string]interface{}) []string {
+	var tableNames []string
+
+	// Try to extract tables from different possible schema structures
+	// This is a generic approach that handles various schema formats
+	if tables, exists := schema["tables"]; exists {
+		if tableList, ok := tables.([]interface{}); ok {
+			for _, table := range tableList {
+				if tableMap, ok := table.(map[string]interface{}); ok {
+					if name, exists := tableMap["name"]; exists {
+						if nameStr, ok := name.(string); ok {
+							tableNames = append(tableNames, nameStr)
+						}
+					}
+				}
+			}
+		}
+	}
+
+	// If no tables found in expected structure, try alternative approaches
+	if len(tableNames) == 0 {
+		// Look for any keys that might represent table names
+		for key, value := range schema {
+			if key == "schemas" || key == "databases" {
+				if schemaMap, ok := value.(map[string]interface{}); ok {
+					for _, schemaValue := range schemaMap {
+						if schemaData, ok := schemaValue.(map[string]interface{}); ok {
+							if tables, exists := schemaData["tables"]; exists {
+								if tableMap, ok := tables.(map[string]interface{}); ok {
+									for tableName := range tableMap {
+										tableNames = append(tableNames, tableName)
+									}
+								}
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+
+	// Sort table names for consistent display
+	if len(tableNames) > 1 {
+		for i := 0; i < len(tableNames)-1; i++ {
+			for j := i + 1; j < len(tableNames); j++ {
+				if tableNames[i] > tableNames[j] {
+					tableNames[i], tableNames[j] = tableNames[j], tableNames[i]
+				}
+			}
+		}
+	}
+
+	return tableNames
+}
+
+// ExtractTableSchemas returns the columns of every table found in a decoded
+// schema document, keyed by table name.
+func ExtractTableSchemas(schema map[string]interface{}) map[string][]TableColumn {
+	tableSchemas := make(map[string][]TableColumn)
+
+	// Pattern 1: Direct tables array
+	if tables, exists := schema["tables"]; exists {
+		if tableList, ok := tables.([]interface{}); ok {
+			for _, table := range tableList {
+				if tableMap, ok := table.(map[string]interface{}); ok {
+					if name, exists := tableMap["name"]; exists {
+						if nameStr, ok := name.(string); ok {
+							columns := extractColumns(tableMap)
+							tableSchemas[nameStr] = columns
+						}
+					}
+				}
+			}
+		} else if tableMap, ok := tables.(map[string]interface{}); ok {
+			// Pattern 1b: Tables as a map
+			for tableName, tableData := range tableMap {
+				if tableInfo, ok := tableData.(map[string]interface{}); ok {
+					columns := extractColumns(tableInfo)
+					tableSchemas[tableName] = columns
+				}
+			}
+		}
+	}
+
+	// Pattern 2: Nested schemas structure
+	if schemas, exists := schema["schemas"]; exists {
+		if schemaMap, ok := schemas.(map[string]interface{}); ok {
+			for _, schemaValue := range schemaMap {
+				if schemaData, ok := schemaValue.(map[string]interface{}); ok {
+					if tables, exists := schemaData["tables"]; exists {
+						if tableMap, ok := tables.(map[string]interface{}); ok {
+							for tableName, tableData := range tableMap {
+								if tableInfo, ok := tableData.(map[string]interface{}); ok {
+									columns := extractColumns(tableInfo)
+									tableSchemas[tableName] = columns
+								}
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+
+	// Pattern 3: Direct table names as top-level keys
+	if len(tableSchemas) == 0 {
+		for key, value := range schema {
+			// Skip common non-table keys
+			if key == "version" || key == "metadata" || key == "info" {
+				continue
+			}
+
+			if tableData, ok := value.(map[string]interface{}); ok {
+				// Check if this looks like table data
+				if _, hasColumns := tableData["columns"]; hasColumns {
+					columns := extractColumns(tableData)
+					if len(columns) > 0 {
+						tableSchemas[key] = columns
+					}
+				}
+			}
+		}
+	}
+
+	return tableSchemas
+}
+
+func extractColumns(tableData map[string]interface{}) []TableColumn {
+	var columns []TableColumn
+
+	// Pattern 0: columnInfo (Soracom format)
+	if cols, exists := tableData["columnInfo"]; exists {
+		if colList, ok := cols.([]interface{}); ok {
+			for _, col := range colList {
+				if colMap, ok := col.(map[string]interface{}); ok {
+					name := ""
+					colType := ""
+					description := ""
+
+					if n, exists := colMap["name"]; exists {
+						if nameStr, ok := n.(string); ok {
+							name = nameStr
+						}
+					}
+
+					// Prefer databaseType over type for better precision
+					if t, exists := colMap["databaseType"]; exists {
+						if typeStr, ok := t.(string); ok {
+							colType = typeStr
+						}
+					} else if t, exists := colMap["type"]; exists {
+						if typeStr, ok := t.(string); ok {
+							colType = typeStr
+						}
+					}
+
+					if d, exists := colMap["description"]; exists {
+						if descStr, ok := d.(string); ok {
+							description = descStr
+						}
+					}
+
+					if name != "" {
+						if colType == "" {
+							colType = "UNKNOWN"
+						}
+						columns = append(columns, TableColumn{Name: name, Type: colType, Description: description})
+					}
+				}
+			}
+		}
+	}
+
+	// Pattern 1: columns as array of objects with name/type
+	if len(columns) == 0 && tableData["columns"] != nil {
+		if cols, exists := tableData["columns"]; exists {
+			if colList, ok := cols.([]interface{}); ok {
+				for _, col := range colList {
+					if colMap, ok := col.(map[string]interface{}); ok {
+						name := ""
+						colType := ""
+
+						// Try different name fields
+						if n, exists := colMap["name"]; exists {
+							if nameStr, ok := n.(string); ok {
+								name = nameStr
+							}
+						} else if n, exists := colMap["column_name"]; exists {
+							if nameStr, ok := n.(string); ok {
+								name = nameStr
+							}
+						}
+
+						// Try different type fields
+						if t, exists := colMap["type"]; exists {
+							if typeStr, ok := t.(string); ok {
+								colType = typeStr
+							}
+						} else if t, exists := colMap["data_type"]; exists {
+							if typeStr, ok := t.(string); ok {
+								colType = typeStr
+							}
+						} else if t, exists := colMap["column_type"]; exists {
+							if typeStr, ok := t.(string); ok {
+								colType = typeStr
+							}
+						}
+
+						if name != "" {
+							if colType == "" {
+								colType = "UNKNOWN"
+							}
+							columns = append(columns, TableColumn{Name: name, Type: colType, Description: ""})
+						}
+					}
+				}
+			}
+
+			// Pattern 2: columns as map of column_name -> column_info
+			if colMap, ok := cols.(map[string]interface{}); ok {
+				for colName, colData := range colMap {
+					colType := "UNKNOWN"
+
+					if colInfo, ok := colData.(map[string]interface{}); ok {
+						// Try different type field names
+						if t, exists := colInfo["type"]; exists {
+							if typeStr, ok := t.(string); ok {
+								colType = typeStr
+							}
+						} else if t, exists := colInfo["data_type"]; exists {
+							if typeStr, ok := t.(string); ok {
+								colType = typeStr
+							}
+						}
+					} else if typeStr, ok := colData.(string); ok {
+						// Pattern 2b: column_name -> type_string directly
+						colType = typeStr
+					}
+
+					columns = append(columns, TableColumn{Name: colName, Type: colType, Description: ""})
+				}
+			}
+		}
+	}
+
+	// Pattern 3: fields instead of columns
+	if len(columns) == 0 {
+		if fields, exists := tableData["fields"]; exists {
+			if fieldList, ok := fields.([]interface{}); ok {
+				for _, field := range fieldList {
+					if fieldMap, ok := field.(map[string]interface{}); ok {
+						name := ""
+						colType := ""
+
+						if n, exists := fieldMap["name"]; exists {
+							if nameStr, ok := n.(string); ok {
+								name = nameStr
+							}
+						}
+
+						if t, exists := fieldMap["type"]; exists {
+							if typeStr, ok := t.(string); ok {
+								colType = typeStr
+							}
+						}
+
+						if name != "" {
+							if colType == "" {
+								colType = "UNKNOWN"
+							}
+							columns = append(columns, TableColumn{Name: name, Type: colType, Description: ""})
+						}
+					}
+				}
+			}
+		}
+	}
+
+	return columns
+}
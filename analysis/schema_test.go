@@ -0,0 +1,144 @@
+package analysis
+
+import (
+	"testing"
+)
+
+func TestExtractTableNames(t *testing.T) {
+	// Test schema with tables array
+	schema1 := map[string]interface{}{
+		"tables": []interface{}{
+			map[string]interface{}{"name": "SIM_SNAPSHOTS"},
+			map[string]interface{}{"name": "CELL_TOWERS"},
+		},
+	}
+
+	tables1 := ExtractTableNames(schema1)
+	if len(tables1) != 2 {
+		t.Errorf("Expected 2 tables, got %d", len(tables1))
+	}
+
+	expectedTables := []string{"CELL_TOWERS", "SIM_SNAPSHOTS"} // Should be sorted
+	for i, table := range expectedTables {
+		if i < len(tables1) && tables1[i] != table {
+			t.Errorf("Expected table %s at index %d, got %s", table, i, tables1[i])
+		}
+	}
+
+	// Test schema with nested structure
+	schema2 := map[string]interface{}{
+		"schemas": map[string]interface{}{
+			"default": map[string]interface{}{
+				"tables": map[string]interface{}{
+					"TABLE1": map[string]interface{}{},
+					"TABLE2": map[string]interface{}{},
+				},
+			},
+		},
+	}
+
+	tables2 := ExtractTableNames(schema2)
+	if len(tables2) != 2 {
+		t.Errorf("Expected 2 tables from nested schema, got %d", len(tables2))
+	}
+}
+
+func TestExtractColumns(t *testing.T) {
+	// Test columnInfo (Soracom format)
+	tableData0 := map[string]interface{}{
+		"columnInfo": []interface{}{
+			map[string]interface{}{
+				"name":         "MCC",
+				"type":         "string",
+				"databaseType": "TEXT",
+			},
+			map[string]interface{}{
+				"name":         "MNC",
+				"type":         "string",
+				"databaseType": "TEXT",
+			},
+		},
+	}
+
+	columns0 := extractColumns(tableData0)
+	if len(columns0) != 2 {
+		t.Errorf("Expected 2 columns from columnInfo, got %d", len(columns0))
+	}
+
+	if columns0[0].Name != "MCC" || columns0[0].Type != "TEXT" {
+		t.Errorf("Expected column {MCC, TEXT}, got {%s, %s}", columns0[0].Name, columns0[0].Type)
+	}
+
+	// Test columns as array
+	tableData1 := map[string]interface{}{
+		"columns": []interface{}{
+			map[string]interface{}{
+				"name": "id",
+				"type": "INTEGER",
+			},
+			map[string]interface{}{
+				"name": "name",
+				"type": "VARCHAR",
+			},
+		},
+	}
+
+	columns1 := extractColumns(tableData1)
+	if len(columns1) != 2 {
+		t.Errorf("Expected 2 columns, got %d", len(columns1))
+	}
+
+	if columns1[0].Name != "id" || columns1[0].Type != "INTEGER" {
+		t.Errorf("Expected column {id, INTEGER}, got {%s, %s}", columns1[0].Name, columns1[0].Type)
+	}
+
+	// Test columns as map
+	tableData2 := map[string]interface{}{
+		"columns": map[string]interface{}{
+			"user_id": map[string]interface{}{
+				"type": "BIGINT",
+			},
+			"email": map[string]interface{}{
+				"type": "STRING",
+			},
+		},
+	}
+
+	columns2 := extractColumns(tableData2)
+	if len(columns2) != 2 {
+		t.Errorf("Expected 2 columns from map structure, got %d", len(columns2))
+	}
+}
+
+func TestExtractAllTableSchemas(t *testing.T) {
+	schema := map[string]interface{}{
+		"tables": []interface{}{
+			map[string]interface{}{
+				"name": "users",
+				"columns": []interface{}{
+					map[string]interface{}{
+						"name": "id",
+						"type": "INTEGER",
+					},
+					map[string]interface{}{
+						"name": "email",
+						"type": "VARCHAR",
+					},
+				},
+			},
+		},
+	}
+
+	tableSchemas := ExtractTableSchemas(schema)
+	if len(tableSchemas) != 1 {
+		t.Errorf("Expected 1 table schema, got %d", len(tableSchemas))
+	}
+
+	if userSchema, exists := tableSchemas["users"]; exists {
+		if len(userSchema) != 2 {
+			t.Errorf("Expected 2 columns for users table, got %d", len(userSchema))
+		}
+	} else {
+		t.Error("Expected 'users' table schema to exist")
+	}
+}
@@ -0,0 +1,128 @@
+package analysis
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// tokenTimeout is the lifetime requested for API tokens at login.
+const tokenTimeout = 24 * time.Hour
+
+// tokenExpiryMargin is how long before its expiry a cached token is no
+// longer used, so that it does not expire in the middle of a query.
+const tokenExpiryMargin = 10 * time.Minute
+
+// TokenCache stores API keys and tokens on disk, one private file per set of
+// credentials, so that consecutive runs do not have to log in again.
+type TokenCache struct {
+	// Dir is the directory holding the cached tokens.
+	Dir string
+}
+
+// DefaultTokenCache returns a TokenCache in the user's cache directory, for
+// example ~/.cache/soraql/tokens on Linux.
+func DefaultTokenCache() (*TokenCache, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to locate the cache directory: %v", err)
+	}
+	return &TokenCache{Dir: filepath.Join(dir, "soraql", "tokens")}, nil
+}
+
+// cachedToken is the content of a token cache file.
+type cachedToken struct {
+	ApiKey    string    `json:"apiKey"`
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// path returns the cache file for config. The name is derived from the
+// endpoint and the credentials, so changing the profile's credentials or
+// endpoint never reuses a token issued for the old ones. For a credential
+// process the command stands in for the credentials, so a cached token
+// saves running it.
+func (tc *TokenCache) path(config *Config) string {
+	identity, _ := json.Marshal([]string{
+		config.Endpoint, config.CoverageType,
+		config.Email, config.Password,
+		config.OperatorId, config.Username,
+		config.AuthKeyId, config.AuthKey,
+		config.CredentialProcess,
+	})
+	sum := sha256.Sum256(identity)
+	return filepath.Join(tc.Dir, hex.EncodeToString(sum[:16])+".json")
+}
+
+// load returns the cached token for config if there is one that has not
+// expired. A nil TokenCache never has a token.
+func (tc *TokenCache) load(config *Config) (*cachedToken, bool) {
+	if tc == nil {
+		return nil, false
+	}
+
+	data, err := os.ReadFile(tc.path(config))
+	if err != nil {
+		return nil, false
+	}
+
+	var cached cachedToken
+	if err := json.Unmarshal(data, &cached); err != nil {
+		return nil, false
+	}
+	if cached.ApiKey == "" || cached.Token == "" || time.Now().Add(tokenExpiryMargin).After(cached.ExpiresAt) {
+		return nil, false
+	}
+	return &cached, true
+}
+
+// store caches a freshly issued token for config. The cache is only an
+// optimization, so failures to write it are ignored.
+func (tc *TokenCache) store(config *Config, auth *AuthResponse) {
+	if tc == nil {
+		return
+	}
+
+	data, err := json.Marshal(cachedToken{
+		ApiKey:    auth.ApiKey,
+		Token:     auth.Token,
+		ExpiresAt: time.Now().Add(tokenTimeout),
+	})
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(tc.Dir, 0700); err != nil {
+		return
+	}
+
+	// Write to a temporary file first so a concurrent run never reads a
+	// partial token.
+	tmp, err := os.CreateTemp(tc.Dir, ".token-*")
+	if err != nil {
+		return
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return
+	}
+	if err := os.Rename(tmp.Name(), tc.path(config)); err != nil {
+		os.Remove(tmp.Name())
+	}
+}
+
+// remove discards the cached token for config, e.g. after the API rejected it.
+func (tc *TokenCache) remove(config *Config) {
+	if tc == nil {
+		return
+	}
+	os.Remove(tc.path(config))
+}
@@ -0,0 +1,108 @@
+package analysis
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTokenCache(t *testing.T) {
+	cache := &TokenCache{Dir: filepath.Join(t.TempDir(), "tokens")}
+	config := &Config{AuthKeyId: "keyId-test", AuthKey: "secret-test"}
+
+	if _, ok := cache.load(config); ok {
+		t.Fatal("load() on an empty cache found a token")
+	}
+
+	cache.store(config, &AuthResponse{ApiKey: "api-key", Token: "token"})
+	cached, ok := cache.load(config)
+	if !ok || cached.ApiKey != "api-key" || cached.Token != "token" {
+		t.Fatalf("load() = %+v, %v, want the stored token", cached, ok)
+	}
+
+	info, err := os.Stat(cache.path(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("cache file permissions = %o, want 600", perm)
+	}
+
+	other := &Config{AuthKeyId: "keyId-test", AuthKey: "rotated-secret"}
+	if _, ok := cache.load(other); ok {
+		t.Error("load() returned a token cached for different credentials")
+	}
+
+	cache.remove(config)
+	if _, ok := cache.load(config); ok {
+		t.Error("load() found a token after remove()")
+	}
+
+	var nilCache *TokenCache
+	nilCache.store(config, &AuthResponse{ApiKey: "api-key", Token: "token"})
+	if _, ok := nilCache.load(config); ok {
+		t.Error("nil TokenCache returned a token")
+	}
+}
+
+func TestTokenCacheExpired(t *testing.T) {
+	cache := &TokenCache{Dir: t.TempDir()}
+	config := &Config{Email: "user@example.com", Password: "secret"}
+
+	data, _ := json.Marshal(cachedToken{ApiKey: "api-key", Token: "token", ExpiresAt: time.Now().Add(time.Minute)})
+	if err := os.WriteFile(cache.path(config), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := cache.load(config); ok {
+		t.Error("load() returned a token that is about to expire")
+	}
+}
+
+func TestNewUsesCachedToken(t *testing.T) {
+	logins := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/auth":
+			logins++
+			json.NewEncoder(w).Encode(AuthResponse{ApiKey: "api-key", Token: "fresh-token"})
+		default:
+			if r.Header.Get("x-soracom-token") != "fresh-token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				json.NewEncoder(w).Encode(ErrorResponse{Code: "AUT0002", Message: "expired"})
+				return
+			}
+			w.Write([]byte(`{"status":"ok"}`))
+		}
+	}))
+	defer server.Close()
+
+	cache := &TokenCache{Dir: t.TempDir()}
+	config := &Config{AuthKeyId: "keyId-test", AuthKey: "secret-test", Endpoint: server.URL}
+	opts := Options{Config: config, TokenCache: cache}
+
+	if _, err := New(context.Background(), opts); err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	client, err := New(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if logins != 1 {
+		t.Errorf("logins = %d, want 1 with a cached token", logins)
+	}
+
+	// A cached token the server no longer accepts is replaced on first use
+	client.setCredentials("api-key", "revoked-token")
+	if _, err := client.makeRequest(context.Background(), "GET", client.apiURL("/v1/analysis/schemas"), nil); err != nil {
+		t.Fatalf("makeRequest() with a revoked token: error = %v", err)
+	}
+	if logins != 2 {
+		t.Errorf("logins = %d, want a second login after the 401", logins)
+	}
+}
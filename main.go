@@ -3,91 +3,57 @@ package main
 import (
 	"bufio"
 	"bytes"
-	"compress/gzip"
+	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"log/slog"
+	"math"
+	"math/big"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"reflect"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/c-bata/go-prompt"
+	"github.com/mattn/go-runewidth"
+	parquetgo "github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/compress"
+	"github.com/parquet-go/parquet-go/encoding"
+	"github.com/parquet-go/parquet-go/format"
+	"golang.org/x/term"
+	_ "modernc.org/sqlite"
+
+	"soraql/analysis"
+	"soraql/analysis/analysistest"
 )
 
-type Config struct {
-	Email        string            `json:"email"`
-	Password     string            `json:"password"`
-	AuthKeyId    string            `json:"authKeyId"`
-	AuthKey      string            `json:"authKey"`
-	CoverageType string            `json:"coverageType"`
-	Endpoint     string            `json:"endpoint"`
-	Headers      map[string]string `json:"headers"`
-}
-
-type AuthResponse struct {
-	ApiKey string `json:"apiKey"`
-	Token  string `json:"token"`
-}
-
-type QueryResponse struct {
-	QueryId string `json:"queryId"`
-}
-
-type ColumnInfo struct {
-	Name         string `json:"name"`
-	Type         string `json:"type"`
-	DatabaseType string `json:"databaseType"`
-}
-
-type QueryStatusResponse struct {
-	Status     string       `json:"status"`
-	URL        string       `json:"url"`
-	ColumnInfo []ColumnInfo `json:"columnInfo"`
-}
-
-type ErrorResponse struct {
-	Code    string `json:"code"`
-	Message string `json:"message"`
-}
-
-type SQLAssistantMessage struct {
-	Role      string `json:"role"`
-	Context   string `json:"context"`
-	AgentMode bool   `json:"agentMode"`
-}
-
-type SQLAssistantTimeRange struct {
-	Hours int `json:"hours"`
-}
-
-type SQLAssistantRequest struct {
-	Messages      []SQLAssistantMessage  `json:"messages"`
-	TimeRange     SQLAssistantTimeRange  `json:"timeRange"`
-	ExistingQuery string                 `json:"existing_query"`
-}
-
-type SQLAssistantResponse struct {
-	ID            string                 `json:"id"`
-	SQLQuery      string                 `json:"sql_query"`
-	Context       string                 `json:"context"`
-	Visualization map[string]interface{} `json:"visualization"`
-}
-
 type Client struct {
-	httpClient        *http.Client
-	baseURL           string
-	authBaseURL       string
-	apiKey            string
-	token             string
-	customHeaders     map[string]string
+	api               *analysis.Client
 	debug             bool
 	silent            bool
 	format            string
+	expanded          string // Expanded display of table results: off, on or auto
+	maxColumnWidth    int    // Truncate table cells wider than this, 0 for no limit
+	pager             bool   // Page table results that don't fit the terminal through $PAGER
+	output            string // File that -format parquet and sqlite write to
+	table             string // Table that -format sqlite writes to
+	ifExists          string // What -format sqlite does with an existing table: fail, append or replace
+	lastResult        *analysis.QueryStatusResponse // Result of the last single-profile query, for .export
 	history           []string
 	fromTime          int64
 	toTime            int64
@@ -97,18 +63,82 @@ type Client struct {
 	currentInput      string // Current input being typed
 	tempHistoryEntry  string // Temporary entry for current session
 	profileName       string // Profile name for prompt display
+	timeout           time.Duration // Per-query deadline, 0 for none
+	pollInterval      time.Duration // Initial delay between status checks
+	maxWait           time.Duration // Give up polling after this long, 0 for no limit
+	queryStatus       atomic.Value  // Latest query status shown by the spinner
+	submitOnly        bool          // Print query IDs instead of waiting for results
+	keepDir           string        // Directory that keeps the raw JSONL of every query, "" for none
+	maxDownloadSize   int64         // Abort result downloads larger than this many bytes, 0 for no limit
+	resultMu          sync.Mutex    // Guards resultDir and resultOpened, which the exit path reads
+	resultDir         string        // Private directory for result files, removed on exit
+	resultOpened      bool          // A file in resultDir was handed to an editor
+	logger            *slog.Logger  // Diagnostics, written to stderr or -log-file
+	logLevel          *slog.LevelVar // Current log level, lowered to debug by .debug
+	baseLogLevel      slog.Level    // Log level to restore when .debug is turned off
+	apiOptions        analysis.Options // Options of the API client, reused by .profile
+	fanout            []fanoutTarget   // Profiles every query runs under at once, set by -profiles and .fanout
 }
 
+// defaultMaxWait bounds how long a query is polled unless -max-wait says otherwise
+const defaultMaxWait = 30 * time.Minute
+
+// defaultMaxColumnWidth is the widest a table cell is drawn unless
+// -max-column-width says otherwise
+const defaultMaxColumnWidth = 50
+
 func main() {
+	// Subcommands come before the regular flags
+	if len(os.Args) > 1 && os.Args[1] == "mock-server" {
+		if err := runMockServer(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Mock server failed: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	var (
 		profile    = flag.String("profile", "", "Soracom CLI profile to use (default: 'default')")
+		profiles   = flag.String("profiles", "", "Run each query under these comma-separated profiles at once, e.g. 'prod,staging'")
+		apiKey     = flag.String("api-key", "", "API key to use instead of logging in (requires -api-token)")
+		apiToken   = flag.String("api-token", "", "API token to use instead of logging in (requires -api-key)")
+		credProc   = flag.String("credential-process", "", "Shell command printing credentials as JSON, overriding the profile's credentialProcess")
 		sqlQuery   = flag.String("sql", "", "SQL query to execute")
 		schemaOnly = flag.Bool("schema", false, "Retrieve schema information only")
+		submitOnly = flag.Bool("submit", false, "Submit the query, print its query ID and exit without waiting")
+		fetchID    = flag.String("fetch", "", "Wait for an existing query by ID and display its results")
+		statusID   = flag.String("status", "", "Show the status of an existing query by ID")
 		debug      = flag.Bool("debug", false, "Enable debug mode")
+		unsafeDbg  = flag.Bool("debug-unsafe", false, "Enable debug mode without masking passwords, keys and tokens")
+		logFile    = flag.String("log-file", "", "Append diagnostics to this file instead of stderr")
+		logFormat  = flag.String("log-format", "text", "Log format: text, json")
+		logLevel   = flag.String("log-level", "warn", "Minimum log level: debug, info, warn, error (default: debug with -debug)")
 		openFile   = flag.Bool("open", false, "Open result file in text editor")
+		keepDir    = flag.String("keep-results", "", "Keep the raw JSONL results of each query in this directory")
+		maxDL      = flag.String("max-download-size", "", "Abort result downloads larger than this, e.g. '500MB' (default: no limit)")
+		retries    = flag.Int("retries", analysis.DefaultRetries, "Retry transient API and download failures this many times (0 disables retries)")
+		recordFile = flag.String("record", "", "Record every HTTP exchange, with credentials redacted, to this file")
+		replayFile = flag.String("replay", "", "Replay HTTP exchanges from a -record file instead of using the network")
+		noCache    = flag.Bool("no-token-cache", false, "Always log in instead of reusing the cached API token")
+		caCert     = flag.String("ca-cert", "", "PEM file of CA certificates to trust in addition to the system roots")
+		clientCert = flag.String("client-cert", "", "PEM file of a client certificate for mutual TLS (requires -client-key)")
+		clientKey  = flag.String("client-key", "", "PEM file of the private key of -client-cert")
+		insecure   = flag.Bool("insecure-skip-verify", false, "Do not verify server certificates (unsafe, for diagnosing certificate problems only)")
+		proxy      = flag.String("proxy", "", "Proxy URL for all requests, or 'direct' for none (default: HTTPS_PROXY/HTTP_PROXY)")
+		noProxy    = flag.String("no-proxy", "", "Comma-separated hosts and domains to reach without -proxy")
+		connectTO  = flag.Duration("connect-timeout", 0, "Maximum time to establish a connection, including the TLS handshake (default: 30s)")
+		readTO     = flag.Duration("read-timeout", 0, "Fail requests and downloads that receive no data for this long (default: no limit)")
 		fromTime   = flag.String("from", "", "Start time for query (Unix timestamp in seconds or relative time like '-24h')")
 		toTime     = flag.String("to", "", "End time for query (Unix timestamp in seconds or relative time like 'now')")
-		format     = flag.String("format", "table", "Output format: table, csv, json")
+		format     = flag.String("format", "table", "Output format: table, vertical, csv, json, jsonl, parquet, sqlite (parquet and sqlite write VARIANT, OBJECT and ARRAY columns as JSON text)")
+		output     = flag.String("o", "", "File to write -format parquet or sqlite results to")
+		table      = flag.String("table", "results", "Table that -format sqlite writes to")
+		ifExists   = flag.String("if-exists", "fail", "What -format sqlite does when the table exists: fail, append, replace")
+		maxColumn  = flag.Int("max-column-width", defaultMaxColumnWidth, "Truncate table cells wider than this, 0 for no limit")
+		noPager    = flag.Bool("no-pager", false, "Print table results directly instead of through $PAGER when they don't fit the terminal")
+		timeout    = flag.Duration("timeout", 0, "Maximum time for each query, e.g. '30s' or '10m' (default: no limit)")
+		pollEvery  = flag.Duration("poll-interval", analysis.DefaultPollInterval, "Initial delay between query status checks; it backs off exponentially")
+		maxWait    = flag.Duration("max-wait", defaultMaxWait, "Stop polling a query after this long (0 for no limit)")
 		silent     = flag.Bool("s", false, "Silent mode - suppress animations (default: true for piped input)")
 		silentLong = flag.Bool("silent", false, "Silent mode - suppress animations (default: true for piped input)")
 		help       = flag.Bool("h", false, "Show help")
@@ -133,33 +163,281 @@ func main() {
 	}
 
 	// Validate format option
-	if *format != "table" && *format != "csv" && *format != "json" {
-		fmt.Fprintf(os.Stderr, "Invalid format '%s'. Supported formats: table, csv, json\n", *format)
+	if *format != "table" && *format != "vertical" && *format != "csv" && *format != "json" && *format != "jsonl" && *format != "parquet" && *format != "sqlite" {
+		fmt.Fprintf(os.Stderr, "Invalid format '%s'. Supported formats: table, vertical, csv, json, jsonl, parquet, sqlite\n", *format)
+		os.Exit(1)
+	}
+	if isFileFormat(*format) && *output == "" {
+		fmt.Fprintf(os.Stderr, "-format %s requires -o FILE\n", *format)
+		os.Exit(1)
+	}
+	if *output != "" && !isFileFormat(*format) {
+		fmt.Fprintln(os.Stderr, "-o is only used with -format parquet or sqlite; redirect the output of other formats instead")
+		os.Exit(1)
+	}
+	if *table == "" {
+		fmt.Fprintln(os.Stderr, "-table must not be empty")
+		os.Exit(1)
+	}
+	if !validIfExists(*ifExists) {
+		fmt.Fprintf(os.Stderr, "Invalid -if-exists '%s': use fail, append or replace\n", *ifExists)
+		os.Exit(1)
+	}
+	if *maxColumn < 0 {
+		fmt.Fprintf(os.Stderr, "Invalid -max-column-width %d: must be 0 or more\n", *maxColumn)
+		os.Exit(1)
+	}
+	if *pollEvery <= 0 {
+		fmt.Fprintf(os.Stderr, "Invalid poll interval '%s': must be a positive duration like '500ms'\n", *pollEvery)
+		os.Exit(1)
+	}
+	if *maxWait < 0 {
+		fmt.Fprintf(os.Stderr, "Invalid max wait '%s': must not be negative\n", *maxWait)
+		os.Exit(1)
+	}
+
+	if *retries < 0 {
+		fmt.Fprintf(os.Stderr, "Invalid retries '%d': must not be negative\n", *retries)
+		os.Exit(1)
+	}
+	// The API client treats 0 as the default and a negative value as none
+	retryOption := *retries
+	if retryOption == 0 {
+		retryOption = -1
+	}
+
+	maxDownloadSize, err := parseByteSize(*maxDL)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid max download size '%s': %v\n", *maxDL, err)
+		os.Exit(1)
+	}
+
+	if *fetchID != "" && *statusID != "" {
+		fmt.Fprintln(os.Stderr, "-fetch and -status cannot be used together")
+		os.Exit(1)
+	}
+	if (*fetchID != "" || *statusID != "") && (*sqlQuery != "" || *submitOnly) {
+		fmt.Fprintln(os.Stderr, "-fetch and -status cannot be combined with -sql or -submit")
+		os.Exit(1)
+	}
+	if *submitOnly && *sqlQuery == "" && !isPipedInput() {
+		fmt.Fprintln(os.Stderr, "-submit requires a query via -sql or piped input")
 		os.Exit(1)
 	}
 
 	// Determine silent mode - default to true for piped input or -sql mode, false for interactive
-	silentMode := *silent || *silentLong || isPipedInput() || *sqlQuery != ""
+	silentMode := *silent || *silentLong || isPipedInput() || *sqlQuery != "" || *fetchID != ""
 
-	client := &Client{
-		httpClient:  &http.Client{},
-		debug:       *debug,
-		silent:      silentMode,
-		format:      *format,
-		fromTime:    fromUnix,
-		toTime:      toUnix,
-		profileName: profileName,
+	if *recordFile != "" && *replayFile != "" {
+		fmt.Fprintln(os.Stderr, "-record and -replay cannot be used together")
+		os.Exit(1)
+	}
+
+	if (*apiKey == "") != (*apiToken == "") {
+		fmt.Fprintln(os.Stderr, "-api-key and -api-token must be used together")
+		os.Exit(1)
+	}
+
+	var fanoutNames []string
+	if *profiles != "" {
+		if fanoutNames, err = parseProfileList(*profiles); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid -profiles: %v\n", err)
+			os.Exit(1)
+		}
+		if *profile != "" || *apiKey != "" || *credProc != "" {
+			fmt.Fprintln(os.Stderr, "-profiles cannot be combined with -profile, -api-key or -credential-process")
+			os.Exit(1)
+		}
+		if *submitOnly || *fetchID != "" || *statusID != "" || *replayFile != "" {
+			fmt.Fprintln(os.Stderr, "-profiles cannot be combined with -submit, -fetch, -status or -replay")
+			os.Exit(1)
+		}
+	}
+
+	if (*clientCert == "") != (*clientKey == "") {
+		fmt.Fprintln(os.Stderr, "-client-cert and -client-key must be used together")
+		os.Exit(1)
+	}
+	if *connectTO < 0 || *readTO < 0 {
+		fmt.Fprintln(os.Stderr, "-connect-timeout and -read-timeout must not be negative")
+		os.Exit(1)
+	}
+	if *insecure {
+		fmt.Fprintln(os.Stderr, "WARNING: -insecure-skip-verify disables TLS certificate verification. Credentials and query results can be intercepted and altered.")
+	}
+
+	if *unsafeDbg {
+		*debug = true
+		fmt.Fprintln(os.Stderr, "WARNING: -debug-unsafe prints passwords, auth keys and API tokens in clear text. Do not share this output.")
+	}
+
+	var baseLogLevel slog.Level
+	if err := baseLogLevel.UnmarshalText([]byte(*logLevel)); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid log level '%s'. Supported levels: debug, info, warn, error\n", *logLevel)
+		os.Exit(1)
+	}
+	level := new(slog.LevelVar)
+	level.Set(baseLogLevel)
+	if *debug && !isFlagSet("log-level") {
+		level.Set(slog.LevelDebug)
+	}
+
+	logOutput := io.Writer(os.Stderr)
+	if *logFile != "" {
+		file, err := os.OpenFile(*logFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to open log file: %v\n", err)
+			os.Exit(1)
+		}
+		defer file.Close()
+		logOutput = file
+	}
+	logger, err := newLogger(logOutput, *logFormat, level)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid -log-format: %v\n", err)
+		os.Exit(1)
+	}
+
+	apiOptions := analysis.Options{
+		Profile:           *profile,
+		APIKey:            *apiKey,
+		APIToken:          *apiToken,
+		CredentialProcess: *credProc,
+		PromptMFA:         promptMFA,
+		Retries:           retryOption,
+		Network: analysis.NetworkOptions{
+			CACert:             *caCert,
+			ClientCert:         *clientCert,
+			ClientKey:          *clientKey,
+			InsecureSkipVerify: *insecure,
+			Proxy:              *proxy,
+			NoProxy:            *noProxy,
+			ConnectTimeout:     *connectTO,
+			ReadTimeout:        *readTO,
+		},
+		Logger:      logger,
+		Debug:       *debug,
+		DebugUnsafe: *unsafeDbg,
+	}
+	if *recordFile != "" {
+		// Recordings may contain customer data even with credentials redacted
+		file, err := os.OpenFile(*recordFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to create recording: %v\n", err)
+			os.Exit(1)
+		}
+		defer file.Close()
+		// The recorder sends requests with the client New builds from the network settings
+		apiOptions.HTTPClient = analysis.NewRecorder(nil, file)
+	}
+	if *replayFile != "" {
+		file, err := os.Open(*replayFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to open recording: %v\n", err)
+			os.Exit(1)
+		}
+		replayer, err := analysis.NewReplayer(file)
+		file.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to load recording: %v\n", err)
+			os.Exit(1)
+		}
+		apiOptions.HTTPClient = replayer
+		// The recording answers /v1/auth, so no real credentials are needed
+		apiOptions.Config = &analysis.Config{AuthKeyId: "replay", AuthKey: "replay"}
+		// Replay status checks back to back unless asked otherwise
+		if !isFlagSet("poll-interval") {
+			*pollEvery = time.Millisecond
+		}
+	}
+	// Recordings must contain the /v1/auth exchange to be replayable
+	if !*noCache && *recordFile == "" && *replayFile == "" {
+		if cache, err := analysis.DefaultTokenCache(); err == nil {
+			apiOptions.TokenCache = cache
+		} else {
+			logger.Warn("Token cache disabled", "error", err)
+		}
 	}
 
-	if err := client.authenticate(profileName); err != nil {
+	authCtx, cancelAuth := newCommandContext(*timeout)
+	var api *analysis.Client
+	var fanout []fanoutTarget
+	if len(fanoutNames) > 0 {
+		// Other commands than queries use the first profile that logged in
+		fanout, err = loginProfiles(authCtx, apiOptions, fanoutNames)
+		if err == nil {
+			api = fanout[0].api
+			profileName = fanout[0].profile
+			apiOptions.Profile = profileName
+		}
+	} else {
+		api, err = analysis.New(authCtx, apiOptions)
+	}
+	// Take the cause before cancelling, which would turn every error into "context canceled"
+	err = contextCause(authCtx, err)
+	cancelAuth(nil)
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "Authentication failed: %v\n", err)
 		os.Exit(1)
 	}
 
+	client := &Client{
+		api:             api,
+		debug:           *debug,
+		silent:          silentMode,
+		format:          *format,
+		output:          *output,
+		table:           *table,
+		ifExists:        *ifExists,
+		maxColumnWidth:  *maxColumn,
+		pager:           !*noPager,
+		fromTime:        fromUnix,
+		toTime:          toUnix,
+		profileName:     profileName,
+		timeout:         *timeout,
+		pollInterval:    *pollEvery,
+		maxWait:         *maxWait,
+		submitOnly:      *submitOnly,
+		keepDir:         *keepDir,
+		maxDownloadSize: maxDownloadSize,
+		logger:          logger,
+		logLevel:        level,
+		baseLogLevel:    baseLogLevel,
+		apiOptions:      apiOptions,
+		fanout:          fanout,
+	}
+
+	// Remove private result files on exit, including when terminated, and
+	// those an editor was still reading when an earlier run exited
+	removeLeftoverResults()
+	defer client.cleanupResults()
+	terminate := make(chan os.Signal, 1)
+	signal.Notify(terminate, syscall.SIGTERM)
+	go func() {
+		<-terminate
+		client.exit(1)
+	}()
+
 	if *schemaOnly {
 		if err := client.getSchemas(); err != nil {
 			fmt.Fprintf(os.Stderr, "Failed to get schemas: %v\n", err)
-			os.Exit(1)
+			client.exit(1)
+		}
+		return
+	}
+
+	if *statusID != "" {
+		if err := client.showQueryStatus(*statusID); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to get query status: %v\n", err)
+			client.exit(1)
+		}
+		return
+	}
+
+	if *fetchID != "" {
+		if err := client.fetchQuery(*fetchID, *openFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to fetch query: %v\n", err)
+			client.exit(1)
 		}
 		return
 	}
@@ -167,9 +445,13 @@ func main() {
 	// Determine mode: single query or interactive
 	if *sqlQuery != "" {
 		// Single query mode
-		if err := client.executeQuery(*sqlQuery, *openFile); err != nil {
+		if err := client.runQuery(*sqlQuery, *openFile); err != nil {
 			fmt.Fprintf(os.Stderr, "Failed to execute query: %v\n", err)
-			os.Exit(1)
+			client.exit(1)
+		}
+		// A profile of -profiles that could not log in fails the query, too
+		if len(fanout) < len(fanoutNames) {
+			client.exit(1)
 		}
 	} else if isPipedInput() {
 		// Piped input mode - process each line as a separate query
@@ -180,6 +462,47 @@ func main() {
 	}
 }
 
+// runMockServer implements "soraql mock-server", a local stand-in for the
+// Soracom analysis API that serves JSONL fixtures over plain HTTP.
+func runMockServer(args []string) error {
+	flags := flag.NewFlagSet("mock-server", flag.ExitOnError)
+	addr := flags.String("addr", "127.0.0.1:8080", "Address to listen on")
+	fixturesDir := flags.String("fixtures", "", "Directory of <TABLE>.jsonl fixtures and an optional schema.json (default: built-in sample data)")
+	schemaFile := flags.String("schema", "", "Schema file served by /v1/analysis/schemas (default: <fixtures>/schema.json or generated)")
+	flags.Parse(args)
+
+	fixtures := analysistest.DefaultFixtures()
+	if *fixturesDir != "" {
+		var err error
+		if fixtures, err = analysistest.LoadFixtures(*fixturesDir); err != nil {
+			return err
+		}
+	}
+	if *schemaFile != "" {
+		schema, err := os.ReadFile(*schemaFile)
+		if err != nil {
+			return fmt.Errorf("failed to read schema file: %v", err)
+		}
+		if !json.Valid(schema) {
+			return fmt.Errorf("schema file '%s' is not valid JSON", *schemaFile)
+		}
+		fixtures.Schema = schema
+	}
+
+	listener, err := net.Listen("tcp", *addr)
+	if err != nil {
+		return err
+	}
+
+	endpoint := "http://" + listener.Addr().String()
+	fmt.Printf("Mock Soracom API listening on %s\n", endpoint)
+	fmt.Println("Point a profile at it, e.g. ~/.soracom/mock.json:")
+	fmt.Printf("  {\"authKeyId\": \"keyId-mock\", \"authKey\": \"secret-mock\", \"endpoint\": \"%s\"}\n", endpoint)
+	fmt.Println("and run: soraql -profile mock")
+
+	return http.Serve(listener, analysistest.NewHandler(fixtures))
+}
+
 func (c *Client) runInteractiveMode(openFile bool) {
 	c.loadHistory()
 	c.historyIndex = 0 // Initialize history navigation
@@ -197,7 +520,7 @@ func (c *Client) runInteractiveMode(openFile bool) {
 		// Check for exit commands
 		if isExitCommand(input) {
 			c.saveHistory()
-			os.Exit(0)
+			c.exit(0)
 		}
 
 		// Handle multi-line input
@@ -230,195 +553,8 @@ func (c *Client) runInteractiveMode(openFile bool) {
 			}
 		}
 
-		// Check for special commands
-		if strings.ToLower(input) == ".tables" {
-			if err := c.showTables(); err != nil {
-				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-			}
-			return
-		}
-
-		// Check for .schema command
-		if strings.HasPrefix(strings.ToLower(input), ".schema") {
-			parts := strings.Fields(input)
-			var tableName string
-			if len(parts) > 1 {
-				tableName = strings.TrimRight(parts[1], ";")
-			}
-			if err := c.showSchema(tableName); err != nil {
-				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-			}
-			return
-		}
-
-		// Check for .ask command (SQL assistant)
-		if strings.HasPrefix(strings.ToLower(input), ".ask ") {
-			context := strings.TrimSpace(input[5:]) // Remove ".ask " prefix
-			if context == "" {
-				fmt.Println("Usage: .ask <your question about SQL or data>")
-				return
-			}
-			
-			// Use existing query from history if available
-			existingQuery := ""
-			if len(c.history) > 0 {
-				// Look for the last SQL query (not a command)
-				for i := len(c.history) - 1; i >= 0; i-- {
-					h := strings.TrimSpace(c.history[i])
-					if !strings.HasPrefix(h, ".") && !isExitCommand(h) {
-						existingQuery = h
-						break
-					}
-				}
-			}
-			
-			// Show animation while waiting for SQL assistant (unless in silent mode)
-			var stopAnimation chan bool
-			if !c.silent {
-				stopAnimation = make(chan bool)
-				go func() {
-					c.showSQLAssistantAnimation(stopAnimation)
-				}()
-			}
-			
-			response, err := c.callSQLAssistant(context, existingQuery)
-			
-			// Stop animation
-			if !c.silent {
-				stopAnimation <- true
-			}
-			
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-				return
-			}
-			
-			// Display response without header
-			if response.Context != "" {
-				fmt.Printf("\n%s\n", response.Context)
-			}
-			if response.SQLQuery != "" {
-				fmt.Printf("\nSuggested SQL:\n%s\n", response.SQLQuery)
-				fmt.Printf("\n🚀 Executing query automatically...\n")
-				
-				// Auto-execute the suggested query (do not save to history since it's not user-typed)
-				err := c.executeQuery(response.SQLQuery, openFile)
-				
-				if err != nil {
-					fmt.Fprintf(os.Stderr, "Error executing query: %v\n", err)
-				}
-			}
-			fmt.Println()
-			return
-		}
-
-		// Check for .window command (time window settings)
-		if strings.HasPrefix(strings.ToLower(input), ".window") {
-			parts := strings.Fields(input)
-			if len(parts) == 1 || (len(parts) == 2 && strings.ToLower(parts[1]) == "show") {
-				// Show current window settings
-				c.showCurrentWindow()
-			} else if len(parts) == 2 && strings.ToLower(parts[1]) == "clear" {
-				// Clear window settings
-				c.clearWindow()
-				fmt.Println("Time window cleared.")
-			} else if len(parts) == 3 {
-				// Set window with from and to parameters
-				fromStr := parts[1]
-				toStr := parts[2]
-				if err := c.setWindow(fromStr, toStr); err != nil {
-					fmt.Fprintf(os.Stderr, "Error setting window: %v\n", err)
-				} else {
-					fmt.Printf("Time window set: from %s to %s\n", fromStr, toStr)
-					c.showCurrentWindow()
-				}
-			} else {
-				fmt.Println("Usage: .window [show|clear|<from> <to>]")
-				fmt.Println("Examples:")
-				fmt.Println("  .window show          # Show current time window")
-				fmt.Println("  .window clear         # Clear time window")
-				fmt.Println("  .window -24h now      # Set window from 24 hours ago to now")
-				fmt.Println("  .window 1640995200 1641081600  # Set specific timestamps")
-			}
-			return
-		}
-
-		// Check for .debug command (toggle debug mode)
-		if strings.HasPrefix(strings.ToLower(input), ".debug") {
-			parts := strings.Fields(input)
-			if len(parts) == 1 {
-				// Toggle debug mode
-				c.debug = !c.debug
-				if c.debug {
-					fmt.Println("Debug mode enabled.")
-				} else {
-					fmt.Println("Debug mode disabled.")
-				}
-			} else if len(parts) == 2 {
-				switch strings.ToLower(parts[1]) {
-				case "on", "true", "1":
-					c.debug = true
-					fmt.Println("Debug mode enabled.")
-				case "off", "false", "0":
-					c.debug = false
-					fmt.Println("Debug mode disabled.")
-				case "show", "status":
-					if c.debug {
-						fmt.Println("Debug mode is currently enabled.")
-					} else {
-						fmt.Println("Debug mode is currently disabled.")
-					}
-				default:
-					fmt.Println("Usage: .debug [on|off|show]")
-					fmt.Println("Examples:")
-					fmt.Println("  .debug        # Toggle debug mode")
-					fmt.Println("  .debug on     # Enable debug mode")
-					fmt.Println("  .debug off    # Disable debug mode")
-					fmt.Println("  .debug show   # Show current debug status")
-				}
-			} else {
-				fmt.Println("Usage: .debug [on|off|show]")
-				fmt.Println("Examples:")
-				fmt.Println("  .debug        # Toggle debug mode")
-				fmt.Println("  .debug on     # Enable debug mode")
-				fmt.Println("  .debug off    # Disable debug mode")
-				fmt.Println("  .debug show   # Show current debug status")
-			}
-			return
-		}
-
-		// Check for .format command (set output format)
-		if strings.HasPrefix(strings.ToLower(input), ".format") {
-			parts := strings.Fields(input)
-			if len(parts) == 1 {
-				// Show current format
-				fmt.Printf("Current output format: %s\n", c.format)
-			} else if len(parts) == 2 {
-				newFormat := strings.ToLower(parts[1])
-				switch newFormat {
-				case "table", "csv", "json":
-					c.format = newFormat
-					fmt.Printf("Output format set to: %s\n", newFormat)
-				case "show", "status":
-					fmt.Printf("Current output format: %s\n", c.format)
-				default:
-					fmt.Println("Usage: .format [table|csv|json|show]")
-					fmt.Println("Examples:")
-					fmt.Println("  .format           # Show current format")
-					fmt.Println("  .format table     # Set format to table")
-					fmt.Println("  .format csv       # Set format to CSV")
-					fmt.Println("  .format json      # Set format to JSON")
-					fmt.Println("  .format show      # Show current format")
-				}
-			} else {
-				fmt.Println("Usage: .format [table|csv|json|show]")
-				fmt.Println("Examples:")
-				fmt.Println("  .format           # Show current format")
-				fmt.Println("  .format table     # Set format to table")
-				fmt.Println("  .format csv       # Set format to CSV")
-				fmt.Println("  .format json      # Set format to JSON")
-				fmt.Println("  .format show      # Show current format")
-			}
+		// Dot commands are complete without a semicolon
+		if c.handleDotCommand(input, openFile) {
 			return
 		}
 
@@ -448,7 +584,7 @@ func (c *Client) runInteractiveMode(openFile bool) {
 		if c.inMultiLine {
 			return "   ...> ", true
 		}
-		return c.profileName + "> ", true
+		return c.promptName() + "> ", true
 	}
 
 	// Custom key bind for history navigation
@@ -470,7 +606,7 @@ func (c *Client) runInteractiveMode(openFile bool) {
 	p := prompt.New(
 		executor,
 		completer,
-		prompt.OptionPrefix(c.profileName+"> "),
+		prompt.OptionPrefix(c.promptName()+"> "),
 		prompt.OptionLivePrefix(prefixFunc),
 		prompt.OptionTitle("SoraQL Interactive SQL Client"),
 		prompt.OptionMaxSuggestion(10),
@@ -517,6 +653,17 @@ func (c *Client) getInteractiveQuery(scanner *bufio.Scanner) string {
 	return strings.TrimSpace(query)
 }
 
+// isFlagSet reports whether the named flag was given on the command line
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
 func isPipedInput() bool {
 	stat, err := os.Stdin.Stat()
 	if err != nil {
@@ -540,144 +687,16 @@ func (c *Client) runPipedMode(openFile bool) {
 			break
 		}
 		
-		// Check for special commands
-		if strings.ToLower(strings.TrimSpace(line)) == ".tables" {
-			if err := c.showTables(); err != nil {
-				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-			}
-			continue
-		}
-
-		// Check for .schema command
-		trimmedLine := strings.TrimSpace(line)
-		if strings.HasPrefix(strings.ToLower(trimmedLine), ".schema") {
-			parts := strings.Fields(trimmedLine)
-			var tableName string
-			if len(parts) > 1 {
-				tableName = strings.TrimRight(parts[1], ";")
-			}
-			if err := c.showSchema(tableName); err != nil {
-				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-			}
-			continue
-		}
-
-		// Check for .window command (time window settings)
-		if strings.HasPrefix(strings.ToLower(trimmedLine), ".window") {
-			parts := strings.Fields(trimmedLine)
-			if len(parts) == 1 || (len(parts) == 2 && strings.ToLower(parts[1]) == "show") {
-				// Show current window settings
-				c.showCurrentWindow()
-			} else if len(parts) == 2 && strings.ToLower(parts[1]) == "clear" {
-				// Clear window settings
-				c.clearWindow()
-				fmt.Println("Time window cleared.")
-			} else if len(parts) == 3 {
-				// Set window with from and to parameters
-				fromStr := parts[1]
-				toStr := parts[2]
-				if err := c.setWindow(fromStr, toStr); err != nil {
-					fmt.Fprintf(os.Stderr, "Error setting window: %v\n", err)
-				} else {
-					fmt.Printf("Time window set: from %s to %s\n", fromStr, toStr)
-					c.showCurrentWindow()
-				}
-			} else {
-				fmt.Println("Usage: .window [show|clear|<from> <to>]")
-				fmt.Println("Examples:")
-				fmt.Println("  .window show          # Show current time window")
-				fmt.Println("  .window clear         # Clear time window")
-				fmt.Println("  .window -24h now      # Set window from 24 hours ago to now")
-				fmt.Println("  .window 1640995200 1641081600  # Set specific timestamps")
-			}
-			continue
-		}
-
-		// Check for .debug command (toggle debug mode)
-		if strings.HasPrefix(strings.ToLower(trimmedLine), ".debug") {
-			parts := strings.Fields(trimmedLine)
-			if len(parts) == 1 {
-				// Toggle debug mode
-				c.debug = !c.debug
-				if c.debug {
-					fmt.Println("Debug mode enabled.")
-				} else {
-					fmt.Println("Debug mode disabled.")
-				}
-			} else if len(parts) == 2 {
-				switch strings.ToLower(parts[1]) {
-				case "on", "true", "1":
-					c.debug = true
-					fmt.Println("Debug mode enabled.")
-				case "off", "false", "0":
-					c.debug = false
-					fmt.Println("Debug mode disabled.")
-				case "show", "status":
-					if c.debug {
-						fmt.Println("Debug mode is currently enabled.")
-					} else {
-						fmt.Println("Debug mode is currently disabled.")
-					}
-				default:
-					fmt.Println("Usage: .debug [on|off|show]")
-					fmt.Println("Examples:")
-					fmt.Println("  .debug        # Toggle debug mode")
-					fmt.Println("  .debug on     # Enable debug mode")
-					fmt.Println("  .debug off    # Disable debug mode")
-					fmt.Println("  .debug show   # Show current debug status")
-				}
-			} else {
-				fmt.Println("Usage: .debug [on|off|show]")
-				fmt.Println("Examples:")
-				fmt.Println("  .debug        # Toggle debug mode")
-				fmt.Println("  .debug on     # Enable debug mode")
-				fmt.Println("  .debug off    # Disable debug mode")
-				fmt.Println("  .debug show   # Show current debug status")
-			}
+		if c.handleDotCommand(line, openFile) {
 			continue
 		}
 
-		// Check for .format command (set output format)
-		if strings.HasPrefix(strings.ToLower(trimmedLine), ".format") {
-			parts := strings.Fields(trimmedLine)
-			if len(parts) == 1 {
-				// Show current format
-				fmt.Printf("Current output format: %s\n", c.format)
-			} else if len(parts) == 2 {
-				newFormat := strings.ToLower(parts[1])
-				switch newFormat {
-				case "table", "csv", "json":
-					c.format = newFormat
-					fmt.Printf("Output format set to: %s\n", newFormat)
-				case "show", "status":
-					fmt.Printf("Current output format: %s\n", c.format)
-				default:
-					fmt.Println("Usage: .format [table|csv|json|show]")
-					fmt.Println("Examples:")
-					fmt.Println("  .format           # Show current format")
-					fmt.Println("  .format table     # Set format to table")
-					fmt.Println("  .format csv       # Set format to CSV")
-					fmt.Println("  .format json      # Set format to JSON")
-					fmt.Println("  .format show      # Show current format")
-				}
-			} else {
-				fmt.Println("Usage: .format [table|csv|json|show]")
-				fmt.Println("Examples:")
-				fmt.Println("  .format           # Show current format")
-				fmt.Println("  .format table     # Set format to table")
-				fmt.Println("  .format csv       # Set format to CSV")
-				fmt.Println("  .format json      # Set format to JSON")
-				fmt.Println("  .format show      # Show current format")
-			}
-			continue
-		}
-		
 		// Remove trailing semicolon if present
 		query := strings.TrimSuffix(line, ";")
 		query = strings.TrimSpace(query)
 		
 		if query != "" {
-			if err := c.executeQuery(query, openFile); err != nil {
+			if err := c.runQuery(query, openFile); err != nil {
 				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 				// Don't exit, continue to next query
 			}
@@ -712,7 +731,18 @@ func (c *Client) getCompletions(d prompt.Document) []prompt.Suggest {
 		{Text: ".ask", Description: "Ask SQL assistant for help (.ask your question)"},
 		{Text: ".window", Description: "Set time window (.window show|clear|<from> <to>)"},
 		{Text: ".debug", Description: "Toggle debug mode (.debug on|off|show)"},
-		{Text: ".format", Description: "Set output format (.format table|csv|json|show)"},
+		{Text: ".format", Description: "Set output format (.format table|vertical|csv|json|jsonl|parquet|sqlite|show)"},
+		{Text: ".export", Description: "Save the last result to a SQLite database (.export sqlite <file> <table> [append|replace])"},
+		{Text: ".fetch", Description: "Display the results of a submitted query (.fetch <queryId>)"},
+		{Text: ".cancel", Description: "Cancel a running query on the server (.cancel <queryId>)"},
+		{Text: ".set", Description: "Show or change query settings (.set poll-interval|max-wait|timeout|max-column-width <value>)"},
+		{Text: ".expanded", Description: "Show table results as vertical records (.expanded on|off|auto)"},
+		{Text: ".pager", Description: "Page results that don't fit the terminal through $PAGER (.pager on|off|show)"},
+		{Text: ".timeout", Description: "Set per-query deadline (.timeout <duration>|off|show)"},
+		{Text: ".keep", Description: "Keep raw JSONL results in a directory (.keep <dir>|off|show)"},
+		{Text: ".profile", Description: "Switch to another profile, keeping the session settings (.profile <name>)"},
+		{Text: ".profiles", Description: "List the profiles in ~/.soracom"},
+		{Text: ".fanout", Description: "Run queries under several profiles at once (.fanout prod,staging|off)"},
 		
 		// SQL Keywords
 		{Text: "SELECT", Description: "Select data from table"},
@@ -882,28 +912,74 @@ func showHelp() {
 	fmt.Println("Usage: soraql [options]")
 	fmt.Println("       echo 'SQL_QUERY' | soraql [options]")
 	fmt.Println("       soraql [options]  (starts interactive mode)")
+	fmt.Println("       soraql mock-server [-addr HOST:PORT] [-fixtures DIR] [-schema FILE]")
 	fmt.Println("")
 	fmt.Println("Authentication options:")
 	fmt.Println("  -profile PROFILE: Specify Soracom CLI profile to use (default: 'default')")
 	fmt.Println("                    The profile determines coverage area (JP/Global) and endpoint")
+	fmt.Println("  -profiles A,B,...: Run each query under all of these profiles at once and merge the results")
+	fmt.Println("                     with a _profile column; other commands use the first profile")
+	fmt.Println("  -api-key KEY -api-token TOKEN: Use an existing API key and token instead of logging in")
+	fmt.Println("  -credential-process COMMAND: Run COMMAND to get credentials as JSON instead of reading them from the profile")
+	fmt.Println("  -no-token-cache: Always log in instead of reusing the API token cached from a previous run")
+	fmt.Println("")
+	fmt.Println("Network options (override the same settings in the profile):")
+	fmt.Println("  -ca-cert FILE: Trust the CA certificates in FILE (PEM) in addition to the system roots")
+	fmt.Println("  -client-cert FILE -client-key FILE: Present a client certificate for mutual TLS")
+	fmt.Println("  -insecure-skip-verify: Do not verify server certificates (unsafe; prints a warning)")
+	fmt.Println("  -proxy URL: Send requests through this proxy, or 'direct' for none (default: HTTPS_PROXY/HTTP_PROXY)")
+	fmt.Println("  -no-proxy HOSTS: Comma-separated hosts and domains to reach without the proxy")
+	fmt.Println("  -connect-timeout DURATION: Maximum time to connect, including the TLS handshake (default: 30s)")
+	fmt.Println("  -read-timeout DURATION: Fail requests and downloads that receive no data for DURATION (default: no limit)")
 	fmt.Println("")
 	fmt.Println("Query options:")
 	fmt.Println("  -sql \"QUERY\": Execute custom SQL query")
 	fmt.Println("  -schema: Retrieve and display schema information")
 	fmt.Println("  -from TIME: Start time for query (Unix timestamp, relative time like '-24h', or datetime)")
 	fmt.Println("  -to TIME: End time for query (Unix timestamp, relative time like 'now', or datetime)")
-	fmt.Println("  -format FORMAT: Output format - table, csv, json (default: table)")
+	fmt.Println("  -format FORMAT: Output format - table, vertical, csv, json, jsonl, parquet, sqlite (default: table)")
+	fmt.Println("                  parquet and sqlite write VARIANT, OBJECT and ARRAY columns as JSON text")
+	fmt.Println("  -o FILE: Write the results of -format parquet to FILE, replacing it, or of -format sqlite to the database FILE")
+	fmt.Println("  -table NAME: Table that -format sqlite creates and fills (default: results)")
+	fmt.Println("  -if-exists MODE: What -format sqlite does when the table exists - fail, append, replace (default: fail)")
+	fmt.Println("  -max-column-width N: Truncate table cells wider than N characters with '…', 0 for no limit (default: 50)")
+	fmt.Println("  -no-pager: Don't page table results that don't fit the terminal through $PAGER (default: less -S)")
+	fmt.Println("  -timeout DURATION: Cancel each query after DURATION, e.g. '30s' or '10m' (default: no limit)")
+	fmt.Println("  -poll-interval DURATION: Initial delay between status checks, backing off exponentially (default: 250ms)")
+	fmt.Println("  -max-wait DURATION: Stop polling a query after DURATION, 0 for no limit (default: 30m)")
 	fmt.Println("  -s, -silent: Silent mode - suppress animations (default: true for piped input and -sql mode)")
-	fmt.Println("  -debug: Show debug messages (authentication details, HTTP requests, etc.)")
+	fmt.Println("  -debug: Show debug messages (authentication details, HTTP requests, etc.) with credentials masked")
+	fmt.Println("  -debug-unsafe: Like -debug, but print passwords, auth keys, tokens and URL signatures unmasked")
+	fmt.Println("  -log-file FILE: Append diagnostics to FILE instead of stderr")
+	fmt.Println("  -log-format FORMAT: Log format - text, json (default: text)")
+	fmt.Println("  -log-level LEVEL: Minimum log level - debug, info, warn, error (default: warn, or debug with -debug)")
 	fmt.Println("  -open: Open downloaded result file in text editor")
+	fmt.Println("  -keep-results DIR: Save the raw JSONL results of each query in DIR")
+	fmt.Println("  -max-download-size SIZE: Abort result downloads larger than SIZE, e.g. '500MB' (default: no limit)")
+	fmt.Println("  -retries N: Retry transient API and download failures N times, 0 to disable (default: 3)")
+	fmt.Println("")
+	fmt.Println("Debugging options:")
+	fmt.Println("  -record FILE: Record every HTTP exchange to FILE, with tokens, passwords and auth keys redacted")
+	fmt.Println("  -replay FILE: Replay a recorded session offline instead of calling the API")
+	fmt.Println("")
+	fmt.Println("Asynchronous query options:")
+	fmt.Println("  -submit: Submit the query (-sql or piped input), print its query ID and exit")
+	fmt.Println("  -fetch QUERY_ID: Wait for a submitted query and display its results")
+	fmt.Println("  -status QUERY_ID: Show the status, result URL and columns of a query")
 	fmt.Println("")
 	fmt.Println("Examples:")
 	fmt.Println("  soraql -sql \"select count(*) from SIM_SNAPSHOTS\"")
 	fmt.Println("  soraql -profile myprofile -sql \"select count(*) from CELL_TOWERS\"")
+	fmt.Println("  soraql -profiles prod,staging -sql \"select count(*) from SIM_SNAPSHOTS\"")
 	fmt.Println("  soraql -schema")
 	fmt.Println("  soraql -debug -sql \"select count(*) from SIM_SNAPSHOTS\"")
 	fmt.Println("  soraql -open -sql \"select * from SIM_SESSION_EVENTS limit 5\"")
 	fmt.Println("")
+	fmt.Println("Asynchronous examples:")
+	fmt.Println("  soraql -submit -sql \"select * from SIM_SESSION_EVENTS\"   # Prints the query ID")
+	fmt.Println("  soraql -status QUERY_ID")
+	fmt.Println("  soraql -fetch QUERY_ID -format csv")
+	fmt.Println("")
 	fmt.Println("Time window examples:")
 	fmt.Println("  soraql -from '-24h' -to 'now' -sql \"select * from SIM_SESSION_EVENTS\"")
 	fmt.Println("  soraql -from '1640995200' -to '1641081600' -sql \"select * from SIM_SNAPSHOTS\"")
@@ -914,6 +990,7 @@ func showHelp() {
 	fmt.Println("  soraql -format csv -sql \"select * from SIM_SNAPSHOTS limit 5\"")
 	fmt.Println("  soraql -format json -sql \"select * from CELL_TOWERS limit 3\"")
 	fmt.Println("  soraql -format table -sql \"select count(*) from SIM_SESSION_EVENTS\"")
+	fmt.Println("  soraql -format vertical -sql \"select * from SIM_SNAPSHOTS limit 2\"")
 	fmt.Println("")
 	fmt.Println("Interactive mode:")
 	fmt.Println("  soraql                                    # Start interactive mode with default profile")
@@ -935,6 +1012,7 @@ func showHelp() {
 	fmt.Println("  • Tab completion with descriptions for SQL keywords and table names")
 	fmt.Println("  • Profile name shown in prompt (e.g., 'myprofile>', 'default>')")
 	fmt.Println("  • History persistence (~/.soraql_history)")
+	fmt.Println("  • ESC or Ctrl-C cancels the running query (also on the server) without leaving the shell")
 	fmt.Println("")
 	fmt.Println("Special commands:")
 	fmt.Println("  .tables                                   # Show all available tables")
@@ -948,12 +1026,39 @@ func showHelp() {
 	fmt.Println("    .debug on                               # Enable debug mode")
 	fmt.Println("    .debug off                              # Disable debug mode")
 	fmt.Println("    .debug show                             # Show current debug status")
-	fmt.Println("  .format [table|csv|json|show]             # Set output format")
+	fmt.Println("  .format [table|vertical|csv|json|jsonl|parquet|sqlite|show] # Set output format")
 	fmt.Println("    .format                                 # Show current format")
 	fmt.Println("    .format table                           # Set format to table")
+	fmt.Println("    .format vertical                        # Show each row as a block of column | value lines")
 	fmt.Println("    .format csv                             # Set format to CSV")
 	fmt.Println("    .format json                            # Set format to JSON")
+	fmt.Println("    .format jsonl                           # Set format to JSON Lines")
+	fmt.Println("    .format parquet                         # Write results to the -o file as Parquet")
+	fmt.Println("    .format sqlite                          # Write results to -table in the -o database")
 	fmt.Println("    .format show                            # Show current format")
+	fmt.Println("  .fetch <queryId>                          # Display the results of a submitted query")
+	fmt.Println("  .cancel <queryId>                         # Cancel a running query on the server")
+	fmt.Println("  .set [<option> <value>]                   # Show or change query settings")
+	fmt.Println("    .set poll-interval 500ms                # Initial delay between status checks")
+	fmt.Println("    .set max-wait 2h                        # Stop polling after 2 hours (or 'off')")
+	fmt.Println("    .set max-column-width 80                # Truncate table cells wider than 80 characters (or 'off')")
+	fmt.Println("  .expanded [show|on|off|auto]              # Show table results as vertical records")
+	fmt.Println("    .expanded auto                          # Only when the table is wider than the terminal")
+	fmt.Println("  .pager [show|on|off]                      # Page results that don't fit the terminal through $PAGER")
+	fmt.Println("  .timeout [show|off|<duration>]            # Set per-query deadline")
+	fmt.Println("    .timeout 2m                             # Cancel queries running longer than 2 minutes")
+	fmt.Println("    .timeout off                            # Remove the deadline")
+	fmt.Println("  .export sqlite <file> <table> [append|replace] # Save the last result to a SQLite table")
+	fmt.Println("    .export sqlite work.db sims             # Create the table sims in work.db")
+	fmt.Println("    .export sqlite work.db sims append      # Add the rows to an existing table")
+	fmt.Println("  .keep [show|off|<dir>]                    # Keep the raw JSONL results of queries")
+	fmt.Println("    .keep ./results                         # Save results of following queries in ./results")
+	fmt.Println("    .keep off                               # Stop keeping results")
+	fmt.Println("  .profile [<name>]                         # Show or switch the profile, keeping the session settings")
+	fmt.Println("  .profiles                                 # List profiles with their coverage type and endpoint")
+	fmt.Println("  .fanout [show|off|<profile>,<profile>...] # Run queries under several profiles at once")
+	fmt.Println("    .fanout prod,staging                    # Merge results of both with a _profile column")
+	fmt.Println("    .fanout off                             # Run queries under the current profile only")
 	fmt.Println("")
 	fmt.Println("Piped input mode:")
 	fmt.Println("  echo 'select count(*) from SIM_SNAPSHOTS' | soraql")
@@ -961,9 +1066,21 @@ func showHelp() {
 	fmt.Println("")
 	fmt.Println("Profile Configuration:")
 	fmt.Println("  Profiles are stored in ~/.soracom/PROFILE.json and contain:")
-	fmt.Println("  • Authentication credentials (email/password or authKeyId/authKey)")
+	fmt.Println("  • Authentication credentials (email/password, operatorId/username/password or authKeyId/authKey)")
 	fmt.Println("  • Coverage type ('jp' for Japan, 'g' for Global)")
 	fmt.Println("  • Optional custom endpoint URL")
+	fmt.Println("  Set SORACOM_PROFILE_DIR to read profiles from another directory than ~/.soracom")
+	fmt.Println("")
+	fmt.Println("Environment variables (override the profile; flags override both):")
+	fmt.Println("  SORACOM_AUTH_KEY_ID, SORACOM_AUTH_KEY    # Auth key credentials")
+	fmt.Println("  SORACOM_EMAIL, SORACOM_PASSWORD          # Email and password credentials")
+	fmt.Println("  SORACOM_OPERATOR_ID, SORACOM_USER_NAME, SORACOM_PASSWORD  # SAM user credentials")
+	fmt.Println("  SORACOM_COVERAGE_TYPE                    # 'jp' or 'g'")
+	fmt.Println("  SORACOM_ENDPOINT                         # Custom API endpoint")
+	fmt.Println("")
+	fmt.Println("Mock server (offline development):")
+	fmt.Println("  soraql mock-server -fixtures ./fixtures     # Serve <TABLE>.jsonl files on http://127.0.0.1:8080")
+	fmt.Println("  Profiles point at it with \"endpoint\": \"http://127.0.0.1:8080\"")
 	fmt.Println("")
 	fmt.Println("Exit commands: exit, quit, \\q, .exit, .quit")
 }
@@ -1083,223 +1200,722 @@ func (c *Client) setWindow(fromStr, toStr string) error {
 	return nil
 }
 
-// parseRelativeTime parses relative time strings like "24h", "1d", "1w"
-func parseRelativeTime(relativeStr string) (time.Duration, error) {
-	if len(relativeStr) < 2 {
-		return 0, fmt.Errorf("invalid relative time format")
+// promptMFA asks for a multi-factor authentication code on the terminal,
+// which also works when queries are piped to stdin
+func promptMFA(ctx context.Context) (string, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return "", errors.New("the account requires a one-time code, but there is no terminal to enter it")
 	}
+	defer tty.Close()
 
-	unit := relativeStr[len(relativeStr)-1:]
-	valueStr := relativeStr[:len(relativeStr)-1]
-
-	value, err := strconv.Atoi(valueStr)
+	fmt.Fprint(tty, "MFA one-time code: ")
+	line, err := bufio.NewReader(tty).ReadString('\n')
 	if err != nil {
-		return 0, fmt.Errorf("invalid numeric value in relative time")
+		return "", err
 	}
-
-	switch unit {
-	case "s":
-		return time.Duration(value) * time.Second, nil
-	case "m":
-		return time.Duration(value) * time.Minute, nil
-	case "h":
-		return time.Duration(value) * time.Hour, nil
-	case "d":
-		return time.Duration(value) * 24 * time.Hour, nil
-	case "w":
-		return time.Duration(value) * 7 * 24 * time.Hour, nil
-	default:
-		return 0, fmt.Errorf("unsupported time unit '%s' (supported: s, m, h, d, w)", unit)
+	code := strings.TrimSpace(line)
+	if code == "" {
+		return "", errors.New("no code entered")
 	}
+	return code, nil
 }
 
-
-func (c *Client) authenticate(profile string) error {
-	configPath := filepath.Join(os.Getenv("HOME"), ".soracom", profile+".json")
-	
-	if c.debug {
-		fmt.Printf("Using profile: %s\n", profile)
-		fmt.Printf("Config path: %s\n", configPath)
+// setDebug switches debug output for both the shell and the API client
+func (c *Client) setDebug(debug bool) {
+	c.debug = debug
+	if c.logLevel == nil {
+		c.api.SetDebug(debug)
+		return
+	}
+	if debug {
+		c.logLevel.Set(slog.LevelDebug)
+	} else {
+		c.logLevel.Set(c.baseLogLevel)
+	}
+}
+
+// log returns the logger for diagnostics
+func (c *Client) log() *slog.Logger {
+	if c.logger == nil {
+		return slog.Default()
+	}
+	return c.logger
+}
+
+// newLogger creates a text or JSON logger writing records of at least level to w
+func newLogger(w io.Writer, format string, level slog.Leveler) (*slog.Logger, error) {
+	opts := &slog.HandlerOptions{Level: level}
+	switch format {
+	case "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("unsupported log format '%s' (supported: text, json)", format)
+	}
+}
+
+// handleDotCommand runs line if it is a dot command such as .tables or
+// .format csv, in the shell and in piped input alike. It reports whether line
+// was a dot command; anything else is left to run as SQL.
+func (c *Client) handleDotCommand(line string, openFile bool) bool {
+	parts := strings.Fields(line)
+	if len(parts) == 0 {
+		return false
+	}
+
+	switch strings.ToLower(strings.TrimRight(parts[0], ";")) {
+	case ".tables":
+		if err := c.showTables(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		}
+	case ".schema":
+		var tableName string
+		if len(parts) > 1 {
+			tableName = strings.TrimRight(parts[1], ";")
+		}
+		if err := c.showSchema(tableName); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		}
+	case ".ask":
+		c.handleAskCommand(strings.TrimSpace(line[len(parts[0]):]), openFile)
+	case ".window":
+		c.handleWindowCommand(parts)
+	case ".debug":
+		c.handleDebugCommand(parts)
+	case ".format":
+		c.handleFormatCommand(parts)
+	case ".fetch":
+		c.handleFetchCommand(parts, openFile)
+	case ".cancel":
+		c.handleCancelCommand(parts)
+	case ".set":
+		c.handleSetCommand(parts)
+	case ".expanded":
+		c.handleExpandedCommand(parts)
+	case ".pager":
+		c.handlePagerCommand(parts)
+	case ".timeout":
+		c.handleTimeoutCommand(parts)
+	case ".export":
+		c.handleExportCommand(parts)
+	case ".keep":
+		c.handleKeepCommand(parts)
+	case ".profiles":
+		c.showProfiles()
+	case ".profile":
+		c.handleProfileCommand(parts)
+	case ".fanout":
+		c.handleFanoutCommand(parts)
+	default:
+		return false
+	}
+	return true
+}
+
+// handleAskCommand implements .ask <question>: the SQL assistant suggests a
+// query, which is then run
+func (c *Client) handleAskCommand(question string, openFile bool) {
+	if question == "" {
+		fmt.Println("Usage: .ask <your question about SQL or data>")
+		return
+	}
+
+	// Use existing query from history if available
+	existingQuery := ""
+	if len(c.history) > 0 {
+		// Look for the last SQL query (not a command)
+		for i := len(c.history) - 1; i >= 0; i-- {
+			h := strings.TrimSpace(c.history[i])
+			if !strings.HasPrefix(h, ".") && !isExitCommand(h) {
+				existingQuery = h
+				break
+			}
+		}
+	}
+
+	// Show animation while waiting for SQL assistant (unless in silent mode)
+	var stopAnimation chan bool
+	if !c.silent {
+		stopAnimation = make(chan bool)
+		go func() {
+			c.showSQLAssistantAnimation(stopAnimation)
+		}()
+	}
+
+	ctx, cancel := c.commandContext()
+	response, err := c.api.AskSQLAssistant(ctx, question, existingQuery)
+	err = contextCause(ctx, err)
+	cancel(nil)
+
+	// Stop animation
+	if !c.silent {
+		stopAnimation <- true
 	}
 
-	configFile, err := os.Open(configPath)
 	if err != nil {
-		return fmt.Errorf("config file '%s' not found: %v", configPath, err)
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return
+	}
+
+	// Display response without header
+	if response.Context != "" {
+		fmt.Printf("\n%s\n", response.Context)
 	}
-	defer configFile.Close()
+	if response.SQLQuery != "" {
+		fmt.Printf("\nSuggested SQL:\n%s\n", response.SQLQuery)
+		fmt.Printf("\n🚀 Executing query automatically...\n")
 
-	var config Config
-	if err := json.NewDecoder(configFile).Decode(&config); err != nil {
-		return fmt.Errorf("failed to parse config file: %v", err)
+		// Auto-execute the suggested query (do not save to history since it's not user-typed)
+		if err := c.runQuery(response.SQLQuery, openFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Error executing query: %v\n", err)
+		}
 	}
+	fmt.Println()
+}
 
-	// Set base URLs based on profile configuration
-	if config.Endpoint != "" {
-		// Use endpoint from profile if specified (remove https:// prefix if present)
-		endpoint := strings.TrimPrefix(config.Endpoint, "https://")
-		endpoint = strings.TrimPrefix(endpoint, "http://")
-		c.baseURL = endpoint
-		c.authBaseURL = endpoint
+// handleWindowCommand implements .window [show|clear|<from> <to>]
+func (c *Client) handleWindowCommand(parts []string) {
+	if len(parts) == 1 || (len(parts) == 2 && strings.ToLower(parts[1]) == "show") {
+		// Show current window settings
+		c.showCurrentWindow()
+	} else if len(parts) == 2 && strings.ToLower(parts[1]) == "clear" {
+		// Clear window settings
+		c.clearWindow()
+		fmt.Println("Time window cleared.")
+	} else if len(parts) == 3 {
+		// Set window with from and to parameters
+		fromStr := parts[1]
+		toStr := parts[2]
+		if err := c.setWindow(fromStr, toStr); err != nil {
+			fmt.Fprintf(os.Stderr, "Error setting window: %v\n", err)
+		} else {
+			fmt.Printf("Time window set: from %s to %s\n", fromStr, toStr)
+			c.showCurrentWindow()
+		}
 	} else {
-		// Default to production endpoints based on coverage type
-		if config.CoverageType == "g" {
-			c.baseURL = "g.api.soracom.io"
-			c.authBaseURL = "g.api.soracom.io"
+		fmt.Println("Usage: .window [show|clear|<from> <to>]")
+		fmt.Println("Examples:")
+		fmt.Println("  .window show          # Show current time window")
+		fmt.Println("  .window clear         # Clear time window")
+		fmt.Println("  .window -24h now      # Set window from 24 hours ago to now")
+		fmt.Println("  .window 1640995200 1641081600  # Set specific timestamps")
+	}
+}
+
+// handleDebugCommand implements .debug [on|off|show]
+func (c *Client) handleDebugCommand(parts []string) {
+	if len(parts) == 1 {
+		// Toggle debug mode
+		c.setDebug(!c.debug)
+		if c.debug {
+			fmt.Println("Debug mode enabled.")
 		} else {
-			// Default to JP coverage
-			c.baseURL = "jp.api.soracom.io"
-			c.authBaseURL = "jp.api.soracom.io"
+			fmt.Println("Debug mode disabled.")
+		}
+		return
+	}
+
+	if len(parts) == 2 {
+		switch strings.ToLower(parts[1]) {
+		case "on", "true", "1":
+			c.setDebug(true)
+			fmt.Println("Debug mode enabled.")
+			return
+		case "off", "false", "0":
+			c.setDebug(false)
+			fmt.Println("Debug mode disabled.")
+			return
+		case "show", "status":
+			if c.debug {
+				fmt.Println("Debug mode is currently enabled.")
+			} else {
+				fmt.Println("Debug mode is currently disabled.")
+			}
+			return
+		}
+	}
+
+	fmt.Println("Usage: .debug [on|off|show]")
+	fmt.Println("Examples:")
+	fmt.Println("  .debug        # Toggle debug mode")
+	fmt.Println("  .debug on     # Enable debug mode")
+	fmt.Println("  .debug off    # Disable debug mode")
+	fmt.Println("  .debug show   # Show current debug status")
+}
+
+// handleFormatCommand implements .format [table|vertical|csv|json|jsonl|parquet|sqlite|show]
+func (c *Client) handleFormatCommand(parts []string) {
+	if len(parts) == 1 {
+		// Show current format
+		fmt.Printf("Current output format: %s\n", c.format)
+		return
+	}
+
+	if len(parts) == 2 {
+		switch newFormat := strings.ToLower(parts[1]); newFormat {
+		case "table", "vertical", "csv", "json", "jsonl":
+			c.format = newFormat
+			fmt.Printf("Output format set to: %s\n", newFormat)
+			return
+		case "parquet", "sqlite":
+			c.setFileFormat(newFormat)
+			return
+		case "show", "status":
+			fmt.Printf("Current output format: %s\n", c.format)
+			return
 		}
 	}
 
-	// Store custom headers from profile
-	c.customHeaders = config.Headers
-	if c.customHeaders == nil {
-		c.customHeaders = make(map[string]string)
+	fmt.Println("Usage: .format [table|vertical|csv|json|jsonl|parquet|sqlite|show]")
+	fmt.Println("Examples:")
+	fmt.Println("  .format           # Show current format")
+	fmt.Println("  .format table     # Set format to table")
+	fmt.Println("  .format vertical  # Show each row as a block of column | value lines")
+	fmt.Println("  .format csv       # Set format to CSV")
+	fmt.Println("  .format json      # Set format to JSON")
+	fmt.Println("  .format jsonl     # Set format to JSON Lines")
+	fmt.Println("  .format parquet   # Write results to the -o file as Parquet")
+	fmt.Println("  .format sqlite    # Write results to a table of the -o database")
+	fmt.Println("  .format show      # Show current format")
+}
+
+// handleFetchCommand implements .fetch <queryId>
+func (c *Client) handleFetchCommand(parts []string, openFile bool) {
+	if len(parts) != 2 {
+		fmt.Println("Usage: .fetch <queryId>")
+		fmt.Println("Examples:")
+		fmt.Println("  .fetch 0123456789abcdef    # Wait for a submitted query and display its results")
+		return
+	}
+
+	if err := c.fetchQuery(strings.TrimRight(parts[1], ";"), openFile); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	}
+}
+
+// handleCancelCommand implements .cancel <queryId>
+func (c *Client) handleCancelCommand(parts []string) {
+	if len(parts) != 2 {
+		fmt.Println("Usage: .cancel <queryId>")
+		fmt.Println("Examples:")
+		fmt.Println("  .cancel 0123456789abcdef   # Stop a query that is still running on the server")
+		return
+	}
+
+	ctx, cancel := c.commandContext()
+	defer cancel(nil)
+
+	queryID := strings.TrimRight(parts[1], ";")
+	if err := c.api.Cancel(ctx, queryID); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: failed to cancel query %s: %v\n", queryID, contextCause(ctx, err))
+		return
 	}
+	fmt.Printf("Query %s cancelled.\n", queryID)
+}
 
-	if c.debug {
-		fmt.Printf("Coverage Type: %s\n", config.CoverageType)
-		fmt.Printf("Endpoint: %s\n", config.Endpoint)
-		fmt.Printf("Base URL: %s\n", c.baseURL)
-		fmt.Printf("Auth Base URL: %s\n", c.authBaseURL)
-		if len(c.customHeaders) > 0 {
-			fmt.Printf("Custom Headers: %v\n", c.customHeaders)
+// handleTimeoutCommand implements .timeout [show|off|<duration>]
+func (c *Client) handleTimeoutCommand(parts []string) {
+	if len(parts) == 1 || (len(parts) == 2 && strings.ToLower(parts[1]) == "show") {
+		if c.timeout > 0 {
+			fmt.Printf("Query timeout: %s\n", c.timeout)
+		} else {
+			fmt.Println("No query timeout set.")
 		}
-		fmt.Printf("Email: %s\n", config.Email)
-		fmt.Printf("Password: %s\n", config.Password)
-		fmt.Printf("API Key ID: %s\n", config.AuthKeyId)
-		fmt.Printf("Auth Key: %s\n", config.AuthKey)
+		return
 	}
 
-	var authPayload interface{}
-	if config.Email != "" && config.Password != "" {
-		authPayload = map[string]string{
-			"email":    config.Email,
-			"password": config.Password,
+	if len(parts) == 2 {
+		if timeout, err := parseLimit(parts[1]); err == nil {
+			c.timeout = timeout
+			if timeout == 0 {
+				fmt.Println("Query timeout disabled.")
+			} else {
+				fmt.Printf("Query timeout set to: %s\n", timeout)
+			}
+			return
 		}
-	} else {
-		authPayload = map[string]string{
-			"authKeyId": config.AuthKeyId,
-			"authKey":   config.AuthKey,
+	}
+
+	fmt.Println("Usage: .timeout [show|off|<duration>]")
+	fmt.Println("Examples:")
+	fmt.Println("  .timeout          # Show current query timeout")
+	fmt.Println("  .timeout 2m       # Cancel queries that run longer than 2 minutes")
+	fmt.Println("  .timeout off      # Let queries run without a deadline")
+}
+
+// handleExpandedCommand implements .expanded [show|on|off|auto]
+func (c *Client) handleExpandedCommand(parts []string) {
+	if len(parts) == 1 || (len(parts) == 2 && strings.ToLower(parts[1]) == "show") {
+		fmt.Printf("Expanded display: %s\n", c.expandedMode())
+		return
+	}
+
+	if len(parts) == 2 {
+		switch mode := strings.ToLower(parts[1]); mode {
+		case "on", "off", "auto":
+			c.expanded = mode
+			fmt.Printf("Expanded display set to: %s\n", mode)
+			return
 		}
 	}
 
-	payloadBytes, err := json.Marshal(authPayload)
-	if err != nil {
-		return fmt.Errorf("failed to marshal auth payload: %v", err)
+	fmt.Println("Usage: .expanded [show|on|off|auto]")
+	fmt.Println("Examples:")
+	fmt.Println("  .expanded         # Show the current setting")
+	fmt.Println("  .expanded on      # Show table results as one column | value block per row")
+	fmt.Println("  .expanded auto    # Only when the table would be wider than the terminal")
+	fmt.Println("  .expanded off     # Always draw tables")
+}
+
+// expandedMode returns the .expanded setting, which is off until changed
+func (c *Client) expandedMode() string {
+	if c.expanded == "" {
+		return "off"
 	}
+	return c.expanded
+}
 
-	req, err := http.NewRequest("POST", fmt.Sprintf("https://%s/v1/auth", c.authBaseURL), bytes.NewBuffer(payloadBytes))
-	if err != nil {
-		return fmt.Errorf("failed to create auth request: %v", err)
+// handlePagerCommand implements .pager [show|on|off]
+func (c *Client) handlePagerCommand(parts []string) {
+	if len(parts) == 1 || (len(parts) == 2 && strings.ToLower(parts[1]) == "show") {
+		if c.pager {
+			fmt.Printf("Pager: on (%s)\n", strings.Join(pagerCommand().Args, " "))
+		} else {
+			fmt.Println("Pager: off")
+		}
+		return
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	
-	// Add custom headers from profile
-	for key, value := range c.customHeaders {
-		req.Header.Set(key, value)
+	if len(parts) == 2 {
+		switch strings.ToLower(parts[1]) {
+		case "on":
+			c.pager = true
+			fmt.Println("Pager enabled: results that don't fit the terminal are shown through $PAGER")
+			return
+		case "off":
+			c.pager = false
+			fmt.Println("Pager disabled")
+			return
+		}
+	}
+
+	fmt.Println("Usage: .pager [show|on|off]")
+	fmt.Println("Examples:")
+	fmt.Println("  .pager            # Show the current setting")
+	fmt.Println("  .pager on         # Page results taller or wider than the terminal")
+	fmt.Println("  .pager off        # Always print results directly")
+}
+
+// setFileFormat switches to parquet or sqlite, which write the -o file that
+// must have been given at startup
+func (c *Client) setFileFormat(format string) {
+	if c.output == "" {
+		fmt.Printf("The %s format writes a file: start soraql with -o FILE to use it\n", format)
+		return
+	}
+	c.format = format
+	fmt.Printf("Output format set to: %s (writing to %s)\n", format, c.output)
+}
+
+// handleExportCommand implements .export sqlite <file> <table> [mode]. It
+// downloads the result of the last query again and writes it to a table of
+// a SQLite database.
+func (c *Client) handleExportCommand(parts []string) {
+	if len(parts) > 0 {
+		parts[len(parts)-1] = strings.TrimRight(parts[len(parts)-1], ";")
+	}
+	mode := c.ifExists
+	if len(parts) == 5 {
+		mode = strings.ToLower(parts[4])
+	}
+	if len(parts) < 4 || len(parts) > 5 || strings.ToLower(parts[1]) != "sqlite" || !validIfExists(mode) {
+		fmt.Println("Usage: .export sqlite <file> <table> [fail|append|replace]")
+		fmt.Println("Examples:")
+		fmt.Println("  .export sqlite work.db sims           # Save the last result as the new table sims")
+		fmt.Println("  .export sqlite work.db sims append    # Add the rows to sims, creating it if needed")
+		fmt.Println("  .export sqlite work.db sims replace   # Drop sims and save the last result in its place")
+		return
 	}
 
-	if c.debug {
-		fmt.Printf("Auth URL: %s\n", req.URL.String())
-		fmt.Printf("Auth payload: %s\n", string(payloadBytes))
+	if c.lastResult == nil {
+		fmt.Println("No result to export: run a query first. Results of several profiles (.fanout) cannot be exported.")
+		return
 	}
+	ctx, cancel := c.commandContext()
+	defer cancel(nil)
 
-	resp, err := c.httpClient.Do(req)
+	body, err := c.api.OpenResults(ctx, c.lastResult, &analysis.DownloadOptions{MaxSize: c.maxDownloadSize, Retries: c.apiOptions.Retries})
 	if err != nil {
-		return fmt.Errorf("auth request failed: %v", err)
+		fmt.Fprintf(os.Stderr, "Error: %v\n", contextCause(ctx, err))
+		return
+	}
+	defer body.Close()
+
+	if err := writeSQLite(analysis.NewRows(body, c.lastResult.ColumnInfo), parts[2], parts[3], mode); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	}
+}
+
+// handleKeepCommand implements .keep [show|off|<dir>]
+func (c *Client) handleKeepCommand(parts []string) {
+	if len(parts) == 1 || (len(parts) == 2 && strings.ToLower(parts[1]) == "show") {
+		if c.keepDir != "" {
+			fmt.Printf("Keeping raw results in: %s\n", c.keepDir)
+		} else {
+			fmt.Println("Raw results are not kept.")
+		}
+		return
+	}
+
+	if len(parts) == 2 {
+		switch dir := strings.TrimRight(parts[1], ";"); strings.ToLower(dir) {
+		case "off", "none":
+			c.keepDir = ""
+			fmt.Println("Raw results will no longer be kept.")
+		default:
+			c.keepDir = dir
+			fmt.Printf("Keeping raw results in: %s\n", dir)
+		}
+		return
+	}
+
+	fmt.Println("Usage: .keep [show|off|<dir>]")
+	fmt.Println("Examples:")
+	fmt.Println("  .keep             # Show where raw results are kept")
+	fmt.Println("  .keep ./results   # Save the raw JSONL of each following query in ./results")
+	fmt.Println("  .keep off         # Stop keeping raw results")
+}
+
+// handleProfileCommand implements .profile [<name>]. It logs in with the
+// new profile before switching, so a failed login keeps the current one.
+func (c *Client) handleProfileCommand(parts []string) {
+	if len(parts) == 1 {
+		fmt.Printf("Current profile: %s\n", c.profileName)
+		return
+	}
+	if len(parts) != 2 {
+		fmt.Println("Usage: .profile [<name>]")
+		fmt.Println("Examples:")
+		fmt.Println("  .profile           # Show the current profile")
+		fmt.Println("  .profile staging   # Log in with the staging profile")
+		return
+	}
+	if c.apiOptions.Config != nil {
+		fmt.Println("Profiles cannot be switched while replaying a recording.")
+		return
 	}
-	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
+	name := strings.TrimRight(parts[1], ";")
+	opts := c.apiOptions
+	opts.Profile = name
+	// Credentials given on the command line belong to the first profile
+	opts.APIKey, opts.APIToken, opts.CredentialProcess = "", "", ""
+
+	ctx, cancel := c.commandContext()
+	defer cancel(nil)
+	api, err := analysis.New(ctx, opts)
 	if err != nil {
-		return fmt.Errorf("failed to read auth response: %v", err)
+		fmt.Fprintf(os.Stderr, "Failed to switch to profile '%s': %v\n", name, contextCause(ctx, err))
+		return
+	}
+
+	c.api = api
+	c.apiOptions = opts
+	c.profileName = name
+	fmt.Printf("Switched to profile: %s\n", name)
+	if len(c.fanout) > 0 {
+		c.fanout = nil
+		fmt.Println("Fan-out turned off.")
 	}
+}
 
-	if c.debug {
-		fmt.Printf("Auth response: %s\n", string(body))
+// showProfiles implements .profiles, listing the profiles with their
+// coverage type and endpoint
+func (c *Client) showProfiles() {
+	profiles, err := analysis.ListProfiles()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: failed to list profiles: %v\n", err)
+		return
+	}
+	if len(profiles) == 0 {
+		fmt.Printf("No profiles found in %s\n", analysis.ProfileDir())
+		return
 	}
 
-	var authResp AuthResponse
-	if err := json.Unmarshal(body, &authResp); err != nil {
-		return fmt.Errorf("failed to parse auth response: %v", err)
+	nameWidth := cellWidth("PROFILE")
+	for _, name := range profiles {
+		nameWidth = max(nameWidth, cellWidth(name))
 	}
 
-	c.apiKey = authResp.ApiKey
-	c.token = authResp.Token
+	fmt.Printf("  %s  %-8s  %s\n", padCell("PROFILE", nameWidth, false), "COVERAGE", "ENDPOINT")
+	for _, name := range profiles {
+		marker := " "
+		if name == c.profileName {
+			marker = "*"
+		}
 
-	return nil
+		config, err := analysis.LoadProfile(name)
+		if err != nil {
+			fmt.Printf("%s %s  (invalid: %v)\n", marker, padCell(name, nameWidth, false), err)
+			continue
+		}
+		coverage := config.CoverageType
+		if coverage == "" {
+			coverage = "jp"
+		}
+		endpoint := config.Endpoint
+		if endpoint == "" {
+			endpoint = "(default)"
+		}
+		fmt.Printf("%s %s  %-8s  %s\n", marker, padCell(name, nameWidth, false), coverage, endpoint)
+	}
 }
 
-func (c *Client) makeRequest(method, url string, payload interface{}) ([]byte, error) {
-	var body io.Reader
-	if payload != nil {
-		payloadBytes, err := json.Marshal(payload)
+// handleSetCommand implements .set [<option> <value>] for the query settings
+func (c *Client) handleSetCommand(parts []string) {
+	if len(parts) == 1 {
+		fmt.Println("Current settings:")
+		fmt.Printf("  poll-interval     %s\n", c.pollInterval)
+		fmt.Printf("  max-wait          %s\n", formatLimit(c.maxWait))
+		fmt.Printf("  timeout           %s\n", formatLimit(c.timeout))
+		fmt.Printf("  max-column-width  %s\n", formatWidth(c.maxColumnWidth))
+		return
+	}
+
+	if len(parts) != 3 {
+		fmt.Println("Usage: .set [<option> <value>]")
+		fmt.Println("Options:")
+		fmt.Println("  poll-interval <duration>     # Initial delay between status checks (backs off exponentially)")
+		fmt.Println("  max-wait <duration>|off      # Stop polling a query after this long")
+		fmt.Println("  timeout <duration>|off       # Cancel each query after this long")
+		fmt.Println("  max-column-width <n>|off     # Truncate table cells wider than this")
+		fmt.Println("Examples:")
+		fmt.Println("  .set                         # Show current settings")
+		fmt.Println("  .set poll-interval 500ms")
+		fmt.Println("  .set max-wait 2h")
+		return
+	}
+
+	option := strings.ToLower(parts[1])
+	value := strings.TrimRight(parts[2], ";")
+	switch option {
+	case "poll-interval":
+		interval, err := time.ParseDuration(value)
+		if err != nil || interval <= 0 {
+			fmt.Fprintf(os.Stderr, "Invalid poll interval '%s': must be a positive duration like '500ms'\n", value)
+			return
+		}
+		c.pollInterval = interval
+		fmt.Printf("poll-interval set to: %s\n", interval)
+	case "max-wait", "timeout":
+		limit, err := parseLimit(value)
 		if err != nil {
-			return nil, fmt.Errorf("failed to marshal payload: %v", err)
+			fmt.Fprintf(os.Stderr, "Invalid %s '%s': %v\n", option, value, err)
+			return
 		}
-		body = bytes.NewBuffer(payloadBytes)
-		if c.debug {
-			fmt.Printf("Request payload: %s\n", string(payloadBytes))
+		if option == "max-wait" {
+			c.maxWait = limit
+		} else {
+			c.timeout = limit
 		}
+		fmt.Printf("%s set to: %s\n", option, formatLimit(limit))
+	case "max-column-width":
+		width, err := parseWidth(value)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid max-column-width '%s': %v\n", value, err)
+			return
+		}
+		c.maxColumnWidth = width
+		fmt.Printf("max-column-width set to: %s\n", formatWidth(width))
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown setting '%s'. Available settings: poll-interval, max-wait, timeout, max-column-width\n", parts[1])
 	}
+}
 
-	req, err := http.NewRequest(method, url, body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %v", err)
+// parseLimit parses a duration limit where "off", "none" or "0" mean no limit
+func parseLimit(value string) (time.Duration, error) {
+	switch strings.ToLower(value) {
+	case "off", "none", "0":
+		return 0, nil
 	}
 
-	req.Header.Set("x-soracom-api-key", c.apiKey)
-	req.Header.Set("x-soracom-token", c.token)
-	
-	// Add custom headers from profile
-	for key, value := range c.customHeaders {
-		req.Header.Set(key, value)
+	limit, err := time.ParseDuration(value)
+	if err != nil || limit <= 0 {
+		return 0, fmt.Errorf("must be a positive duration like '10m' or 'off'")
 	}
-	
-	if payload != nil {
-		req.Header.Set("Content-Type", "application/json")
+	return limit, nil
+}
+
+// formatLimit renders a duration limit, showing 0 as "off"
+func formatLimit(limit time.Duration) string {
+	if limit == 0 {
+		return "off"
 	}
+	return limit.String()
+}
 
-	if c.debug {
-		fmt.Printf("Request URL: %s\n", req.URL.String())
-		fmt.Printf("Request method: %s\n", method)
+// parseWidth parses a column width where "off", "none" or "0" mean no limit
+func parseWidth(value string) (int, error) {
+	switch strings.ToLower(value) {
+	case "off", "none":
+		return 0, nil
 	}
 
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("request failed: %v", err)
+	width, err := strconv.Atoi(value)
+	if err != nil || width < 0 {
+		return 0, fmt.Errorf("must be a number of characters like '80' or 'off'")
 	}
-	defer resp.Body.Close()
+	return width, nil
+}
 
-	responseBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %v", err)
+// formatWidth renders a column width, showing 0 as "off"
+func formatWidth(width int) string {
+	if width == 0 {
+		return "off"
 	}
+	return strconv.Itoa(width)
+}
 
-	if c.debug {
-		fmt.Printf("Response status: %d\n", resp.StatusCode)
-		fmt.Printf("Response body: %s\n", string(responseBody))
+// parseRelativeTime parses relative time strings like "24h", "1d", "1w"
+func parseRelativeTime(relativeStr string) (time.Duration, error) {
+	if len(relativeStr) < 2 {
+		return 0, fmt.Errorf("invalid relative time format")
 	}
 
-	// Check for HTTP error status codes
-	if resp.StatusCode >= 400 {
-		var errorResp ErrorResponse
-		if err := json.Unmarshal(responseBody, &errorResp); err == nil && errorResp.Code != "" {
-			return nil, fmt.Errorf("API error [%s]: %s", errorResp.Code, errorResp.Message)
-		}
-		return nil, fmt.Errorf("HTTP %d error: %s", resp.StatusCode, string(responseBody))
+	unit := relativeStr[len(relativeStr)-1:]
+	valueStr := relativeStr[:len(relativeStr)-1]
+
+	value, err := strconv.Atoi(valueStr)
+	if err != nil {
+		return 0, fmt.Errorf("invalid numeric value in relative time")
 	}
 
-	return responseBody, nil
+	switch unit {
+	case "s":
+		return time.Duration(value) * time.Second, nil
+	case "m":
+		return time.Duration(value) * time.Minute, nil
+	case "h":
+		return time.Duration(value) * time.Hour, nil
+	case "d":
+		return time.Duration(value) * 24 * time.Hour, nil
+	case "w":
+		return time.Duration(value) * 7 * 24 * time.Hour, nil
+	default:
+		return 0, fmt.Errorf("unsupported time unit '%s' (supported: s, m, h, d, w)", unit)
+	}
 }
 
+
 func (c *Client) getSchemas() error {
-	body, err := c.makeRequest("GET", fmt.Sprintf("https://%s/v1/analysis/schemas", c.baseURL), nil)
+	ctx, cancel := c.commandContext()
+	defer cancel(nil)
+
+	body, err := c.api.Schemas(ctx)
 	if err != nil {
-		return err
+		return contextCause(ctx, err)
 	}
 
 	var prettyJSON bytes.Buffer
@@ -1313,19 +1929,13 @@ func (c *Client) getSchemas() error {
 }
 
 func (c *Client) showTables() error {
-	body, err := c.makeRequest("GET", fmt.Sprintf("https://%s/v1/analysis/schemas", c.baseURL), nil)
-	if err != nil {
-		return err
-	}
+	ctx, cancel := c.commandContext()
+	defer cancel(nil)
 
-	// Parse the schema response to extract tables
-	var schema map[string]interface{}
-	if err := json.Unmarshal(body, &schema); err != nil {
-		return fmt.Errorf("failed to parse schema response: %v", err)
+	tableNames, err := c.api.Tables(ctx)
+	if err != nil {
+		return contextCause(ctx, err)
 	}
-
-	// Extract table names from schema
-	tableNames := c.extractTableNames(schema)
 	
 	if len(tableNames) == 0 {
 		fmt.Println("No tables found.")
@@ -1333,76 +1943,28 @@ func (c *Client) showTables() error {
 	}
 
 	// Display tables in a simple list format
+	width := 42
+	for _, tableName := range tableNames {
+		width = max(width, cellWidth(tableName))
+	}
 	fmt.Println("Tables:")
-	fmt.Println("┌────────────────────────────────────────────┐")
+	fmt.Println("┌" + strings.Repeat("─", width+2) + "┐")
 	for _, tableName := range tableNames {
-		fmt.Printf("│ %-42s │\n", tableName)
+		fmt.Printf("│ %s │\n", padCell(tableName, width, false))
 	}
-	fmt.Println("└────────────────────────────────────────────┘")
+	fmt.Println("└" + strings.Repeat("─", width+2) + "┘")
 	fmt.Printf("\n(%d tables)\n", len(tableNames))
 
 	return nil
 }
 
-func (c *Client) extractTableNames(schema map[string]interface{}) []string {
-	var tableNames []string
-	
-	// Try to extract tables from different possible schema structures
-	// This is a generic approach that handles various schema formats
-	if tables, exists := schema["tables"]; exists {
-		if tableList, ok := tables.([]interface{}); ok {
-			for _, table := range tableList {
-				if tableMap, ok := table.(map[string]interface{}); ok {
-					if name, exists := tableMap["name"]; exists {
-						if nameStr, ok := name.(string); ok {
-							tableNames = append(tableNames, nameStr)
-						}
-					}
-				}
-			}
-		}
-	}
-	
-	// If no tables found in expected structure, try alternative approaches
-	if len(tableNames) == 0 {
-		// Look for any keys that might represent table names
-		for key, value := range schema {
-			if key == "schemas" || key == "databases" {
-				if schemaMap, ok := value.(map[string]interface{}); ok {
-					for _, schemaValue := range schemaMap {
-						if schemaData, ok := schemaValue.(map[string]interface{}); ok {
-							if tables, exists := schemaData["tables"]; exists {
-								if tableMap, ok := tables.(map[string]interface{}); ok {
-									for tableName := range tableMap {
-										tableNames = append(tableNames, tableName)
-									}
-								}
-							}
-						}
-					}
-				}
-			}
-		}
-	}
-	
-	// Sort table names for consistent display
-	if len(tableNames) > 1 {
-		for i := 0; i < len(tableNames)-1; i++ {
-			for j := i + 1; j < len(tableNames); j++ {
-				if tableNames[i] > tableNames[j] {
-					tableNames[i], tableNames[j] = tableNames[j], tableNames[i]
-				}
-			}
-		}
-	}
-	
-	return tableNames
-}
-
 func (c *Client) showSchema(tableName string) error {
-	body, err := c.makeRequest("GET", fmt.Sprintf("https://%s/v1/analysis/schemas", c.baseURL), nil)
+	ctx, cancel := c.commandContext()
+	defer cancel(nil)
+
+	body, err := c.api.Schemas(ctx)
 	if err != nil {
-		return err
+		return contextCause(ctx, err)
 	}
 
 	// Parse the schema response
@@ -1411,10 +1973,7 @@ func (c *Client) showSchema(tableName string) error {
 		return fmt.Errorf("failed to parse schema response: %v", err)
 	}
 
-	// Debug: show raw schema if no table schemas found
-	if c.debug {
-		fmt.Printf("Raw schema response: %s\n", string(body))
-	}
+	c.log().Debug("Schema response", "body", string(body))
 
 	if tableName == "" {
 		// Show all schemas
@@ -1426,7 +1985,7 @@ func (c *Client) showSchema(tableName string) error {
 }
 
 func (c *Client) displayAllSchemas(schema map[string]interface{}) error {
-	tableSchemas := c.extractAllTableSchemas(schema)
+	tableSchemas := analysis.ExtractTableSchemas(schema)
 	
 	if len(tableSchemas) == 0 {
 		fmt.Println("No table schemas found in expected format.")
@@ -1466,12 +2025,8 @@ func (c *Client) displayAllSchemas(schema map[string]interface{}) error {
 		maxTypeWidth := len("Type")
 		
 		for _, col := range columns {
-			if len(col.Name) > maxColNameWidth {
-				maxColNameWidth = len(col.Name)
-			}
-			if len(col.Type) > maxTypeWidth {
-				maxTypeWidth = len(col.Type)
-			}
+			maxColNameWidth = max(maxColNameWidth, cellWidth(col.Name))
+			maxTypeWidth = max(maxTypeWidth, cellWidth(col.Type))
 		}
 		
 		if hasDescriptions {
@@ -1488,7 +2043,7 @@ func (c *Client) displayAllSchemas(schema map[string]interface{}) error {
 			fmt.Println("┐")
 			
 			// Print header
-			fmt.Printf("│ %-*s │ %-*s │ %-*s │\n", maxColNameWidth, "Column", maxTypeWidth, "Type", descWidth, "Description")
+			fmt.Printf("│ %s │ %s │ %s │\n", padCell("Column", maxColNameWidth, false), padCell("Type", maxTypeWidth, false), padCell("Description", descWidth, false))
 			
 			// Print separator
 			fmt.Print("├")
@@ -1506,10 +2061,8 @@ func (c *Client) displayAllSchemas(schema map[string]interface{}) error {
 					desc = "-"
 				}
 				// Truncate long descriptions to fit
-				if len(desc) > descWidth {
-					desc = desc[:descWidth-3] + "..."
-				}
-				fmt.Printf("│ %-*s │ %-*s │ %-*s │\n", maxColNameWidth, col.Name, maxTypeWidth, col.Type, descWidth, desc)
+				desc = displayWidth.Truncate(desc, descWidth, "...")
+				fmt.Printf("│ %s │ %s │ %s │\n", padCell(col.Name, maxColNameWidth, false), padCell(col.Type, maxTypeWidth, false), padCell(desc, descWidth, false))
 			}
 			
 			// Print bottom border
@@ -1530,7 +2083,7 @@ func (c *Client) displayAllSchemas(schema map[string]interface{}) error {
 			fmt.Println("┐")
 			
 			// Print header
-			fmt.Printf("│ %-*s │ %-*s │\n", maxColNameWidth, "Column", maxTypeWidth, "Type")
+			fmt.Printf("│ %s │ %s │\n", padCell("Column", maxColNameWidth, false), padCell("Type", maxTypeWidth, false))
 			
 			// Print separator
 			fmt.Print("├")
@@ -1541,7 +2094,7 @@ func (c *Client) displayAllSchemas(schema map[string]interface{}) error {
 			
 			// Print data rows
 			for _, col := range columns {
-				fmt.Printf("│ %-*s │ %-*s │\n", maxColNameWidth, col.Name, maxTypeWidth, col.Type)
+				fmt.Printf("│ %s │ %s │\n", padCell(col.Name, maxColNameWidth, false), padCell(col.Type, maxTypeWidth, false))
 			}
 			
 			// Print bottom border
@@ -1559,7 +2112,7 @@ func (c *Client) displayAllSchemas(schema map[string]interface{}) error {
 }
 
 func (c *Client) displayTableSchema(schema map[string]interface{}, tableName string) error {
-	tableSchemas := c.extractAllTableSchemas(schema)
+	tableSchemas := analysis.ExtractTableSchemas(schema)
 	
 	columns, exists := tableSchemas[tableName]
 	if !exists {
@@ -1594,12 +2147,8 @@ func (c *Client) displayTableSchema(schema map[string]interface{}, tableName str
 	maxTypeWidth := len("Type")
 	
 	for _, col := range columns {
-		if len(col.Name) > maxColNameWidth {
-			maxColNameWidth = len(col.Name)
-		}
-		if len(col.Type) > maxTypeWidth {
-			maxTypeWidth = len(col.Type)
-		}
+		maxColNameWidth = max(maxColNameWidth, cellWidth(col.Name))
+		maxTypeWidth = max(maxTypeWidth, cellWidth(col.Type))
 	}
 	
 	if hasDescriptions {
@@ -1616,7 +2165,7 @@ func (c *Client) displayTableSchema(schema map[string]interface{}, tableName str
 		fmt.Println("┐")
 		
 		// Print header
-		fmt.Printf("│ %-*s │ %-*s │ %-*s │\n", maxColNameWidth, "Column", maxTypeWidth, "Type", descWidth, "Description")
+		fmt.Printf("│ %s │ %s │ %s │\n", padCell("Column", maxColNameWidth, false), padCell("Type", maxTypeWidth, false), padCell("Description", descWidth, false))
 		
 		// Print separator
 		fmt.Print("├")
@@ -1634,10 +2183,8 @@ func (c *Client) displayTableSchema(schema map[string]interface{}, tableName str
 				desc = "-"
 			}
 			// Truncate long descriptions to fit
-			if len(desc) > descWidth {
-				desc = desc[:descWidth-3] + "..."
-			}
-			fmt.Printf("│ %-*s │ %-*s │ %-*s │\n", maxColNameWidth, col.Name, maxTypeWidth, col.Type, descWidth, desc)
+			desc = displayWidth.Truncate(desc, descWidth, "...")
+			fmt.Printf("│ %s │ %s │ %s │\n", padCell(col.Name, maxColNameWidth, false), padCell(col.Type, maxTypeWidth, false), padCell(desc, descWidth, false))
 		}
 		
 		// Print bottom border
@@ -1658,7 +2205,7 @@ func (c *Client) displayTableSchema(schema map[string]interface{}, tableName str
 		fmt.Println("┐")
 		
 		// Print header
-		fmt.Printf("│ %-*s │ %-*s │\n", maxColNameWidth, "Column", maxTypeWidth, "Type")
+		fmt.Printf("│ %s │ %s │\n", padCell("Column", maxColNameWidth, false), padCell("Type", maxTypeWidth, false))
 		
 		// Print separator
 		fmt.Print("├")
@@ -1669,7 +2216,7 @@ func (c *Client) displayTableSchema(schema map[string]interface{}, tableName str
 		
 		// Print data rows
 		for _, col := range columns {
-			fmt.Printf("│ %-*s │ %-*s │\n", maxColNameWidth, col.Name, maxTypeWidth, col.Type)
+			fmt.Printf("│ %s │ %s │\n", padCell(col.Name, maxColNameWidth, false), padCell(col.Type, maxTypeWidth, false))
 		}
 		
 		// Print bottom border
@@ -1685,555 +2232,877 @@ func (c *Client) displayTableSchema(schema map[string]interface{}, tableName str
 	return nil
 }
 
-func (c *Client) findRawTableData(schema map[string]interface{}, tableName string) map[string]interface{} {
-	// Try to find the raw table data for debugging
-	if tables, exists := schema["tables"]; exists {
-		if tableList, ok := tables.([]interface{}); ok {
-			for _, table := range tableList {
-				if tableMap, ok := table.(map[string]interface{}); ok {
-					if name, exists := tableMap["name"]; exists {
-						if nameStr, ok := name.(string); ok && strings.EqualFold(nameStr, tableName) {
-							return tableMap
-						}
-					}
-				}
-			}
-		} else if tableMap, ok := tables.(map[string]interface{}); ok {
-			for name, tableData := range tableMap {
-				if strings.EqualFold(name, tableName) {
-					if tableInfo, ok := tableData.(map[string]interface{}); ok {
-						return tableInfo
-					}
-				}
-			}
-		}
-	}
+func (c *Client) showPlans() error {
+	fmt.Println("--------------------------------------------------")
+	fmt.Println("show plans")
 	
-	// Try nested schemas
-	if schemas, exists := schema["schemas"]; exists {
-		if schemaMap, ok := schemas.(map[string]interface{}); ok {
-			for _, schemaValue := range schemaMap {
-				if schemaData, ok := schemaValue.(map[string]interface{}); ok {
-					if tables, exists := schemaData["tables"]; exists {
-						if tableMap, ok := tables.(map[string]interface{}); ok {
-							for name, tableData := range tableMap {
-								if strings.EqualFold(name, tableName) {
-									if tableInfo, ok := tableData.(map[string]interface{}); ok {
-										return tableInfo
-									}
-								}
-							}
-						}
-					}
-				}
-			}
-		}
+	ctx, cancel := c.commandContext()
+	defer cancel(nil)
+
+	body, err := c.api.QueryPlans(ctx)
+	if err != nil {
+		return contextCause(ctx, err)
 	}
-	
-	// Try direct table keys
-	for key, value := range schema {
-		if strings.EqualFold(key, tableName) {
-			if tableData, ok := value.(map[string]interface{}); ok {
-				return tableData
-			}
-		}
+
+	var prettyJSON bytes.Buffer
+	if err := json.Indent(&prettyJSON, body, "", "  "); err != nil {
+		fmt.Println(string(body))
+	} else {
+		fmt.Println(prettyJSON.String())
 	}
-	
+
 	return nil
 }
 
-type TableColumn struct {
-	Name        string
-	Type        string
-	Description string
-}
+var (
+	errQueryCancelled = errors.New("query cancelled by user")
+	errInterrupted    = errors.New("interrupted")
+)
 
-func (c *Client) extractAllTableSchemas(schema map[string]interface{}) map[string][]TableColumn {
-	tableSchemas := make(map[string][]TableColumn)
-	
-	// Pattern 1: Direct tables array
-	if tables, exists := schema["tables"]; exists {
-		if tableList, ok := tables.([]interface{}); ok {
-			for _, table := range tableList {
-				if tableMap, ok := table.(map[string]interface{}); ok {
-					if name, exists := tableMap["name"]; exists {
-						if nameStr, ok := name.(string); ok {
-							columns := c.extractColumns(tableMap)
-							tableSchemas[nameStr] = columns
-						}
-					}
-				}
-			}
-		} else if tableMap, ok := tables.(map[string]interface{}); ok {
-			// Pattern 1b: Tables as a map
-			for tableName, tableData := range tableMap {
-				if tableInfo, ok := tableData.(map[string]interface{}); ok {
-					columns := c.extractColumns(tableInfo)
-					tableSchemas[tableName] = columns
-				}
-			}
-		}
-	}
-	
-	// Pattern 2: Nested schemas structure
-	if schemas, exists := schema["schemas"]; exists {
-		if schemaMap, ok := schemas.(map[string]interface{}); ok {
-			for _, schemaValue := range schemaMap {
-				if schemaData, ok := schemaValue.(map[string]interface{}); ok {
-					if tables, exists := schemaData["tables"]; exists {
-						if tableMap, ok := tables.(map[string]interface{}); ok {
-							for tableName, tableData := range tableMap {
-								if tableInfo, ok := tableData.(map[string]interface{}); ok {
-									columns := c.extractColumns(tableInfo)
-									tableSchemas[tableName] = columns
-								}
-							}
-						}
-					}
-				}
-			}
-		}
+// newCommandContext returns the context for one command's API calls. It is
+// cancelled when timeout elapses (0 means no limit) or on Ctrl-C, so an
+// interrupt aborts the running command instead of the whole shell. The
+// returned function releases the timer and the signal handler.
+func newCommandContext(timeout time.Duration) (context.Context, context.CancelCauseFunc) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	cancelTimeout := context.CancelFunc(func() {})
+	if timeout > 0 {
+		ctx, cancelTimeout = context.WithTimeoutCause(ctx, timeout, fmt.Errorf("timed out after %s", timeout))
 	}
-	
-	// Pattern 3: Direct table names as top-level keys
-	if len(tableSchemas) == 0 {
-		for key, value := range schema {
-			// Skip common non-table keys
-			if key == "version" || key == "metadata" || key == "info" {
-				continue
-			}
-			
-			if tableData, ok := value.(map[string]interface{}); ok {
-				// Check if this looks like table data
-				if _, hasColumns := tableData["columns"]; hasColumns {
-					columns := c.extractColumns(tableData)
-					if len(columns) > 0 {
-						tableSchemas[key] = columns
-					}
-				}
-			}
+
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	go func() {
+		select {
+		case <-interrupts:
+			cancel(errInterrupted)
+		case <-ctx.Done():
 		}
+	}()
+
+	return ctx, func(cause error) {
+		signal.Stop(interrupts)
+		cancel(cause)
+		cancelTimeout()
 	}
-	
-	return tableSchemas
 }
 
-func (c *Client) extractColumns(tableData map[string]interface{}) []TableColumn {
-	var columns []TableColumn
-	
-	// Pattern 0: columnInfo (Soracom format)
-	if cols, exists := tableData["columnInfo"]; exists {
-		if colList, ok := cols.([]interface{}); ok {
-			for _, col := range colList {
-				if colMap, ok := col.(map[string]interface{}); ok {
-					name := ""
-					colType := ""
-					description := ""
-					
-					if n, exists := colMap["name"]; exists {
-						if nameStr, ok := n.(string); ok {
-							name = nameStr
-						}
-					}
-					
-					// Prefer databaseType over type for better precision
-					if t, exists := colMap["databaseType"]; exists {
-						if typeStr, ok := t.(string); ok {
-							colType = typeStr
-						}
-					} else if t, exists := colMap["type"]; exists {
-						if typeStr, ok := t.(string); ok {
-							colType = typeStr
-						}
-					}
-					
-					if d, exists := colMap["description"]; exists {
-						if descStr, ok := d.(string); ok {
-							description = descStr
-						}
-					}
-					
-					if name != "" {
-						if colType == "" {
-							colType = "UNKNOWN"
-						}
-						columns = append(columns, TableColumn{Name: name, Type: colType, Description: description})
-					}
-				}
-			}
-		}
+// commandContext returns a command context bounded by the session timeout
+func (c *Client) commandContext() (context.Context, context.CancelCauseFunc) {
+	return newCommandContext(c.timeout)
+}
+
+// contextCause replaces err with the reason ctx was cancelled, if it was,
+// so users see "timed out after 30s" rather than "context canceled".
+func contextCause(ctx context.Context, err error) error {
+	if err != nil && ctx.Err() != nil {
+		return context.Cause(ctx)
 	}
-	
-	// Pattern 1: columns as array of objects with name/type
-	if len(columns) == 0 && tableData["columns"] != nil {
-		if cols, exists := tableData["columns"]; exists {
-			if colList, ok := cols.([]interface{}); ok {
-				for _, col := range colList {
-					if colMap, ok := col.(map[string]interface{}); ok {
-						name := ""
-						colType := ""
-						
-						// Try different name fields
-						if n, exists := colMap["name"]; exists {
-							if nameStr, ok := n.(string); ok {
-								name = nameStr
-							}
-						} else if n, exists := colMap["column_name"]; exists {
-							if nameStr, ok := n.(string); ok {
-								name = nameStr
-							}
-						}
-						
-						// Try different type fields
-						if t, exists := colMap["type"]; exists {
-							if typeStr, ok := t.(string); ok {
-								colType = typeStr
-							}
-						} else if t, exists := colMap["data_type"]; exists {
-							if typeStr, ok := t.(string); ok {
-								colType = typeStr
-							}
-						} else if t, exists := colMap["column_type"]; exists {
-							if typeStr, ok := t.(string); ok {
-								colType = typeStr
-							}
-						}
-						
-						if name != "" {
-							if colType == "" {
-								colType = "UNKNOWN"
-							}
-							columns = append(columns, TableColumn{Name: name, Type: colType, Description: ""})
-						}
-					}
-				}
-			}
-			
-			// Pattern 2: columns as map of column_name -> column_info
-			if colMap, ok := cols.(map[string]interface{}); ok {
-				for colName, colData := range colMap {
-					colType := "UNKNOWN"
-					
-					if colInfo, ok := colData.(map[string]interface{}); ok {
-						// Try different type field names
-						if t, exists := colInfo["type"]; exists {
-							if typeStr, ok := t.(string); ok {
-								colType = typeStr
-							}
-						} else if t, exists := colInfo["data_type"]; exists {
-							if typeStr, ok := t.(string); ok {
-								colType = typeStr
-							}
-						}
-					} else if typeStr, ok := colData.(string); ok {
-						// Pattern 2b: column_name -> type_string directly
-						colType = typeStr
-					}
-					
-					columns = append(columns, TableColumn{Name: colName, Type: colType, Description: ""})
-				}
-			}
-		}
+	return err
+}
+
+func (c *Client) executeQuery(sqlQuery string, openFile bool) error {
+	if len(c.fanout) > 0 {
+		return c.executeFanoutQuery(sqlQuery, openFile)
 	}
-	
-	// Pattern 3: fields instead of columns
-	if len(columns) == 0 {
-		if fields, exists := tableData["fields"]; exists {
-			if fieldList, ok := fields.([]interface{}); ok {
-				for _, field := range fieldList {
-					if fieldMap, ok := field.(map[string]interface{}); ok {
-						name := ""
-						colType := ""
-						
-						if n, exists := fieldMap["name"]; exists {
-							if nameStr, ok := n.(string); ok {
-								name = nameStr
-							}
-						}
-						
-						if t, exists := fieldMap["type"]; exists {
-							if typeStr, ok := t.(string); ok {
-								colType = typeStr
-							}
-						}
-						
-						if name != "" {
-							if colType == "" {
-								colType = "UNKNOWN"
-							}
-							columns = append(columns, TableColumn{Name: name, Type: colType, Description: ""})
-						}
-					}
-				}
-			}
-		}
+
+	ctx, cancel := c.commandContext()
+	defer cancel(nil)
+
+	queryID, err := c.api.Submit(ctx, sqlQuery, &analysis.QueryOptions{From: c.fromTime, To: c.toTime})
+	if err != nil {
+		return contextCause(ctx, err)
 	}
-	
-	return columns
-}
 
-func (c *Client) showPlans() error {
-	fmt.Println("--------------------------------------------------")
-	fmt.Println("show plans")
-	
-	body, err := c.makeRequest("GET", fmt.Sprintf("https://%s/v1/analysis/plans/query", c.baseURL), nil)
+	status, err := c.waitForQuery(ctx, cancel, queryID)
+	if analysis.Abandoned(err) {
+		c.cancelOnServer(ctx, c.api, queryID)
+	}
 	if err != nil {
-		return err
+		return contextCause(ctx, err)
 	}
 
-	var prettyJSON bytes.Buffer
-	if err := json.Indent(&prettyJSON, body, "", "  "); err != nil {
-		fmt.Println(string(body))
-	} else {
-		fmt.Println(prettyJSON.String())
+	return contextCause(ctx, c.showResults(analysis.WithQueryID(ctx, queryID), status, openFile))
+}
+
+// runQuery executes a query, or only submits it when -submit is set
+func (c *Client) runQuery(sqlQuery string, openFile bool) error {
+	if c.submitOnly {
+		return c.submitQuery(sqlQuery)
 	}
+	return c.executeQuery(sqlQuery, openFile)
+}
 
-	return nil
+// fanoutTarget is one profile that fan-out queries run under
+type fanoutTarget struct {
+	profile string
+	api     *analysis.Client
 }
 
-func (c *Client) executeQuery(sqlQuery string, openFile bool) error {
-	if c.debug {
-		fmt.Printf("Executing SQL: %s\n", sqlQuery)
-		if c.fromTime > 0 {
-			fmt.Printf("From time: %d (%s)\n", c.fromTime, time.Unix(c.fromTime, 0).Format(time.RFC3339))
+// fanoutResult is the outcome of a fan-out query under one profile
+type fanoutResult struct {
+	target  fanoutTarget
+	queryID string
+	status  *analysis.QueryStatusResponse
+	err     error
+}
+
+// parseProfileList splits a comma-separated list of profile names such as
+// "prod,staging", dropping duplicates
+func parseProfileList(value string) ([]string, error) {
+	var names []string
+	seen := map[string]bool{}
+	for _, name := range strings.Split(strings.TrimRight(value, ";"), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			return nil, fmt.Errorf("empty profile name in '%s'", value)
 		}
-		if c.toTime > 0 {
-			fmt.Printf("To time: %d (%s)\n", c.toTime, time.Unix(c.toTime, 0).Format(time.RFC3339))
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
 		}
 	}
+	return names, nil
+}
 
-	// Create payload with optional time parameters
-	payload := map[string]interface{}{"sql": sqlQuery}
-	if c.fromTime > 0 {
-		payload["from"] = c.fromTime
-	}
-	if c.toTime > 0 {
-		payload["to"] = c.toTime
+// loginProfiles logs in with every named profile at once. Profiles that fail
+// are reported on stderr and left out; it is an error only if all of them fail.
+func loginProfiles(ctx context.Context, opts analysis.Options, names []string) ([]fanoutTarget, error) {
+	// Credentials given on the command line can't belong to every profile
+	opts.APIKey, opts.APIToken, opts.CredentialProcess = "", "", ""
+
+	apis := make([]*analysis.Client, len(names))
+	errs := make([]error, len(names))
+	var promptMu sync.Mutex
+	var wg sync.WaitGroup
+	for i, name := range names {
+		profileOpts := opts
+		profileOpts.Profile = name
+		if prompt := opts.PromptMFA; prompt != nil {
+			// One code prompt at a time, saying which profile it is for
+			profileOpts.PromptMFA = func(ctx context.Context) (string, error) {
+				promptMu.Lock()
+				defer promptMu.Unlock()
+				fmt.Fprintf(os.Stderr, "Profile '%s' requires a one-time code.\n", name)
+				return prompt(ctx)
+			}
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			apis[i], errs[i] = analysis.New(ctx, profileOpts)
+		}()
 	}
+	wg.Wait()
 
-	body, err := c.makeRequest("POST", fmt.Sprintf("https://%s/v1/analysis/queries", c.baseURL), payload)
-	if err != nil {
-		return err
+	var targets []fanoutTarget
+	for i, name := range names {
+		if errs[i] != nil {
+			fmt.Fprintf(os.Stderr, "Authentication failed for profile '%s': %v\n", name, contextCause(ctx, errs[i]))
+			continue
+		}
+		targets = append(targets, fanoutTarget{profile: name, api: apis[i]})
+	}
+	if len(targets) == 0 {
+		return nil, errors.New("no profile could log in")
 	}
+	return targets, nil
+}
 
-	var queryResp QueryResponse
-	if err := json.Unmarshal(body, &queryResp); err != nil {
-		return fmt.Errorf("failed to parse query response: %v", err)
+// fanoutProfiles returns the names of the profiles fan-out queries run under
+func (c *Client) fanoutProfiles() []string {
+	names := make([]string, len(c.fanout))
+	for i, target := range c.fanout {
+		names[i] = target.profile
 	}
+	return names
+}
 
-	if c.debug {
-		fmt.Printf("Query ID: %s\n", queryResp.QueryId)
+// promptName is the profile name shown in the interactive prompt
+func (c *Client) promptName() string {
+	if len(c.fanout) > 0 {
+		return strings.Join(c.fanoutProfiles(), ",")
 	}
+	return c.profileName
+}
 
-	// Show animation while waiting for query to complete (unless in debug or silent mode)
-	var stopAnimation chan bool
-	var stopCancel chan bool
-	cancelQuery := make(chan bool)
-	if !c.debug && !c.silent {
-		stopAnimation = make(chan bool)
-		stopCancel = make(chan bool)
-		go c.showQueryAnimation(stopAnimation, cancelQuery)
-		go c.watchForCancel(cancelQuery, stopCancel)
+// handleFanoutCommand implements .fanout [show|off|<profile>,<profile>...]
+func (c *Client) handleFanoutCommand(parts []string) {
+	arg := ""
+	if len(parts) > 1 {
+		arg = strings.TrimRight(strings.Join(parts[1:], ","), ";")
 	}
-	
-	// Wait for either timeout or cancellation
-	select {
-	case <-time.After(5 * time.Second):
-		// Normal timeout - continue with query
-		if !c.debug && !c.silent {
-			stopAnimation <- true
-			stopCancel <- true // Stop the key monitoring
-		}
-	case <-cancelQuery:
-		// User cancelled - stop animation and return error
-		if !c.debug && !c.silent {
-			stopAnimation <- true
-			stopCancel <- true // Stop the key monitoring
-		}
-		return fmt.Errorf("query cancelled by user")
-	}
-
-	// Poll for query completion with retry logic
-	var statusResp QueryStatusResponse
-	maxRetries := 30 // Max 30 retries (about 5 minutes with 10 second intervals)
-	for i := 0; i < maxRetries; i++ {
-		body, err = c.makeRequest("GET", fmt.Sprintf("https://%s/v1/analysis/queries/%s?exportFormat=jsonl", c.baseURL, queryResp.QueryId), nil)
-		if err != nil {
-			return err
-		}
 
-		if c.debug {
-			fmt.Printf("Status check %d: %s\n", i+1, string(body))
+	switch strings.ToLower(arg) {
+	case "", "show":
+		if len(c.fanout) == 0 {
+			fmt.Println("Fan-out is off.")
+		} else {
+			fmt.Printf("Fan-out profiles: %s\n", strings.Join(c.fanoutProfiles(), ", "))
 		}
+		return
+	case "off":
+		c.fanout = nil
+		fmt.Println("Fan-out turned off.")
+		return
+	}
 
-		if err := json.Unmarshal(body, &statusResp); err != nil {
-			return fmt.Errorf("failed to parse status response: %v", err)
-		}
+	names, err := parseProfileList(arg)
+	if err != nil {
+		fmt.Println("Usage: .fanout [show|off|<profile>,<profile>...]")
+		fmt.Println("Examples:")
+		fmt.Println("  .fanout                # Show the fan-out profiles")
+		fmt.Println("  .fanout prod,staging   # Run queries under prod and staging at once")
+		fmt.Println("  .fanout off            # Run queries under the current profile only")
+		return
+	}
+	if c.apiOptions.Config != nil {
+		fmt.Println("Fan-out is not available while replaying a recording.")
+		return
+	}
+	if c.submitOnly {
+		// Like -profiles with -submit: each profile would need its own query ID
+		fmt.Println("Fan-out is not available with -submit.")
+		return
+	}
 
-		// Check if query is completed
-		if statusResp.Status == "COMPLETED" {
-			if c.debug {
-				fmt.Printf("Query completed after %d status checks\n", i+1)
+	ctx, cancel := c.commandContext()
+	defer cancel(nil)
+	targets, err := loginProfiles(ctx, c.apiOptions, names)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to turn on fan-out: %v\n", contextCause(ctx, err))
+		return
+	}
+
+	c.fanout = targets
+	fmt.Printf("Fan-out profiles: %s\n", strings.Join(c.fanoutProfiles(), ", "))
+}
+
+// executeFanoutQuery runs a query under every fan-out profile at once and
+// displays the results as one set with a _profile column. A profile that
+// fails is reported on stderr without stopping the others.
+func (c *Client) executeFanoutQuery(sqlQuery string, openFile bool) error {
+	ctx, cancel := c.commandContext()
+	defer cancel(nil)
+
+	// A merged result cannot be downloaded again by .export
+	c.lastResult = nil
+
+	results := make([]fanoutResult, len(c.fanout))
+	c.waitWithAnimation(ctx, cancel, func(onStatus func(string)) error {
+		var mu sync.Mutex
+		statuses := make([]string, len(c.fanout))
+		var wg sync.WaitGroup
+		for i, target := range c.fanout {
+			report := func(status string) {
+				if onStatus == nil {
+					return
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				statuses[i] = target.profile + ": " + status
+				onStatus(strings.Join(statuses, ", "))
 			}
-			break
+			report("SUBMITTED")
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				results[i] = c.runFanoutTarget(ctx, target, sqlQuery, report)
+			}()
 		}
-		
-		// If status is EXPORTING or RUNNING, wait and retry
-		if statusResp.Status == "EXPORTING" || statusResp.Status == "RUNNING" {
-			if c.debug {
-				fmt.Printf("Query status: %s, waiting 10 seconds before retry...\n", statusResp.Status)
-			}
-			time.Sleep(10 * time.Second)
+		wg.Wait()
+		return nil
+	})
+	if context.Cause(ctx) != nil {
+		return contextCause(ctx, ctx.Err())
+	}
+
+	var completed []fanoutResult
+	for _, result := range results {
+		if result.err != nil {
+			fmt.Fprintf(os.Stderr, "Error [%s]: %v\n", result.target.profile, result.err)
 			continue
 		}
-		
-		// If status is FAILED or other error state, return error
-		if statusResp.Status == "FAILED" {
-			return fmt.Errorf("query failed: %s", string(body))
-		}
-		
-		// For any other status, wait and retry
-		if c.debug {
-			fmt.Printf("Unknown status: %s, waiting 5 seconds before retry...\n", statusResp.Status)
-		}
-		time.Sleep(5 * time.Second)
+		completed = append(completed, result)
 	}
-	
-	// Check if we exhausted retries without completion
-	if statusResp.Status != "COMPLETED" {
-		return fmt.Errorf("query did not complete within timeout, final status: %s", statusResp.Status)
+	failed := len(results) - len(completed)
+	if len(completed) == 0 {
+		return fmt.Errorf("the query failed under all %d profiles", len(results))
 	}
 
-	filename := filepath.Base(strings.Split(statusResp.URL, "?")[0])
-	if c.debug {
-		fmt.Printf("File Name: %s\n", filename)
+	pr, pw := io.Pipe()
+	mergeFailed := make(chan int, 1)
+	go func() {
+		n := c.mergeFanoutResults(ctx, completed, pw)
+		pw.Close()
+		mergeFailed <- n
+	}()
+
+	fileName := "fanout-" + analysis.ResultFileName(completed[0].status)
+	err := c.displayResults(pr, fanoutColumns(completed), fileName, openFile)
+	pr.Close()
+	failed += <-mergeFailed
+	if err != nil {
+		return contextCause(ctx, err)
+	}
+	if failed > 0 {
+		return fmt.Errorf("the query failed under %d of %d profiles", failed, len(results))
 	}
+	return nil
+}
 
-	tmpPath := filepath.Join("/tmp", filename)
-	if err := c.downloadFile(statusResp.URL, tmpPath); err != nil {
-		return fmt.Errorf("failed to download file: %v", err)
+// runFanoutTarget submits a query under one profile and waits for it
+func (c *Client) runFanoutTarget(ctx context.Context, target fanoutTarget, sqlQuery string, onStatus func(string)) fanoutResult {
+	result := fanoutResult{target: target}
+	result.queryID, result.err = target.api.Submit(ctx, sqlQuery, &analysis.QueryOptions{From: c.fromTime, To: c.toTime})
+	if result.err != nil {
+		return result
 	}
+	result.status, result.err = target.api.Wait(ctx, result.queryID, c.waitOptions(onStatus))
+	if analysis.Abandoned(result.err) {
+		c.cancelOnServer(ctx, target.api, result.queryID)
+	}
+	return result
+}
 
-	if c.debug {
-		fmt.Printf("Downloaded to: %s\n", tmpPath)
+// mergeFanoutResults downloads the results of each profile in turn and
+// writes their records to w with a "_profile" field added. A profile whose
+// download fails is reported on stderr and skipped; the number of such
+// profiles is returned.
+func (c *Client) mergeFanoutResults(ctx context.Context, results []fanoutResult, w io.Writer) (failed int) {
+	enc := json.NewEncoder(w)
+	for _, result := range results {
+		profile, _ := json.Marshal(result.target.profile)
+		err := c.copyFanoutResult(ctx, result, func(record map[string]json.RawMessage) error {
+			record["_profile"] = profile
+			return enc.Encode(record)
+		})
+		if errors.Is(err, io.ErrClosedPipe) {
+			// The reader stopped, e.g. because displaying the rows failed
+			return failed
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error [%s]: %v\n", result.target.profile, contextCause(ctx, err))
+			failed++
+		}
 	}
+	return failed
+}
 
-	decompressedPath := strings.TrimSuffix(tmpPath, ".gz")
-	if err := c.decompressFile(tmpPath, decompressedPath); err != nil {
-		return fmt.Errorf("failed to decompress file: %v", err)
+// copyFanoutResult downloads the results of one profile and passes each
+// record to write
+func (c *Client) copyFanoutResult(ctx context.Context, result fanoutResult, write func(map[string]json.RawMessage) error) error {
+	opts := &analysis.DownloadOptions{MaxSize: c.maxDownloadSize, Retries: c.apiOptions.Retries}
+	if c.showProgress() {
+		progress := newDownloadProgress()
+		defer progress.stop()
+		opts.OnProgress = progress.update
 	}
 
-	if c.debug {
-		if info, err := os.Stat(decompressedPath); err == nil {
-			fmt.Printf("Decompressed file size: %d bytes\n", info.Size())
-		}
+	body, err := result.target.api.OpenResults(analysis.WithQueryID(ctx, result.queryID), result.status, opts)
+	if err != nil {
+		return err
 	}
+	defer body.Close()
 
-	if openFile {
-		if err := c.openInEditor(decompressedPath); err != nil {
-			fmt.Printf("Warning: failed to open file in editor: %v\n", err)
+	// Keep the values as they are, so large numbers don't lose precision
+	dec := json.NewDecoder(body)
+	for {
+		var record map[string]json.RawMessage
+		if err := dec.Decode(&record); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed to read results: %v", err)
+		}
+		if err := write(record); err != nil {
+			return err
 		}
 	}
+}
 
-	return c.displayJSONFile(decompressedPath, statusResp.ColumnInfo)
+// fanoutColumns describes the merged results: the _profile column followed
+// by the columns of every profile, in the order they first appear
+func fanoutColumns(results []fanoutResult) []analysis.ColumnInfo {
+	columns := []analysis.ColumnInfo{{Name: "_profile", Type: "string", DatabaseType: "VARCHAR"}}
+	seen := map[string]bool{"_profile": true}
+	for _, result := range results {
+		for _, column := range result.status.ColumnInfo {
+			if !seen[column.Name] {
+				seen[column.Name] = true
+				columns = append(columns, column)
+			}
+		}
+	}
+	return columns
 }
 
-func (c *Client) callSQLAssistant(context, existingQuery string) (*SQLAssistantResponse, error) {
-	request := SQLAssistantRequest{
-		Messages: []SQLAssistantMessage{
-			{
-				Role:      "user",
-				Context:   context,
-				AgentMode: false,
-			},
-		},
-		TimeRange: SQLAssistantTimeRange{
-			Hours: 2,
-		},
-		ExistingQuery: existingQuery,
+// submitQuery starts a query and prints its ID without waiting for it
+func (c *Client) submitQuery(sqlQuery string) error {
+	ctx, cancel := c.commandContext()
+	defer cancel(nil)
+
+	queryID, err := c.api.Submit(ctx, sqlQuery, &analysis.QueryOptions{From: c.fromTime, To: c.toTime})
+	if err != nil {
+		return contextCause(ctx, err)
 	}
 
-	// Save current custom headers and temporarily add SQL helper flag
-	originalHeaders := make(map[string]string)
-	for k, v := range c.customHeaders {
-		originalHeaders[k] = v
+	fmt.Println(queryID)
+	return nil
+}
+
+// fetchQuery waits for a previously submitted query and displays its results.
+// Aborting a fetch leaves the query running so it can be fetched again.
+func (c *Client) fetchQuery(queryID string, openFile bool) error {
+	ctx, cancel := c.commandContext()
+	defer cancel(nil)
+
+	status, err := c.waitForQuery(ctx, cancel, queryID)
+	if err != nil {
+		return contextCause(ctx, err)
 	}
-	c.customHeaders["x-soracom-dynamicroutes"] = "add-sql-helper"
 
-	url := fmt.Sprintf("https://%s/v1/analysis/sql_assistant", c.baseURL)
-	response, err := c.makeRequest("POST", url, request)
-	
-	// Restore original custom headers
-	c.customHeaders = originalHeaders
-	
+	return contextCause(ctx, c.showResults(analysis.WithQueryID(ctx, queryID), status, openFile))
+}
+
+// showQueryStatus prints the status of a query without waiting for it
+func (c *Client) showQueryStatus(queryID string) error {
+	ctx, cancel := c.commandContext()
+	defer cancel(nil)
+
+	status, err := c.api.Status(ctx, queryID)
 	if err != nil {
-		return nil, err
+		return contextCause(ctx, err)
 	}
 
-	var sqlAssistantResponse SQLAssistantResponse
-	if err := json.Unmarshal(response, &sqlAssistantResponse); err != nil {
-		return nil, fmt.Errorf("failed to parse SQL assistant response: %w", err)
+	if c.format == "json" {
+		jsonBytes, err := json.MarshalIndent(status, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(jsonBytes))
+		return nil
 	}
 
-	return &sqlAssistantResponse, nil
+	fmt.Printf("Query ID: %s\n", queryID)
+	fmt.Printf("Status:   %s\n", status.Status)
+	if status.URL != "" {
+		fmt.Printf("URL:      %s\n", status.URL)
+	}
+	if len(status.ColumnInfo) > 0 {
+		fmt.Println("Columns:")
+		for _, col := range status.ColumnInfo {
+			fmt.Printf("  %s (%s, %s)\n", col.Name, col.Type, col.DatabaseType)
+		}
+	}
+	return nil
 }
 
-func (c *Client) downloadFile(url, filepath string) error {
-	resp, err := http.Get(url)
-	if err != nil {
-		return err
+// showResults streams the results of a completed query into the current
+// format. The raw JSONL is saved as it streams in when a keep directory is
+// set, or when openFile asks for it to be opened in an editor afterwards.
+func (c *Client) showResults(ctx context.Context, status *analysis.QueryStatusResponse, openFile bool) error {
+	c.lastResult = status
+
+	opts := &analysis.DownloadOptions{MaxSize: c.maxDownloadSize, Retries: c.apiOptions.Retries}
+	if c.showProgress() {
+		progress := newDownloadProgress()
+		defer progress.stop()
+		opts.OnProgress = progress.update
 	}
-	defer resp.Body.Close()
 
-	out, err := os.Create(filepath)
+	body, err := c.api.OpenResults(ctx, status, opts)
 	if err != nil {
 		return err
 	}
-	defer out.Close()
+	defer body.Close()
 
-	_, err = io.Copy(out, resp.Body)
-	return err
+	return c.displayResults(body, status.ColumnInfo, analysis.ResultFileName(status), openFile)
 }
 
-func (c *Client) decompressFile(src, dst string) error {
-	srcFile, err := os.Open(src)
-	if err != nil {
-		return err
+// displayResults displays the JSONL records read from body. With -keep-results
+// or -open they are also saved to a file with the given name.
+func (c *Client) displayResults(body io.ReadCloser, columns []analysis.ColumnInfo, fileName string, openFile bool) error {
+	var err error
+	dir := c.keepDir
+	if dir == "" && openFile {
+		if dir, err = c.privateResultDir(); err != nil {
+			return err
+		}
+	}
+	if dir == "" {
+		return c.displayRows(analysis.NewRows(body, columns))
 	}
-	defer srcFile.Close()
 
-	gzReader, err := gzip.NewReader(srcFile)
+	result, err := createResultFile(dir, fileName)
 	if err != nil {
 		return err
 	}
-	defer gzReader.Close()
 
-	dstFile, err := os.Create(dst)
-	if err != nil {
+	c.log().Debug("Saving results", "path", result.path)
+
+	rows := analysis.NewRows(io.NopCloser(io.TeeReader(body, result.file)), columns)
+	if err := c.displayRows(rows); err != nil {
+		result.discard()
+		return err
+	}
+	if err := result.commit(); err != nil {
 		return err
 	}
-	defer dstFile.Close()
 
-	_, err = io.Copy(dstFile, gzReader)
-	return err
+	if c.keepDir != "" {
+		fmt.Fprintf(os.Stderr, "Results saved to: %s\n", result.path)
+	}
+	if openFile {
+		if err := c.openInEditor(result.path); err != nil {
+			fmt.Printf("Warning: failed to open file in editor: %v\n", err)
+		} else if c.keepDir == "" {
+			c.resultMu.Lock()
+			c.resultOpened = true
+			c.resultMu.Unlock()
+		}
+	}
+	return nil
 }
 
-func (c *Client) openInEditor(filepath string) error {
-	cmd := exec.Command("open", "-e", filepath)
-	return cmd.Run()
-}
+// resultDirPattern names the private result directories in the temporary
+// directory
+const resultDirPattern = "soraql-*"
 
+// leftoverMarker is the file that marks a private result directory that was
+// kept on exit because an editor may still be loading a file from it
+const leftoverMarker = ".exited"
 
-func (c *Client) watchForCancel(cancel chan bool, stop chan bool) {
-	// Set terminal to raw mode to read individual key presses
-	exec.Command("stty", "-f", "/dev/tty", "cbreak", "min", "0", "time", "1").Run()
-	exec.Command("stty", "-f", "/dev/tty", "-echo").Run()
-	defer func() {
-		// Restore normal terminal mode
-		exec.Command("stty", "-f", "/dev/tty", "echo").Run()
-		exec.Command("stty", "-f", "/dev/tty", "-cbreak").Run()
-		exec.Command("stty", "-f", "/dev/tty", "min", "1", "time", "0").Run()
-	}()
+// privateResultDir returns the directory for result files that are only
+// needed while soraql runs. It is created on first use, readable only by
+// the current user, and removed by cleanupResults.
+func (c *Client) privateResultDir() (string, error) {
+	c.resultMu.Lock()
+	defer c.resultMu.Unlock()
+
+	if c.resultDir == "" {
+		dir, err := os.MkdirTemp("", resultDirPattern)
+		if err != nil {
+			return "", fmt.Errorf("failed to create result directory: %v", err)
+		}
+		// Not only the umask decides: the directory must be private
+		if err := os.Chmod(dir, 0700); err != nil {
+			os.RemoveAll(dir)
+			return "", fmt.Errorf("failed to secure result directory: %v", err)
+		}
+		if info, err := os.Stat(dir); err != nil || info.Mode().Perm() != 0700 {
+			os.RemoveAll(dir)
+			return "", fmt.Errorf("failed to secure result directory %s", dir)
+		}
+		c.resultDir = dir
+	}
+	return c.resultDir, nil
+}
+
+// cleanupResults removes the private result directory and everything in it.
+// `open -e` returns before the editor has read the file, so a directory with
+// a file handed to an editor is only marked, and removed by
+// removeLeftoverResults the next time soraql starts.
+func (c *Client) cleanupResults() {
+	c.resultMu.Lock()
+	defer c.resultMu.Unlock()
+
+	if c.resultDir == "" {
+		return
+	}
+	if c.resultOpened {
+		if err := os.WriteFile(filepath.Join(c.resultDir, leftoverMarker), nil, 0600); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to mark result files in %s for removal: %v\n", c.resultDir, err)
+		}
+	} else if err := os.RemoveAll(c.resultDir); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to remove result files in %s: %v\n", c.resultDir, err)
+	}
+	c.resultDir = ""
+	c.resultOpened = false
+}
+
+// removeLeftoverResults removes the private result directories that earlier
+// runs kept for an editor. Directories of other running sessions have no
+// marker and are left alone.
+func removeLeftoverResults() {
+	dirs, _ := filepath.Glob(filepath.Join(os.TempDir(), resultDirPattern))
+	for _, dir := range dirs {
+		if info, err := os.Lstat(dir); err != nil || !info.IsDir() {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(dir, leftoverMarker)); err != nil {
+			continue
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to remove result files in %s: %v\n", dir, err)
+		}
+	}
+}
+
+// exit removes the private result files and exits with code
+func (c *Client) exit(code int) {
+	c.cleanupResults()
+	os.Exit(code)
+}
+
+// resultFile is a result download being saved to disk. It is written under a
+// ".partial" name and only renamed into place once the whole result has been
+// read, so an interrupted or truncated download never looks complete.
+type resultFile struct {
+	file *os.File
+	path string
+}
+
+// createResultFile starts saving a result named name in dir. If a file of
+// that name already exists, a numbered suffix is added instead of
+// overwriting it.
+func createResultFile(dir, name string) (*resultFile, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create result directory: %v", err)
+	}
+
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i := 0; ; i++ {
+		path := filepath.Join(dir, name)
+		if i > 0 {
+			path = filepath.Join(dir, fmt.Sprintf("%s-%d%s", base, i, ext))
+		}
+		if _, err := os.Lstat(path); err == nil {
+			continue // Collides with a completed result
+		}
+
+		file, err := os.OpenFile(path+".partial", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if errors.Is(err, os.ErrExist) {
+			continue // Another download of the same name is in progress
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to save result file: %v", err)
+		}
+		return &resultFile{file: file, path: path}, nil
+	}
+}
+
+// commit moves a completely downloaded result into place
+func (r *resultFile) commit() error {
+	if err := r.file.Close(); err != nil {
+		os.Remove(r.file.Name())
+		return fmt.Errorf("failed to save result file: %v", err)
+	}
+	if err := os.Rename(r.file.Name(), r.path); err != nil {
+		os.Remove(r.file.Name())
+		return fmt.Errorf("failed to save result file: %v", err)
+	}
+	return nil
+}
+
+// discard removes an incomplete result
+func (r *resultFile) discard() {
+	r.file.Close()
+	os.Remove(r.file.Name())
+}
+
+// cancelOnServer stops an abandoned query in the warehouse so it doesn't keep
+// using quota, and reports whether the server confirmed the cancellation.
+func (c *Client) cancelOnServer(ctx context.Context, api *analysis.Client, queryID string) {
+	if err := api.CancelAbandoned(ctx, queryID); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: query %s may still be running on the server: %v\n", queryID, err)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Query %s cancelled on the server.\n", queryID)
+}
+
+// waitForQuery polls a submitted query until it completes. Unless in debug
+// or silent mode it shows a spinner and lets ESC cancel the query.
+func (c *Client) waitForQuery(ctx context.Context, cancel context.CancelCauseFunc, queryID string) (*analysis.QueryStatusResponse, error) {
+	var status *analysis.QueryStatusResponse
+	err := c.waitWithAnimation(ctx, cancel, func(onStatus func(string)) error {
+		var err error
+		status, err = c.api.Wait(ctx, queryID, c.waitOptions(onStatus))
+		return err
+	})
+	return status, err
+}
+
+// waitWithAnimation runs wait, which reports query statuses to onStatus.
+// Unless in debug or silent mode it shows them with a spinner and lets ESC
+// cancel ctx; otherwise onStatus is nil.
+func (c *Client) waitWithAnimation(ctx context.Context, cancel context.CancelCauseFunc, wait func(onStatus func(string)) error) error {
+	if c.debug || c.silent {
+		return wait(nil)
+	}
+
+	c.queryStatus.Store("SUBMITTED")
+	stopAnimation := make(chan bool)
+	cancelAnimation := make(chan bool)
+	stopCancel := make(chan bool)
+	cancelQuery := make(chan bool)
+	go c.showQueryAnimation(stopAnimation, cancelAnimation)
+	go c.watchForCancel(cancelQuery, stopCancel)
+	go func() {
+		select {
+		case <-cancelQuery:
+			cancel(errQueryCancelled)
+		case <-stopCancel:
+		}
+	}()
+
+	err := wait(func(status string) {
+		c.queryStatus.Store(status)
+	})
+
+	close(stopCancel) // Stop the key monitoring
+	if errors.Is(context.Cause(ctx), errQueryCancelled) {
+		cancelAnimation <- true
+	} else {
+		stopAnimation <- true
+	}
+	return err
+}
+
+// showProgress reports whether to draw a download progress bar. It is drawn
+// on stderr, and only when it can't garble streamed rows on the same terminal.
+func (c *Client) showProgress() bool {
+	return !c.silent && !c.debug && (c.format == "table" || c.format == "vertical" || isFileFormat(c.format) || !isTerminal(os.Stdout))
+}
+
+// downloadProgress draws a progress bar with the bytes received and the
+// transfer rate of a result download.
+type downloadProgress struct {
+	start     time.Time
+	lastDrawn time.Time
+	drawn     bool
+}
+
+func newDownloadProgress() *downloadProgress {
+	return &downloadProgress{start: time.Now()}
+}
+
+// update redraws the bar at most ten times per second
+func (p *downloadProgress) update(downloaded, total int64) {
+	now := time.Now()
+	if now.Sub(p.lastDrawn) < 100*time.Millisecond && downloaded != total {
+		return
+	}
+	p.lastDrawn = now
+	p.drawn = true
+
+	rate := ""
+	if elapsed := now.Sub(p.start).Seconds(); elapsed > 0 {
+		rate = fmt.Sprintf(" (%s/s)", formatBytes(int64(float64(downloaded)/elapsed)))
+	}
+
+	if total <= 0 {
+		fmt.Fprintf(os.Stderr, "\r\033[2K\033[36mDownloading %s%s\033[0m", formatBytes(downloaded), rate)
+		return
+	}
+
+	const barWidth = 30
+	filled := int(min(downloaded, total) * barWidth / total)
+	fmt.Fprintf(os.Stderr, "\r\033[2K\033[36m[%s%s] %s / %s%s\033[0m",
+		strings.Repeat("█", filled), strings.Repeat("░", barWidth-filled),
+		formatBytes(downloaded), formatBytes(total), rate)
+}
+
+// stop clears the progress bar
+func (p *downloadProgress) stop() {
+	if p.drawn {
+		fmt.Fprint(os.Stderr, "\r\033[2K\033[0m")
+	}
+}
+
+// terminalSize returns the number of columns and lines of the terminal f
+// writes to, or zeros if f is not a terminal
+func terminalSize(f *os.File) (width, height int) {
+	width, height, err := term.GetSize(int(f.Fd()))
+	if err != nil {
+		return 0, 0
+	}
+	return width, height
+}
+
+// isTerminal reports whether f is a terminal rather than a file or pipe
+func isTerminal(f *os.File) bool {
+	stat, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return (stat.Mode() & os.ModeCharDevice) != 0
+}
+
+// formatBytes renders a byte count with a binary unit, e.g. "1.5 MB"
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// parseByteSize parses a size such as "1048576", "500MB" or "2GB", using
+// binary multiples. An empty string, "0" or "off" mean no limit.
+func parseByteSize(value string) (int64, error) {
+	switch strings.ToLower(value) {
+	case "", "0", "off", "none":
+		return 0, nil
+	}
+
+	upper := strings.ToUpper(strings.TrimSpace(value))
+	multiplier := int64(1)
+	for _, suffix := range []struct {
+		unit       string
+		multiplier int64
+	}{
+		{"KB", 1 << 10}, {"MB", 1 << 20}, {"GB", 1 << 30}, {"TB", 1 << 40}, {"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30}, {"T", 1 << 40}, {"B", 1},
+	} {
+		if strings.HasSuffix(upper, suffix.unit) {
+			upper = strings.TrimSpace(strings.TrimSuffix(upper, suffix.unit))
+			multiplier = suffix.multiplier
+			break
+		}
+	}
+
+	size, err := strconv.ParseFloat(upper, 64)
+	if err != nil || math.IsNaN(size) || math.IsInf(size, 0) || size <= 0 {
+		return 0, fmt.Errorf("must be a positive size like '500MB' or 'off'")
+	}
+	if size >= math.MaxInt64/float64(multiplier) {
+		return 0, fmt.Errorf("must be smaller than %s", formatBytes(math.MaxInt64))
+	}
+	return int64(size * float64(multiplier)), nil
+}
+
+// waitOptions returns the polling settings of the current session
+func (c *Client) waitOptions(onStatus func(status string)) *analysis.WaitOptions {
+	return &analysis.WaitOptions{
+		Interval: c.pollInterval,
+		MaxWait:  c.maxWait,
+		OnStatus: onStatus,
+	}
+}
+
+func (c *Client) openInEditor(filepath string) error {
+	cmd := exec.Command("open", "-e", filepath)
+	return cmd.Run()
+}
+
+
+func (c *Client) watchForCancel(cancel chan bool, stop chan bool) {
+	// Set terminal to raw mode to read individual key presses
+	exec.Command("stty", "-f", "/dev/tty", "cbreak", "min", "0", "time", "1").Run()
+	exec.Command("stty", "-f", "/dev/tty", "-echo").Run()
+	defer func() {
+		// Restore normal terminal mode
+		exec.Command("stty", "-f", "/dev/tty", "echo").Run()
+		exec.Command("stty", "-f", "/dev/tty", "-cbreak").Run()
+		exec.Command("stty", "-f", "/dev/tty", "min", "1", "time", "0").Run()
+	}()
 
 	// Poll for input with timeout
 	ticker := time.NewTicker(50 * time.Millisecond)
@@ -2280,9 +3149,13 @@ func (c *Client) showQueryAnimation(stop chan bool, cancel chan bool) {
 		case <-ticker.C:
 			// Calculate elapsed seconds
 			elapsed := int(time.Since(startTime).Seconds())
-			// Show spinner with message and elapsed time in cyan color
-			fmt.Printf("\r\033[2K\033[36m%s Executing query... %ds (press ESC to cancel)\033[0m", 
-				spinners[frame%len(spinners)], elapsed)
+			// Show spinner with message, query status and elapsed time in cyan color
+			status, _ := c.queryStatus.Load().(string)
+			if status == "" {
+				status = "SUBMITTED"
+			}
+			fmt.Printf("\r\033[2K\033[36m%s Executing query [%s]... %ds (press ESC to cancel)\033[0m", 
+				spinners[frame%len(spinners)], status, elapsed)
 			frame++
 		}
 	}
@@ -2313,218 +3186,1093 @@ func (c *Client) showSQLAssistantAnimation(stop chan bool) {
 }
 
 
-func (c *Client) displayJSONFile(filepath string, columnInfo []ColumnInfo) error {
-	file, err := os.Open(filepath)
-	if err != nil {
-		return err
+// displayRows renders a query result in the current format. CSV, JSON,
+// JSONL, Parquet and SQLite are written row by row as the result streams in,
+// so memory use does not grow with the result size; the table format has to
+// collect every row to size its columns.
+func (c *Client) displayRows(result *analysis.Rows) error {
+	switch c.format {
+	case "parquet":
+		return c.writeParquet(result)
+	case "sqlite":
+		return writeSQLite(result, c.output, c.table, c.ifExists)
 	}
-	defer file.Close()
 
-	// Parse all rows to determine columns and data
-	var rows []map[string]interface{}
-	var columnOrder []string
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
 
-	// Use column info from API response if available
-	if len(columnInfo) > 0 {
-		for _, col := range columnInfo {
-			columnOrder = append(columnOrder, col.Name)
-		}
+	switch c.format {
+	case "csv":
+		return c.displayCSV(out, result)
+	case "json":
+		return c.displayJSON(out, result)
+	case "jsonl":
+		return c.displayJSONL(out, result)
 	}
 
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.TrimSpace(line) == "" {
-			continue
-		}
-
-		var row map[string]interface{}
-		if err := json.Unmarshal([]byte(line), &row); err != nil {
-			// If JSON parsing fails, show the raw line
-			fmt.Println(line)
-			continue
+	var rows []map[string]interface{}
+	var columnOrder []string
+	for result.Next() {
+		row := result.Row()
+		if len(rows) == 0 {
+			columnOrder = resultColumns(result, row)
 		}
-
 		rows = append(rows, row)
-
-		// If no column info from API, fall back to tracking column names in order of first appearance
-		if len(columnOrder) == 0 {
-			columnSet := make(map[string]bool)
-			for key := range row {
-				if !columnSet[key] {
-					columnOrder = append(columnOrder, key)
-					columnSet[key] = true
-				}
-			}
-		}
 	}
-
-	if err := scanner.Err(); err != nil {
+	if err := result.Err(); err != nil {
 		return err
 	}
 
 	if len(rows) == 0 {
-		fmt.Println("No results found.")
+		fmt.Fprintln(out, "No results found.")
 		return nil
 	}
 
-	// Display in the specified format
-	switch c.format {
-	case "table":
-		c.displayTable(columnOrder, rows)
-	case "csv":
-		c.displayCSV(columnOrder, rows)
-	case "json":
-		c.displayJSON(rows)
-	default:
-		c.displayTable(columnOrder, rows) // fallback to table
+	// Render the whole result first to decide whether it needs the pager
+	var page bytes.Buffer
+	if c.useVertical(columnOrder, rows) {
+		c.displayVertical(&page, columnOrder, rows)
+	} else {
+		c.displayTable(&page, columnOrder, rows)
 	}
-	return nil
+	return c.pageOutput(page.Bytes())
 }
 
-func (c *Client) displayTable(columns []string, rows []map[string]interface{}) {
-	if len(rows) == 0 {
-		return
+// resultColumns returns the column order of a result: the column info from
+// the API if available, otherwise the sorted keys of the first row.
+func resultColumns(result *analysis.Rows, first map[string]interface{}) []string {
+	var columns []string
+	for _, col := range result.Columns {
+		columns = append(columns, col.Name)
+	}
+	if len(columns) > 0 {
+		return columns
 	}
 
-	// Calculate column widths and determine if column is numeric
+	for key := range first {
+		columns = append(columns, key)
+	}
+	sort.Strings(columns)
+	return columns
+}
+
+// useVertical reports whether table results are shown as vertical records:
+// always with -format vertical or .expanded on, and with .expanded auto when
+// the table would be wider than the terminal.
+func (c *Client) useVertical(columns []string, rows []map[string]interface{}) bool {
+	if c.format == "vertical" {
+		return true
+	}
+	switch c.expandedMode() {
+	case "on":
+		return true
+	case "auto":
+		width, _ := terminalSize(os.Stdout)
+		return width > 0 && tableWidth(columns, c.columnWidths(columns, rows)) > width
+	}
+	return false
+}
+
+// tableCell returns the text of a table cell, truncated to -max-column-width
+func (c *Client) tableCell(val interface{}) string {
+	return truncateCell(c.formatValue(val), c.maxColumnWidth)
+}
+
+// truncateCell shortens s to width terminal cells, ending it with "…" to
+// show that it was cut. A width of 0 means no limit.
+func truncateCell(s string, width int) string {
+	if width <= 0 {
+		return s
+	}
+	return displayWidth.Truncate(s, width, "…")
+}
+
+// displayWidth measures text in terminal cells: two for full-width
+// characters such as kanji and for emoji, including sequences joined with
+// ZWJ. Characters of ambiguous width, which include the box-drawing ones of
+// the tables, count as one whatever the locale, so that borders line up.
+var displayWidth = &runewidth.Condition{EastAsianWidth: false, StrictEmojiNeutral: true}
+
+// cellWidth returns the number of terminal cells s takes up
+func cellWidth(s string) int {
+	return displayWidth.StringWidth(s)
+}
+
+// padCell pads s with spaces to width cells, on the left if alignRight
+func padCell(s string, width int, alignRight bool) string {
+	padding := strings.Repeat(" ", max(0, width-cellWidth(s)))
+	if alignRight {
+		return padding + s
+	}
+	return s + padding
+}
+
+// columnWidths returns the width of each column of displayTable: its widest
+// value or its header
+func (c *Client) columnWidths(columns []string, rows []map[string]interface{}) map[string]int {
 	widths := make(map[string]int)
-	isNumeric := make(map[string]bool)
-	
 	for _, col := range columns {
-		widths[col] = len(col) // Start with header width
-		isNumeric[col] = c.isColumnNumeric(col, rows)
+		widths[col] = cellWidth(truncateCell(col, c.maxColumnWidth)) // Start with header width
 	}
 
 	// Check all data to find max width for each column
 	for _, row := range rows {
 		for _, col := range columns {
 			if val, exists := row[col]; exists {
-				str := c.formatValue(val)
-				if len(str) > widths[col] {
-					widths[col] = len(str)
-				}
+				widths[col] = max(widths[col], cellWidth(c.tableCell(val)))
 			}
 		}
 	}
+	return widths
+}
+
+// tableWidth returns the width of the lines displayTable draws
+func tableWidth(columns []string, widths map[string]int) int {
+	width := 1 // Left border
+	for _, col := range columns {
+		width += widths[col] + 3 // Padding and right border
+	}
+	return width
+}
+
+// displayTable writes rows as a table with box-drawing borders. Cells wider
+// than -max-column-width are truncated; the vertical format shows them whole.
+func (c *Client) displayTable(w io.Writer, columns []string, rows []map[string]interface{}) {
+	if len(rows) == 0 {
+		return
+	}
+
+	// Calculate column widths and determine if column is numeric
+	widths := c.columnWidths(columns, rows)
+	isNumeric := make(map[string]bool)
+	for _, col := range columns {
+		isNumeric[col] = c.isColumnNumeric(col, rows)
+	}
 
 	// Print header
-	fmt.Print("┌")
+	fmt.Fprint(w, "┌")
 	for i, col := range columns {
-		fmt.Print(strings.Repeat("─", widths[col]+2))
+		fmt.Fprint(w, strings.Repeat("─", widths[col]+2))
 		if i < len(columns)-1 {
-			fmt.Print("┬")
+			fmt.Fprint(w, "┬")
 		}
 	}
-	fmt.Println("┐")
+	fmt.Fprintln(w, "┐")
 
-	fmt.Print("│")
+	// Numeric headers are right-aligned like their values
+	fmt.Fprint(w, "│")
 	for _, col := range columns {
-		if isNumeric[col] {
-			fmt.Printf(" %*s │", widths[col], col) // Right-align numeric headers
-		} else {
-			fmt.Printf(" %-*s │", widths[col], col) // Left-align text headers
-		}
+		fmt.Fprintf(w, " %s │", padCell(truncateCell(col, c.maxColumnWidth), widths[col], isNumeric[col]))
 	}
-	fmt.Println()
+	fmt.Fprintln(w)
 
 	// Print separator
-	fmt.Print("├")
+	fmt.Fprint(w, "├")
 	for i, col := range columns {
-		fmt.Print(strings.Repeat("─", widths[col]+2))
+		fmt.Fprint(w, strings.Repeat("─", widths[col]+2))
 		if i < len(columns)-1 {
-			fmt.Print("┼")
+			fmt.Fprint(w, "┼")
 		}
 	}
-	fmt.Println("┤")
+	fmt.Fprintln(w, "┤")
 
-	// Print data rows
+	// Print data rows, right-aligning numeric values
 	for _, row := range rows {
-		fmt.Print("│")
+		fmt.Fprint(w, "│")
 		for _, col := range columns {
 			val := ""
 			if v, exists := row[col]; exists {
-				val = c.formatValue(v)
-			}
-			
-			if isNumeric[col] {
-				fmt.Printf(" %*s │", widths[col], val) // Right-align numeric values
-			} else {
-				fmt.Printf(" %-*s │", widths[col], val) // Left-align text values
+				val = c.tableCell(v)
 			}
+			fmt.Fprintf(w, " %s │", padCell(val, widths[col], isNumeric[col]))
 		}
-		fmt.Println()
+		fmt.Fprintln(w)
 	}
 
 	// Print bottom border
-	fmt.Print("└")
+	fmt.Fprint(w, "└")
 	for i, col := range columns {
-		fmt.Print(strings.Repeat("─", widths[col]+2))
+		fmt.Fprint(w, strings.Repeat("─", widths[col]+2))
 		if i < len(columns)-1 {
-			fmt.Print("┴")
+			fmt.Fprint(w, "┴")
 		}
 	}
-	fmt.Println("┘")
+	fmt.Fprintln(w, "┘")
 
 	// Print row count
-	fmt.Printf("\n(%d rows)\n", len(rows))
+	fmt.Fprintf(w, "\n(%d rows)\n", len(rows))
 }
 
-// displayCSV displays results in CSV format
-func (c *Client) displayCSV(columns []string, rows []map[string]interface{}) {
-	if len(rows) == 0 {
-		return
+// displayVertical writes rows as psql's expanded display does: a header line
+// for each record, then one "column | value" line per column. Further lines
+// of a multi-line value continue under the first.
+func (c *Client) displayVertical(w io.Writer, columns []string, rows []map[string]interface{}) {
+	nameWidth := 0
+	for _, col := range columns {
+		nameWidth = max(nameWidth, cellWidth(col))
 	}
 
-	// Print header
-	for i, col := range columns {
-		if i > 0 {
-			fmt.Print(",")
+	// Split the values into lines up front to size the record headers
+	values := make([][][]string, len(rows))
+	valueWidth := 0
+	for i, row := range rows {
+		values[i] = make([][]string, len(columns))
+		for j, col := range columns {
+			val := ""
+			if v, exists := row[col]; exists {
+				val = c.formatValue(v)
+			}
+			values[i][j] = strings.Split(val, "\n")
+			for _, line := range values[i][j] {
+				valueWidth = max(valueWidth, cellWidth(line))
+			}
 		}
-		// Escape and quote column names if needed
-		fmt.Print(c.escapeCSVField(col))
 	}
-	fmt.Println()
 
-	// Print data rows
-	for _, row := range rows {
+	for i := range rows {
+		header := fmt.Sprintf("-[ RECORD %d ]", i+1)
+		fmt.Fprint(w, header)
+		fmt.Fprintln(w, strings.Repeat("-", max(0, nameWidth+3+valueWidth-len(header))))
+		for j, col := range columns {
+			for k, line := range values[i][j] {
+				name := col
+				if k > 0 {
+					name = ""
+				}
+				fmt.Fprintln(w, strings.TrimRight(padCell(name, nameWidth, false)+" | "+line, " "))
+			}
+		}
+	}
+
+	fmt.Fprintf(w, "\n(%d rows)\n", len(rows))
+}
+
+// pageOutput writes a rendered result to stdout. When the pager is on and
+// the result is taller or wider than the terminal, it is shown through
+// $PAGER (less -S by default, which scrolls sideways instead of wrapping).
+func (c *Client) pageOutput(output []byte) error {
+	width, height := terminalSize(os.Stdout)
+	if !c.pager || !isTerminal(os.Stdin) || !exceedsScreen(output, width, height) {
+		_, err := os.Stdout.Write(output)
+		return err
+	}
+
+	cmd := pagerCommand()
+	cmd.Stdin = bytes.NewReader(output)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		c.log().Debug("Pager not available", "pager", cmd.Path, "error", err)
+		_, err := os.Stdout.Write(output)
+		return err
+	}
+
+	// The exit status only tells how the pager was left, e.g. quitting early
+	if err := cmd.Wait(); err != nil {
+		c.log().Debug("Pager exited", "error", err)
+	}
+	return nil
+}
+
+// pagerCommand returns the command of $PAGER, or less -S if it is not set
+func pagerCommand() *exec.Cmd {
+	pager := strings.Fields(os.Getenv("PAGER"))
+	if len(pager) == 0 {
+		pager = []string{"less", "-S"}
+	}
+	return exec.Command(pager[0], pager[1:]...)
+}
+
+// exceedsScreen reports whether output has more lines than fit on a screen
+// of the given size, leaving a line for the prompt, or a line wider than it.
+// A size of 0 is not a terminal, which nothing exceeds.
+func exceedsScreen(output []byte, width, height int) bool {
+	if width <= 0 || height <= 0 {
+		return false
+	}
+	lines := bytes.Split(bytes.TrimSuffix(output, []byte("\n")), []byte("\n"))
+	if len(lines) >= height {
+		return true
+	}
+	for _, line := range lines {
+		if cellWidth(string(line)) > width {
+			return true
+		}
+	}
+	return false
+}
+
+// displayCSV writes results in CSV format, one line per row as it arrives
+func (c *Client) displayCSV(w io.Writer, result *analysis.Rows) error {
+	var columns []string
+	for result.Next() {
+		row := result.Row()
+		if columns == nil {
+			columns = resultColumns(result, row)
+
+			// Print header
+			for i, col := range columns {
+				if i > 0 {
+					fmt.Fprint(w, ",")
+				}
+				// Escape and quote column names if needed
+				fmt.Fprint(w, c.escapeCSVField(col))
+			}
+			fmt.Fprintln(w)
+		}
+
 		for i, col := range columns {
 			if i > 0 {
-				fmt.Print(",")
+				fmt.Fprint(w, ",")
 			}
 			val := ""
 			if v, exists := row[col]; exists {
 				val = c.formatValue(v)
 			}
-			fmt.Print(c.escapeCSVField(val))
+			fmt.Fprint(w, c.escapeCSVField(val))
 		}
-		fmt.Println()
+		fmt.Fprintln(w)
+	}
+	if err := result.Err(); err != nil {
+		return err
+	}
+
+	if columns == nil {
+		fmt.Fprintln(w, "No results found.")
 	}
+	return nil
 }
 
-// displayJSON displays results in JSON format
-func (c *Client) displayJSON(rows []map[string]interface{}) {
-	if len(rows) == 0 {
-		fmt.Println("[]")
-		return
+// displayJSON writes results as a pretty-printed JSON array, one element per
+// row as it arrives
+func (c *Client) displayJSON(w io.Writer, result *analysis.Rows) error {
+	count := 0
+	for result.Next() {
+		rowBytes, err := json.MarshalIndent(result.Row(), "  ", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to encode result row: %v", err)
+		}
+		if count == 0 {
+			fmt.Fprint(w, "[\n  ")
+		} else {
+			fmt.Fprint(w, ",\n  ")
+		}
+		w.Write(rowBytes)
+		count++
+	}
+	if err := result.Err(); err != nil {
+		return err
+	}
+
+	if count == 0 {
+		fmt.Fprintln(w, "No results found.")
+		return nil
+	}
+	fmt.Fprintln(w, "\n]")
+	return nil
+}
+
+// displayJSONL writes results as JSON Lines, one object per row as it arrives
+func (c *Client) displayJSONL(w io.Writer, result *analysis.Rows) error {
+	encoder := json.NewEncoder(w)
+	count := 0
+	for result.Next() {
+		if err := encoder.Encode(result.Row()); err != nil {
+			return fmt.Errorf("failed to encode result row: %v", err)
+		}
+		count++
+	}
+	if err := result.Err(); err != nil {
+		return err
+	}
+
+	if count == 0 {
+		fmt.Fprintln(w, "No results found.")
+	}
+	return nil
+}
+
+// writeParquet writes a query result to the -o file as Parquet, one row
+// group at a time as the result streams in. The file is written under a
+// ".partial" name and only replaces the -o file once it is complete.
+func (c *Client) writeParquet(result *analysis.Rows) error {
+	// Decimals must keep every digit
+	result.UseNumber()
+
+	var first map[string]interface{}
+	if result.Next() {
+		first = result.Row()
+	} else if err := result.Err(); err != nil {
+		return err
+	}
+	names := resultColumns(result, first)
+	if len(names) == 0 {
+		fmt.Println("No results found.")
+		return nil
 	}
 
-	// Pretty print JSON
-	jsonBytes, err := json.MarshalIndent(rows, "", "  ")
+	file, err := os.OpenFile(c.output+".partial", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
 	if err != nil {
-		// Fallback to line-by-line output if pretty printing fails
-		fmt.Println("[")
-		for i, row := range rows {
-			if i > 0 {
-				fmt.Print(",")
+		return fmt.Errorf("failed to create output file: %v", err)
+	}
+	output := &resultFile{file: file, path: c.output}
+	out := bufio.NewWriter(file)
+
+	fields := parquetFields(result.Columns, names, first)
+	count := 0
+	err = func() error {
+		w := parquetgo.NewGenericWriter[any](out, parquetgo.NewSchema("schema", fields), parquetgo.Compression(&parquetgo.Gzip))
+		rows := []parquetgo.Row{make(parquetgo.Row, len(fields))}
+		for row := first; row != nil; row = nextRow(result) {
+			for i, field := range fields {
+				value, err := parquetValue(field, row[field.name])
+				if err != nil {
+					return fmt.Errorf("failed to write row %d: column %s: %v", count+1, field.name, err)
+				}
+				// Every column is optional: definition level 1 marks a value
+				level := 1
+				if value.IsNull() {
+					level = 0
+				}
+				rows[0][i] = value.Level(0, level, i)
+			}
+			if _, err := w.WriteRows(rows); err != nil {
+				return fmt.Errorf("failed to write row %d: %v", count+1, err)
+			}
+			count++
+			if count%parquetRowGroupSize == 0 {
+				if err := w.Flush(); err != nil {
+					return err
+				}
 			}
-			rowBytes, _ := json.Marshal(row)
-			fmt.Printf("  %s\n", string(rowBytes))
 		}
-		fmt.Println("]")
-		return
+		if err := result.Err(); err != nil {
+			return err
+		}
+		if err := w.Close(); err != nil {
+			return err
+		}
+		return out.Flush()
+	}()
+	if err != nil {
+		output.discard()
+		return err
+	}
+	if err := output.commit(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Wrote %d rows to %s\n", count, c.output)
+	return nil
+}
+
+// nextRow advances result and returns its row, or nil at the end
+func nextRow(result *analysis.Rows) map[string]interface{} {
+	if !result.Next() {
+		return nil
+	}
+	return result.Row()
+}
+
+// fileColumns returns the column info of a result with the given column
+// names, for the file formats. Columns without column info get their type
+// from the value in the first row.
+func fileColumns(info []analysis.ColumnInfo, names []string, first map[string]interface{}) []analysis.ColumnInfo {
+	byName := make(map[string]analysis.ColumnInfo)
+	for _, col := range info {
+		byName[col.Name] = col
+	}
+
+	var columns []analysis.ColumnInfo
+	for _, name := range names {
+		col, ok := byName[name]
+		if !ok {
+			col = analysis.ColumnInfo{Name: name, Type: valueType(first[name])}
+		}
+		columns = append(columns, col)
+	}
+	return columns
+}
+
+// parquetRowGroupSize is the number of rows per row group of a Parquet file.
+// The writer keeps a row group in memory until it is flushed.
+const parquetRowGroupSize = 100000
+
+// parquetField is a column of a Parquet file
+type parquetField struct {
+	parquetgo.Node
+	name string
+}
+
+func (f parquetField) Name() string { return f.name }
+
+// Value returns the value of the column in a row map, like the fields of a
+// parquetgo.Group
+func (f parquetField) Value(base reflect.Value) reflect.Value {
+	if base.Kind() == reflect.Interface {
+		if base.IsNil() {
+			return reflect.ValueOf(nil)
+		}
+		base = base.Elem()
+	}
+	return base.MapIndex(reflect.ValueOf(f.name))
+}
+
+// parquetSchema is the root group of a Parquet file. Unlike parquetgo.Group,
+// which sorts its fields by name, it keeps the columns in result order.
+type parquetSchema []parquetField
+
+func (s parquetSchema) ID() int                     { return 0 }
+func (s parquetSchema) String() string              { return parquetgo.NewSchema("schema", s).String() }
+func (s parquetSchema) Type() parquetgo.Type        { return parquetgo.Group{}.Type() }
+func (s parquetSchema) Optional() bool              { return false }
+func (s parquetSchema) Repeated() bool              { return false }
+func (s parquetSchema) Required() bool              { return true }
+func (s parquetSchema) Leaf() bool                  { return false }
+func (s parquetSchema) Encoding() encoding.Encoding { return nil }
+func (s parquetSchema) Compression() compress.Codec { return nil }
+
+func (s parquetSchema) Fields() []parquetgo.Field {
+	fields := make([]parquetgo.Field, len(s))
+	for i, field := range s {
+		fields[i] = field
+	}
+	return fields
+}
+
+func (s parquetSchema) GoType() reflect.Type {
+	group := make(parquetgo.Group, len(s))
+	for _, field := range s {
+		group[field.name] = field.Node
+	}
+	return group.GoType()
+}
+
+// parquetFields returns the Parquet schema of a result with the given column
+// names
+func parquetFields(info []analysis.ColumnInfo, names []string, first map[string]interface{}) parquetSchema {
+	var fields parquetSchema
+	for _, col := range fileColumns(info, names, first) {
+		fields = append(fields, parquetField{Node: parquetgo.Optional(parquetColumn(col)), name: col.Name})
+	}
+	return fields
+}
+
+// parquetColumn maps a result column to a Parquet column type by its
+// database type, e.g. NUMBER(10,2) to a decimal, or else by its JSON type.
+// VARIANT, OBJECT and ARRAY columns have no fixed structure and are written
+// as JSON text.
+func parquetColumn(col analysis.ColumnInfo) parquetgo.Node {
+	base, params := splitDatabaseType(col.DatabaseType)
+	switch base {
+	case "NUMBER", "DECIMAL", "NUMERIC":
+		// Without a declared precision the values may have any scale
+		if precision, scale, ok := parseDecimalParams(params); ok {
+			return parquetgo.Decimal(scale, precision, decimalType(precision))
+		}
+	case "INT", "INTEGER", "BIGINT", "SMALLINT", "TINYINT", "BYTEINT":
+		return parquetgo.Int(64)
+	case "FLOAT", "FLOAT4", "FLOAT8", "DOUBLE", "DOUBLE PRECISION", "REAL":
+		return parquetgo.Leaf(parquetgo.DoubleType)
+	case "BOOLEAN":
+		return parquetgo.Leaf(parquetgo.BooleanType)
+	case "DATE":
+		return parquetgo.Date()
+	case "TIMESTAMP", "TIMESTAMP_NTZ", "DATETIME":
+		return parquetgo.Leaf(localTimestampType{parquetgo.Timestamp(parquetgo.Microsecond).Type()})
+	case "TIMESTAMP_LTZ", "TIMESTAMP_TZ":
+		return parquetgo.Timestamp(parquetgo.Microsecond)
+	case "VARIANT", "OBJECT", "ARRAY":
+		return parquetgo.JSON()
+	}
+
+	switch col.Type {
+	case "number":
+		return parquetgo.Leaf(parquetgo.DoubleType)
+	case "boolean":
+		return parquetgo.Leaf(parquetgo.BooleanType)
+	case "object":
+		return parquetgo.JSON()
+	}
+	return parquetgo.String()
+}
+
+// localTimestampType is the type of date-times without a time zone, which
+// keep the date and time as written. parquetgo.Timestamp always marks its
+// values as instants in UTC.
+type localTimestampType struct {
+	parquetgo.Type
+}
+
+func (t localTimestampType) String() string { return t.LogicalType().Timestamp.String() }
+
+func (t localTimestampType) LogicalType() *format.LogicalType {
+	return &format.LogicalType{Timestamp: &format.TimestampType{
+		IsAdjustedToUTC: false,
+		Unit:            format.TimeUnit{Micros: &format.MicroSeconds{}},
+	}}
+}
+
+// decimalType returns the smallest physical type that holds every unscaled
+// decimal of the given precision
+func decimalType(precision int) parquetgo.Type {
+	switch {
+	case precision <= 9:
+		return parquetgo.Int32Type
+	case precision <= 18:
+		return parquetgo.Int64Type
+	}
+	limit := pow10(precision)
+	for n := 1; ; n++ {
+		if new(big.Int).Lsh(big.NewInt(1), uint(8*n-1)).Cmp(limit) >= 0 {
+			return parquetgo.FixedLenByteArrayType(n)
+		}
+	}
+}
+
+// parquetTimeLayouts are the text forms accepted for dates and timestamps.
+// Layouts without a time zone read as UTC.
+var parquetTimeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999 Z07:00",
+	"2006-01-02 15:04:05.999999999 -0700",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02",
+}
+
+// parquetValue converts a result value to a value of a Parquet column type.
+// nil is a null.
+func parquetValue(node parquetgo.Node, v interface{}) (parquetgo.Value, error) {
+	if v == nil {
+		return parquetgo.NullValue(), nil
+	}
+
+	typ := node.Type()
+	logical := typ.LogicalType()
+	switch {
+	case logical == nil:
+	case logical.Decimal != nil:
+		n, err := toDecimal(v, int(logical.Decimal.Precision), int(logical.Decimal.Scale))
+		if err != nil {
+			return parquetgo.Value{}, err
+		}
+		switch typ.Kind() {
+		case parquetgo.Int32:
+			return parquetgo.Int32Value(int32(n.Int64())), nil
+		case parquetgo.Int64:
+			return parquetgo.Int64Value(n.Int64()), nil
+		}
+		// Big-endian two's complement
+		if n.Sign() < 0 {
+			n.Add(n, new(big.Int).Lsh(big.NewInt(1), uint(8*typ.Length())))
+		}
+		return parquetgo.FixedLenByteArrayValue(n.FillBytes(make([]byte, typ.Length()))), nil
+	case logical.Date != nil:
+		t, err := toTime(v, "date")
+		if err != nil {
+			return parquetgo.Value{}, err
+		}
+		days := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC).Unix() / 86400
+		return parquetgo.Int32Value(int32(days)), nil
+	case logical.Timestamp != nil:
+		t, err := toTime(v, "timestamp")
+		if err != nil {
+			return parquetgo.Value{}, err
+		}
+		if !logical.Timestamp.IsAdjustedToUTC {
+			// Keep the date and time as written, whatever the zone
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+		}
+		return parquetgo.Int64Value(t.UnixMicro()), nil
+	case logical.Json != nil:
+		doc, err := json.Marshal(v)
+		if err != nil {
+			return parquetgo.Value{}, err
+		}
+		return parquetgo.ByteArrayValue(doc), nil
+	case logical.UTF8 != nil:
+		text, ok := v.(string)
+		if !ok {
+			// Numbers and booleans in a text column keep their JSON form
+			doc, err := json.Marshal(v)
+			if err != nil {
+				return parquetgo.Value{}, err
+			}
+			text = string(doc)
+		}
+		return parquetgo.ByteArrayValue([]byte(text)), nil
+	}
+
+	switch typ.Kind() {
+	case parquetgo.Boolean:
+		b, err := toBool(v)
+		return parquetgo.BooleanValue(b), err
+	case parquetgo.Int64:
+		n, err := toInt64(v)
+		return parquetgo.Int64Value(n), err
+	case parquetgo.Double:
+		f, err := toFloat64(v)
+		return parquetgo.DoubleValue(f), err
 	}
-	fmt.Println(string(jsonBytes))
+	return parquetgo.Value{}, fmt.Errorf("unsupported Parquet type %s", typ)
+}
+
+// invalidValue reports a value that cannot be stored as the given type
+func invalidValue(v interface{}, typ string) error {
+	return fmt.Errorf("%q is not a valid %s", fmt.Sprint(v), typ)
+}
+
+func toBool(v interface{}) (bool, error) {
+	switch v := v.(type) {
+	case bool:
+		return v, nil
+	case string:
+		if b, err := strconv.ParseBool(v); err == nil {
+			return b, nil
+		}
+	}
+	return false, invalidValue(v, "boolean")
+}
+
+func toInt64(v interface{}) (int64, error) {
+	switch v := v.(type) {
+	case float64:
+		if v == math.Trunc(v) && v >= math.MinInt64 && v < math.MaxInt64 {
+			return int64(v), nil
+		}
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n, nil
+		}
+	case string:
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return n, nil
+		}
+	}
+	return 0, invalidValue(v, "integer")
+}
+
+func toFloat64(v interface{}) (float64, error) {
+	switch v := v.(type) {
+	case float64:
+		return v, nil
+	case json.Number:
+		if f, err := v.Float64(); err == nil {
+			return f, nil
+		}
+	case string:
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			return f, nil
+		}
+	}
+	return 0, invalidValue(v, "double")
+}
+
+// toDecimal returns the unscaled value of v, i.e. v times 10^scale, which
+// must be an integer of at most precision digits.
+func toDecimal(v interface{}, precision, scale int) (*big.Int, error) {
+	var text string
+	switch v := v.(type) {
+	case json.Number:
+		text = v.String()
+	case string:
+		text = v
+	case float64:
+		// The shortest decimal that reads back as v, not its binary expansion
+		text = strconv.FormatFloat(v, 'g', -1, 64)
+	}
+
+	r, ok := new(big.Rat).SetString(strings.TrimSpace(text))
+	if text == "" || !ok {
+		return nil, invalidValue(v, "decimal")
+	}
+	r.Mul(r, new(big.Rat).SetInt(pow10(scale)))
+	if !r.IsInt() {
+		return nil, fmt.Errorf("%s has more than %d digits after the decimal point", text, scale)
+	}
+	n := new(big.Int).Set(r.Num())
+	if new(big.Int).Abs(n).Cmp(pow10(precision)) >= 0 {
+		return nil, fmt.Errorf("%s does not fit DECIMAL(%d,%d)", text, precision, scale)
+	}
+	return n, nil
+}
+
+func pow10(n int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
+
+// toTime converts a text form in parquetTimeLayouts or a number of seconds
+// since the Unix epoch to a date or timestamp
+func toTime(v interface{}, typ string) (time.Time, error) {
+	var text string
+	switch v := v.(type) {
+	case string:
+		text = strings.TrimSpace(v)
+		for _, layout := range parquetTimeLayouts {
+			if t, err := time.Parse(layout, text); err == nil {
+				return t, nil
+			}
+		}
+	case json.Number:
+		text = v.String()
+	case float64:
+		text = strconv.FormatFloat(v, 'f', -1, 64)
+	}
+
+	// Seconds since the epoch, with up to nanosecond precision
+	if r, ok := new(big.Rat).SetString(text); ok && text != "" {
+		nanos := new(big.Int).Quo(new(big.Int).Mul(r.Num(), big.NewInt(1e9)), r.Denom())
+		if nanos.IsInt64() {
+			return time.Unix(0, nanos.Int64()).UTC(), nil
+		}
+	}
+	return time.Time{}, invalidValue(v, typ)
+}
+
+// maxDecimalPrecision is the largest precision of a NUMBER(p,s) column
+const maxDecimalPrecision = 38
+
+// splitDatabaseType splits a database type such as "number(38, 2)" into its
+// upper-case name and the parameters after the parenthesis, "38, 2)"
+func splitDatabaseType(databaseType string) (base, params string) {
+	base, params, _ = strings.Cut(strings.ToUpper(strings.TrimSpace(databaseType)), "(")
+	return strings.TrimSpace(base), params
+}
+
+// parseDecimalParams parses the "38,2)" of a type like NUMBER(38,2). The
+// scale defaults to 0.
+func parseDecimalParams(params string) (precision, scale int, ok bool) {
+	params, found := strings.CutSuffix(strings.TrimSpace(params), ")")
+	if !found {
+		return 0, 0, false
+	}
+	precisionText, scaleText, hasScale := strings.Cut(params, ",")
+	precision, err := strconv.Atoi(strings.TrimSpace(precisionText))
+	if err != nil || precision < 1 || precision > maxDecimalPrecision {
+		return 0, 0, false
+	}
+	if hasScale {
+		if scale, err = strconv.Atoi(strings.TrimSpace(scaleText)); err != nil || scale < 0 || scale > precision {
+			return 0, 0, false
+		}
+	}
+	return precision, scale, true
+}
+
+// valueType names the JSON type of a result value, like ColumnInfo.Type
+func valueType(value interface{}) string {
+	switch value.(type) {
+	case float64, json.Number:
+		return "number"
+	case bool:
+		return "boolean"
+	case map[string]interface{}, []interface{}:
+		return "object"
+	}
+	return "string"
+}
+
+// isFileFormat reports whether format writes the -o file instead of stdout
+func isFileFormat(format string) bool {
+	return format == "parquet" || format == "sqlite"
+}
+
+// validIfExists reports whether mode is a -if-exists mode
+func validIfExists(mode string) bool {
+	return mode == "fail" || mode == "append" || mode == "replace"
+}
+
+// writeSQLite writes a query result to a table of the SQLite database at
+// path, which is created if needed. The rows are inserted with a prepared
+// statement within one transaction, so a failure leaves the database as it
+// was. ifExists says what happens to an existing table: fail, append the
+// rows, or replace the table.
+func writeSQLite(result *analysis.Rows, path, table, ifExists string) error {
+	// Decimals must keep every digit
+	result.UseNumber()
+
+	first := nextRow(result)
+	if err := result.Err(); err != nil {
+		return err
+	}
+	names := resultColumns(result, first)
+	if len(names) == 0 {
+		fmt.Println("No results found.")
+		return nil
+	}
+	columns := sqliteColumns(result.Columns, names, first)
+
+	tableError := func(err error) error {
+		message := err.Error()
+		if ifExists == "fail" && strings.Contains(message, "already exists") {
+			message += " (use append or replace mode)"
+		}
+		return fmt.Errorf("failed to write table %s in %s: %s", table, path, message)
+	}
+
+	db, err := openSQLite(path)
+	if err != nil {
+		return tableError(err)
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return tableError(err)
+	}
+	// Without a Commit, nothing of this write is kept
+	defer tx.Rollback()
+
+	insert, err := createSQLiteTable(tx, columns, table, ifExists)
+	if err != nil {
+		return tableError(err)
+	}
+	defer insert.Close()
+
+	count := 0
+	values := make([]interface{}, len(columns))
+	for row := first; row != nil; row = nextRow(result) {
+		for i, col := range columns {
+			values[i] = sqliteValue(row[col.name], col.affinity)
+		}
+		if _, err := insert.Exec(values...); err != nil {
+			return tableError(err)
+		}
+		count++
+	}
+	if err := result.Err(); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return tableError(err)
+	}
+
+	fmt.Fprintf(os.Stderr, "Wrote %d rows to table %s in %s\n", count, table, path)
+	return nil
+}
+
+// openSQLite opens the SQLite database at path, creating it if needed.
+// Transactions take the write lock when they begin.
+func openSQLite(path string) (*sql.DB, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	// A URI keeps characters such as '?' in path from being read as options
+	dsn := &url.URL{Scheme: "file", Path: filepath.ToSlash(abs), RawQuery: "_txlock=immediate"}
+	return sql.Open("sqlite", dsn.String())
+}
+
+// createSQLiteTable creates the table for columns as ifExists says and
+// returns the statement that inserts a row into it.
+func createSQLiteTable(tx *sql.Tx, columns []sqliteColumn, table, ifExists string) (*sql.Stmt, error) {
+	var definitions, names, params []string
+	for _, col := range columns {
+		definitions = append(definitions, quoteSQLiteIdentifier(col.name)+" "+col.affinity)
+		names = append(names, quoteSQLiteIdentifier(col.name))
+		params = append(params, "?")
+	}
+
+	create := "CREATE TABLE "
+	switch ifExists {
+	case "replace":
+		if _, err := tx.Exec("DROP TABLE IF EXISTS " + quoteSQLiteIdentifier(table)); err != nil {
+			return nil, err
+		}
+	case "append":
+		create = "CREATE TABLE IF NOT EXISTS "
+	}
+	if _, err := tx.Exec(create + quoteSQLiteIdentifier(table) + " (" + strings.Join(definitions, ", ") + ")"); err != nil {
+		return nil, err
+	}
+
+	return tx.Prepare(fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", quoteSQLiteIdentifier(table), strings.Join(names, ", "), strings.Join(params, ", ")))
+}
+
+// sqliteColumn is a column of a SQLite table written from a result
+type sqliteColumn struct {
+	name     string
+	affinity string // Declared type: INTEGER, REAL, TEXT, BOOLEAN, DATE or TIMESTAMP
+}
+
+// sqliteColumns returns the SQLite columns of a result with the given column
+// names
+func sqliteColumns(info []analysis.ColumnInfo, names []string, first map[string]interface{}) []sqliteColumn {
+	var columns []sqliteColumn
+	for _, col := range fileColumns(info, names, first) {
+		columns = append(columns, sqliteColumn{name: col.Name, affinity: sqliteColumnType(col)})
+	}
+	return columns
+}
+
+// sqliteColumnType returns the SQLite type of a result column by its
+// database type, or else by its JSON type. SQLite has no exact decimals, so
+// decimals with a fraction are stored as TEXT, which keeps every digit and
+// which SQL arithmetic still reads as numbers.
+func sqliteColumnType(col analysis.ColumnInfo) string {
+	base, params := splitDatabaseType(col.DatabaseType)
+	switch base {
+	case "NUMBER", "DECIMAL", "NUMERIC":
+		// Without a declared precision the values may have any scale
+		if _, scale, ok := parseDecimalParams(params); ok {
+			if scale == 0 {
+				return "INTEGER"
+			}
+			return "TEXT"
+		}
+	case "INT", "INTEGER", "BIGINT", "SMALLINT", "TINYINT", "BYTEINT":
+		return "INTEGER"
+	case "FLOAT", "FLOAT4", "FLOAT8", "DOUBLE", "DOUBLE PRECISION", "REAL":
+		return "REAL"
+	case "BOOLEAN":
+		return "BOOLEAN"
+	case "DATE":
+		return "DATE"
+	case "TIMESTAMP", "TIMESTAMP_NTZ", "DATETIME", "TIMESTAMP_LTZ", "TIMESTAMP_TZ":
+		return "TIMESTAMP"
+	case "VARIANT", "OBJECT", "ARRAY":
+		return "TEXT" // JSON documents
+	}
+
+	switch col.Type {
+	case "number":
+		return "REAL"
+	case "boolean":
+		return "BOOLEAN"
+	}
+	return "TEXT" // Strings and JSON documents
+}
+
+// sqliteValue returns a result value as the parameter to insert into a
+// column of the given affinity. Numbers are bound as integers or doubles
+// only in columns of that type, and as their exact text otherwise; nested
+// objects and arrays are stored as JSON text.
+func sqliteValue(value interface{}, affinity string) interface{} {
+	switch v := value.(type) {
+	case nil, bool, string:
+		return v
+	case json.Number:
+		switch affinity {
+		case "INTEGER":
+			if n, err := v.Int64(); err == nil {
+				return n
+			}
+		case "REAL":
+			if f, err := v.Float64(); err == nil {
+				return f
+			}
+		}
+		return v.String()
+	}
+	encoded, _ := json.Marshal(value)
+	return string(encoded)
+}
+
+// quoteSQLiteIdentifier quotes a table or column name for SQLite
+func quoteSQLiteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
 }
 
 // escapeCSVField escapes and quotes a CSV field if necessary
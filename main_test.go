@@ -1,17 +1,27 @@
 package main
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/c-bata/go-prompt"
+	parquetgo "github.com/parquet-go/parquet-go"
+
+	"soraql/analysis"
+	"soraql/analysis/analysistest"
 )
 
 
@@ -25,190 +35,6 @@ func TestReadFromStdin(t *testing.T) {
 	})
 }
 
-func TestClient_MakeRequest(t *testing.T) {
-	// Create a test server
-	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Check headers
-		if r.Header.Get("x-soracom-api-key") != "test-api-key" {
-			t.Errorf("Missing or incorrect x-soracom-api-key header")
-		}
-		if r.Header.Get("x-soracom-token") != "test-token" {
-			t.Errorf("Missing or incorrect x-soracom-token header")
-		}
-		if r.Header.Get("x-test-header") != "test-value" {
-			t.Errorf("Missing or incorrect x-test-header")
-		}
-
-		// Return test response
-		response := map[string]string{"status": "success"}
-		json.NewEncoder(w).Encode(response)
-	}))
-	defer server.Close()
-
-	client := &Client{
-		httpClient:    &http.Client{},
-		apiKey:        "test-api-key",
-		token:         "test-token",
-		customHeaders: map[string]string{"x-test-header": "test-value"},
-		debug:         false,
-	}
-
-	body, err := client.makeRequest("GET", server.URL, nil)
-	if err != nil {
-		t.Errorf("makeRequest() error = %v", err)
-		return
-	}
-
-	var response map[string]string
-	if err := json.Unmarshal(body, &response); err != nil {
-		t.Errorf("Failed to unmarshal response: %v", err)
-		return
-	}
-
-	if response["status"] != "success" {
-		t.Errorf("makeRequest() response status = %v, want success", response["status"])
-	}
-}
-
-func TestClient_MakeRequestWithPayload(t *testing.T) {
-	// Create a test server that expects JSON payload
-	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != "POST" {
-			t.Errorf("Expected POST method, got %s", r.Method)
-		}
-
-		if r.Header.Get("Content-Type") != "application/json" {
-			t.Errorf("Expected Content-Type application/json, got %s", r.Header.Get("Content-Type"))
-		}
-
-		// Read and verify payload
-		body, err := io.ReadAll(r.Body)
-		if err != nil {
-			t.Errorf("Failed to read request body: %v", err)
-		}
-
-		var payload map[string]string
-		if err := json.Unmarshal(body, &payload); err != nil {
-			t.Errorf("Failed to unmarshal request payload: %v", err)
-		}
-
-		if payload["sql"] != "SELECT 1" {
-			t.Errorf("Expected SQL payload 'SELECT 1', got %s", payload["sql"])
-		}
-
-		// Return test response
-		response := map[string]string{"queryId": "test-query-id"}
-		json.NewEncoder(w).Encode(response)
-	}))
-	defer server.Close()
-
-	client := &Client{
-		httpClient:    &http.Client{},
-		apiKey:        "test-api-key",
-		token:         "test-token",
-		customHeaders: map[string]string{"x-test-header": "test-value"},
-		debug:         false,
-	}
-
-	payload := map[string]string{"sql": "SELECT 1"}
-	body, err := client.makeRequest("POST", server.URL, payload)
-	if err != nil {
-		t.Errorf("makeRequest() error = %v", err)
-		return
-	}
-
-	var response map[string]string
-	if err := json.Unmarshal(body, &response); err != nil {
-		t.Errorf("Failed to unmarshal response: %v", err)
-		return
-	}
-
-	if response["queryId"] != "test-query-id" {
-		t.Errorf("makeRequest() response queryId = %v, want test-query-id", response["queryId"])
-	}
-}
-
-func TestQueryResponseParsing(t *testing.T) {
-	jsonResponse := `{"queryId": "test-123", "status": "submitted"}`
-
-	var queryResp QueryResponse
-	err := json.Unmarshal([]byte(jsonResponse), &queryResp)
-	if err != nil {
-		t.Errorf("Failed to unmarshal QueryResponse: %v", err)
-	}
-
-	if queryResp.QueryId != "test-123" {
-		t.Errorf("QueryResponse.QueryId = %v, want test-123", queryResp.QueryId)
-	}
-}
-
-func TestQueryStatusResponseParsing(t *testing.T) {
-	jsonResponse := `{"status": "COMPLETED", "url": "https://example.com/result.jsonl.gz"}`
-
-	var statusResp QueryStatusResponse
-	err := json.Unmarshal([]byte(jsonResponse), &statusResp)
-	if err != nil {
-		t.Errorf("Failed to unmarshal QueryStatusResponse: %v", err)
-	}
-
-	if statusResp.Status != "COMPLETED" {
-		t.Errorf("QueryStatusResponse.Status = %v, want COMPLETED", statusResp.Status)
-	}
-
-	if statusResp.URL != "https://example.com/result.jsonl.gz" {
-		t.Errorf("QueryStatusResponse.URL = %v, want https://example.com/result.jsonl.gz", statusResp.URL)
-	}
-}
-
-func TestAuthResponseParsing(t *testing.T) {
-	jsonResponse := `{"apiKey": "test-api-key", "token": "test-token"}`
-
-	var authResp AuthResponse
-	err := json.Unmarshal([]byte(jsonResponse), &authResp)
-	if err != nil {
-		t.Errorf("Failed to unmarshal AuthResponse: %v", err)
-	}
-
-	if authResp.ApiKey != "test-api-key" {
-		t.Errorf("AuthResponse.ApiKey = %v, want test-api-key", authResp.ApiKey)
-	}
-
-	if authResp.Token != "test-token" {
-		t.Errorf("AuthResponse.Token = %v, want test-token", authResp.Token)
-	}
-}
-
-func TestConfigParsing(t *testing.T) {
-	jsonConfig := `{
-		"email": "test@example.com",
-		"password": "testpass",
-		"authKeyId": "key123",
-		"authKey": "secret456"
-	}`
-
-	var config Config
-	err := json.Unmarshal([]byte(jsonConfig), &config)
-	if err != nil {
-		t.Errorf("Failed to unmarshal Config: %v", err)
-	}
-
-	if config.Email != "test@example.com" {
-		t.Errorf("Config.Email = %v, want test@example.com", config.Email)
-	}
-
-	if config.Password != "testpass" {
-		t.Errorf("Config.Password = %v, want testpass", config.Password)
-	}
-
-	if config.AuthKeyId != "key123" {
-		t.Errorf("Config.AuthKeyId = %v, want key123", config.AuthKeyId)
-	}
-
-	if config.AuthKey != "secret456" {
-		t.Errorf("Config.AuthKey = %v, want secret456", config.AuthKey)
-	}
-}
-
 // Test helper function to simulate stdin input
 func simulateStdinInput(input string, fn func() string) string {
 	// Create a pipe
@@ -257,7 +83,7 @@ func TestStdinInputSimulation(t *testing.T) {
 	})
 }
 
-func TestClient_DisplayJSONFile(t *testing.T) {
+func TestClient_DisplayRows(t *testing.T) {
 	// Create a temporary JSON file
 	tmpFile, err := os.CreateTemp("", "test*.jsonl")
 	if err != nil {
@@ -274,19 +100,26 @@ func TestClient_DisplayJSONFile(t *testing.T) {
 	}
 	tmpFile.Close()
 
+	file, err := os.Open(tmpFile.Name())
+	if err != nil {
+		t.Fatalf("Failed to open temp file: %v", err)
+	}
+	rows := analysis.NewRows(file, []analysis.ColumnInfo{})
+	defer rows.Close()
+
 	// Capture stdout
 	oldStdout := os.Stdout
 	r, w, _ := os.Pipe()
 	os.Stdout = w
 
 	client := &Client{debug: false}
-	err = client.displayJSONFile(tmpFile.Name(), []ColumnInfo{})
+	err = client.displayRows(rows)
 
 	w.Close()
 	os.Stdout = oldStdout
 
 	if err != nil {
-		t.Errorf("displayJSONFile() error = %v", err)
+		t.Errorf("displayRows() error = %v", err)
 	}
 
 	// Read captured output
@@ -295,16 +128,16 @@ func TestClient_DisplayJSONFile(t *testing.T) {
 
 	// Check for table format elements
 	if !strings.Contains(outputStr, "COUNT(*)") {
-		t.Errorf("displayJSONFile() output should contain COUNT(*), got: %s", outputStr)
+		t.Errorf("displayRows() output should contain COUNT(*), got: %s", outputStr)
 	}
 	if !strings.Contains(outputStr, "123") {
-		t.Errorf("displayJSONFile() output should contain 123, got: %s", outputStr)
+		t.Errorf("displayRows() output should contain 123, got: %s", outputStr)
 	}
 	if !strings.Contains(outputStr, "┌") || !strings.Contains(outputStr, "┐") {
-		t.Errorf("displayJSONFile() output should contain table borders, got: %s", outputStr)
+		t.Errorf("displayRows() output should contain table borders, got: %s", outputStr)
 	}
 	if !strings.Contains(outputStr, "rows)") {
-		t.Errorf("displayJSONFile() output should contain row count, got: %s", outputStr)
+		t.Errorf("displayRows() output should contain row count, got: %s", outputStr)
 	}
 }
 
@@ -363,248 +196,6 @@ func TestIsColumnNumeric(t *testing.T) {
 	}
 }
 
-func TestExtractTableNames(t *testing.T) {
-	client := &Client{}
-	
-	// Test schema with tables array
-	schema1 := map[string]interface{}{
-		"tables": []interface{}{
-			map[string]interface{}{"name": "SIM_SNAPSHOTS"},
-			map[string]interface{}{"name": "CELL_TOWERS"},
-		},
-	}
-	
-	tables1 := client.extractTableNames(schema1)
-	if len(tables1) != 2 {
-		t.Errorf("Expected 2 tables, got %d", len(tables1))
-	}
-	
-	expectedTables := []string{"CELL_TOWERS", "SIM_SNAPSHOTS"} // Should be sorted
-	for i, table := range expectedTables {
-		if i < len(tables1) && tables1[i] != table {
-			t.Errorf("Expected table %s at index %d, got %s", table, i, tables1[i])
-		}
-	}
-	
-	// Test schema with nested structure
-	schema2 := map[string]interface{}{
-		"schemas": map[string]interface{}{
-			"default": map[string]interface{}{
-				"tables": map[string]interface{}{
-					"TABLE1": map[string]interface{}{},
-					"TABLE2": map[string]interface{}{},
-				},
-			},
-		},
-	}
-	
-	tables2 := client.extractTableNames(schema2)
-	if len(tables2) != 2 {
-		t.Errorf("Expected 2 tables from nested schema, got %d", len(tables2))
-	}
-}
-
-func TestExtractColumns(t *testing.T) {
-	client := &Client{}
-	
-	// Test columnInfo (Soracom format)
-	tableData0 := map[string]interface{}{
-		"columnInfo": []interface{}{
-			map[string]interface{}{
-				"name":         "MCC",
-				"type":         "string",
-				"databaseType": "TEXT",
-			},
-			map[string]interface{}{
-				"name":         "MNC",
-				"type":         "string",
-				"databaseType": "TEXT",
-			},
-		},
-	}
-	
-	columns0 := client.extractColumns(tableData0)
-	if len(columns0) != 2 {
-		t.Errorf("Expected 2 columns from columnInfo, got %d", len(columns0))
-	}
-	
-	if columns0[0].Name != "MCC" || columns0[0].Type != "TEXT" {
-		t.Errorf("Expected column {MCC, TEXT}, got {%s, %s}", columns0[0].Name, columns0[0].Type)
-	}
-	
-	// Test columns as array
-	tableData1 := map[string]interface{}{
-		"columns": []interface{}{
-			map[string]interface{}{
-				"name": "id",
-				"type": "INTEGER",
-			},
-			map[string]interface{}{
-				"name": "name",
-				"type": "VARCHAR",
-			},
-		},
-	}
-	
-	columns1 := client.extractColumns(tableData1)
-	if len(columns1) != 2 {
-		t.Errorf("Expected 2 columns, got %d", len(columns1))
-	}
-	
-	if columns1[0].Name != "id" || columns1[0].Type != "INTEGER" {
-		t.Errorf("Expected column {id, INTEGER}, got {%s, %s}", columns1[0].Name, columns1[0].Type)
-	}
-	
-	// Test columns as map
-	tableData2 := map[string]interface{}{
-		"columns": map[string]interface{}{
-			"user_id": map[string]interface{}{
-				"type": "BIGINT",
-			},
-			"email": map[string]interface{}{
-				"type": "STRING",
-			},
-		},
-	}
-	
-	columns2 := client.extractColumns(tableData2)
-	if len(columns2) != 2 {
-		t.Errorf("Expected 2 columns from map structure, got %d", len(columns2))
-	}
-}
-
-func TestExtractAllTableSchemas(t *testing.T) {
-	client := &Client{}
-	
-	schema := map[string]interface{}{
-		"tables": []interface{}{
-			map[string]interface{}{
-				"name": "users",
-				"columns": []interface{}{
-					map[string]interface{}{
-						"name": "id",
-						"type": "INTEGER",
-					},
-					map[string]interface{}{
-						"name": "email",
-						"type": "VARCHAR",
-					},
-				},
-			},
-		},
-	}
-	
-	tableSchemas := client.extractAllTableSchemas(schema)
-	if len(tableSchemas) != 1 {
-		t.Errorf("Expected 1 table schema, got %d", len(tableSchemas))
-	}
-	
-	if userSchema, exists := tableSchemas["users"]; exists {
-		if len(userSchema) != 2 {
-			t.Errorf("Expected 2 columns for users table, got %d", len(userSchema))
-		}
-	} else {
-		t.Error("Expected 'users' table schema to exist")
-	}
-}
-
-// Benchmark tests
-func BenchmarkAuthResponseParsing(b *testing.B) {
-	jsonData := `{"apiKey": "test-key", "token": "test-token"}`
-	b.ResetTimer()
-	
-	for i := 0; i < b.N; i++ {
-		var authResp AuthResponse
-		json.Unmarshal([]byte(jsonData), &authResp)
-	}
-}
-
-func BenchmarkJSONParsing(b *testing.B) {
-	jsonData := `{"queryId": "test-123", "status": "submitted"}`
-	b.ResetTimer()
-	
-	for i := 0; i < b.N; i++ {
-		var queryResp QueryResponse
-		json.Unmarshal([]byte(jsonData), &queryResp)
-	}
-}
-
-func TestErrorResponseHandling(t *testing.T) {
-	// Test server that returns HTTP 400 with error response
-	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusBadRequest)
-		errorResp := ErrorResponse{
-			Code:    "ANA0005",
-			Message: "SQL compilation error: invalid identifier 'TIMESTAMP'",
-		}
-		json.NewEncoder(w).Encode(errorResp)
-	}))
-	defer server.Close()
-
-	client := &Client{
-		httpClient:    &http.Client{},
-		apiKey:        "test-api-key",
-		token:         "test-token",
-		customHeaders: map[string]string{"x-test-header": "test-value"},
-		debug:         false,
-	}
-
-	_, err := client.makeRequest("GET", server.URL, nil)
-	if err == nil {
-		t.Error("Expected error for HTTP 400 response, got nil")
-	}
-
-	expectedError := "API error [ANA0005]: SQL compilation error: invalid identifier 'TIMESTAMP'"
-	if err.Error() != expectedError {
-		t.Errorf("Expected error message '%s', got '%s'", expectedError, err.Error())
-	}
-}
-
-func TestErrorResponseParsing(t *testing.T) {
-	jsonResponse := `{"code": "ANA0005", "message": "SQL compilation error"}`
-
-	var errorResp ErrorResponse
-	err := json.Unmarshal([]byte(jsonResponse), &errorResp)
-	if err != nil {
-		t.Errorf("Failed to unmarshal ErrorResponse: %v", err)
-	}
-
-	if errorResp.Code != "ANA0005" {
-		t.Errorf("ErrorResponse.Code = %v, want ANA0005", errorResp.Code)
-	}
-
-	if errorResp.Message != "SQL compilation error" {
-		t.Errorf("ErrorResponse.Message = %v, want 'SQL compilation error'", errorResp.Message)
-	}
-}
-
-func TestHTTPErrorHandling(t *testing.T) {
-	// Test server that returns HTTP 500 without structured error
-	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte("Internal Server Error"))
-	}))
-	defer server.Close()
-
-	client := &Client{
-		httpClient:    &http.Client{},
-		apiKey:        "test-api-key",
-		token:         "test-token",
-		customHeaders: map[string]string{"x-test-header": "test-value"},
-		debug:         false,
-	}
-
-	_, err := client.makeRequest("GET", server.URL, nil)
-	if err == nil {
-		t.Error("Expected error for HTTP 500 response, got nil")
-	}
-
-	expectedError := "HTTP 500 error: Internal Server Error"
-	if err.Error() != expectedError {
-		t.Errorf("Expected error message '%s', got '%s'", expectedError, err.Error())
-	}
-}
-
 func TestIsExitCommand(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -635,35 +226,43 @@ func TestIsExitCommand(t *testing.T) {
 
 func TestRunPipedMode(t *testing.T) {
 	// Create a test server that always returns success
-	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if strings.Contains(r.URL.Path, "/auth") {
-			response := AuthResponse{ApiKey: "test-key", Token: "test-token"}
+			response := analysis.AuthResponse{ApiKey: "test-key", Token: "test-token"}
 			json.NewEncoder(w).Encode(response)
 		} else if strings.Contains(r.URL.Path, "/queries") && r.Method == "POST" {
-			response := QueryResponse{QueryId: "test-query-id"}
+			response := analysis.QueryResponse{QueryId: "test-query-id"}
 			json.NewEncoder(w).Encode(response)
 		} else if strings.Contains(r.URL.Path, "/queries") && r.Method == "GET" {
-			response := QueryStatusResponse{Status: "COMPLETED", URL: "http://example.com/result.jsonl.gz"}
+			response := analysis.QueryStatusResponse{Status: "COMPLETED", URL: "http://example.com/result.jsonl.gz"}
 			json.NewEncoder(w).Encode(response)
 		}
 	}))
 	defer server.Close()
 
+	api, err := analysis.New(context.Background(), analysis.Options{
+		Config: &analysis.Config{
+			AuthKeyId: "keyId-test",
+			AuthKey:   "secret-test",
+			Endpoint:  server.URL,
+			Headers:   map[string]string{"x-test-header": "test-value"},
+		},
+		HTTPClient: server.Client(),
+	})
+	if err != nil {
+		t.Fatalf("analysis.New() error = %v", err)
+	}
+
 	client := &Client{
-		httpClient:    &http.Client{},
-		baseURL:       strings.TrimPrefix(server.URL, "https://"),
-		authBaseURL:   strings.TrimPrefix(server.URL, "https://"),
-		apiKey:        "test-key",
-		token:         "test-token",
-		customHeaders: map[string]string{"x-test-header": "test-value"},
-		debug:         false,
+		api:   api,
+		debug: false,
 	}
 
 	// Test that runPipedMode doesn't panic
 	// Note: This is a limited test as we can't easily mock stdin
 	// The actual functionality is tested through integration tests
-	if client.baseURL == "" {
-		t.Error("Client should have a baseURL set")
+	if client.api == nil {
+		t.Error("Client should have an API client set")
 	}
 }
 
@@ -815,15 +414,12 @@ func TestIntegrationHelper(t *testing.T) {
 	// This test would require actual Soracom credentials
 	// For now, we just test that the client can be created
 	client := &Client{
-		httpClient:    &http.Client{},
-		baseURL:       "test.api.soracom.io",
-		authBaseURL:   "test.api.soracom.io",
-		customHeaders: map[string]string{"x-test-header": "test-value"},
-		debug:         true,
+		debug:       true,
+		profileName: "test",
 	}
 	
-	if client.baseURL != "test.api.soracom.io" {
-		t.Errorf("Client baseURL not set correctly")
+	if client.profileName != "test" {
+		t.Errorf("Client profileName not set correctly")
 	}
 	
 	if client.debug != true {
@@ -831,70 +427,6 @@ func TestIntegrationHelper(t *testing.T) {
 	}
 }
 
-func TestSQLAssistantRequestStructure(t *testing.T) {
-	request := SQLAssistantRequest{
-		Messages: []SQLAssistantMessage{
-			{
-				Role:      "user",
-				Context:   "Show me SIM counts by status",
-				AgentMode: false,
-			},
-		},
-		TimeRange: SQLAssistantTimeRange{
-			Hours: 2,
-		},
-		ExistingQuery: "SELECT * FROM SIM_SNAPSHOTS",
-	}
-
-	// Test JSON marshaling
-	data, err := json.Marshal(request)
-	if err != nil {
-		t.Fatalf("Failed to marshal SQL assistant request: %v", err)
-	}
-
-	// Test that it contains expected fields
-	var parsed map[string]interface{}
-	if err := json.Unmarshal(data, &parsed); err != nil {
-		t.Fatalf("Failed to parse marshalled request: %v", err)
-	}
-
-	// Verify structure
-	if parsed["messages"] == nil {
-		t.Error("Expected 'messages' field in request")
-	}
-	if parsed["timeRange"] == nil {
-		t.Error("Expected 'timeRange' field in request")
-	}
-	if parsed["existing_query"] == nil {
-		t.Error("Expected 'existing_query' field in request")
-	}
-}
-
-func TestSQLAssistantResponseParsing(t *testing.T) {
-	// Mock response JSON matching actual API format
-	responseJSON := `{
-		"id": "test-id-123",
-		"sql_query": "SELECT status, COUNT(*) FROM SIM_SNAPSHOTS GROUP BY status",
-		"context": "This query counts SIMs by their status",
-		"visualization": {"display": true, "type": "bar"}
-	}`
-
-	var response SQLAssistantResponse
-	if err := json.Unmarshal([]byte(responseJSON), &response); err != nil {
-		t.Fatalf("Failed to parse SQL assistant response: %v", err)
-	}
-
-	if response.SQLQuery != "SELECT status, COUNT(*) FROM SIM_SNAPSHOTS GROUP BY status" {
-		t.Errorf("Expected SQL to be parsed correctly, got: %s", response.SQLQuery)
-	}
-	if response.Context != "This query counts SIMs by their status" {
-		t.Errorf("Expected context to be parsed correctly, got: %s", response.Context)
-	}
-	if response.ID != "test-id-123" {
-		t.Errorf("Expected ID to be parsed correctly, got: %s", response.ID)
-	}
-}
-
 func TestAskCommandInSuggestions(t *testing.T) {
 	// Test that .ask command exists in the suggestion list
 	// This tests the suggestion definition, not the filtering logic
@@ -940,41 +472,935 @@ func TestSQLAssistantAnimation(t *testing.T) {
 }
 
 
-func TestQueryStatusHandling(t *testing.T) {
-	// Test different query status scenarios
-	testCases := []struct {
-		name     string
-		status   string
-		expected bool // whether it should be considered complete
-	}{
-		{"completed", "COMPLETED", true},
-		{"exporting", "EXPORTING", false},
-		{"running", "RUNNING", false},
-		{"failed", "FAILED", false},
-		{"unknown", "UNKNOWN_STATUS", false},
+func TestNewCommandContextTimeout(t *testing.T) {
+	ctx, cancel := newCommandContext(10 * time.Millisecond)
+	defer cancel(nil)
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("command context did not expire")
 	}
-	
-	for _, tc := range testCases {
-		t.Run(tc.name, func(t *testing.T) {
-			// Test that we can parse status responses correctly
-			statusJSON := fmt.Sprintf(`{"status":"%s","columnInfo":[{"name":"SIM_ID","type":"string","databaseType":"TEXT"}]}`, tc.status)
-			
-			var statusResp QueryStatusResponse
-			err := json.Unmarshal([]byte(statusJSON), &statusResp)
-			if err != nil {
-				t.Fatalf("Failed to parse status response: %v", err)
-			}
-			
-			if statusResp.Status != tc.status {
-				t.Errorf("Expected status %s, got %s", tc.status, statusResp.Status)
-			}
-			
-			// Test completion logic
-			isComplete := statusResp.Status == "COMPLETED"
-			if isComplete != tc.expected {
-				t.Errorf("Expected completion status %v for %s, got %v", tc.expected, tc.status, isComplete)
-			}
-		})
+
+	err := contextCause(ctx, errors.New("request failed: context deadline exceeded"))
+	if err == nil || err.Error() != "timed out after 10ms" {
+		t.Errorf("contextCause() = %v, want 'timed out after 10ms'", err)
+	}
+}
+
+func TestContextCausePassesThroughErrors(t *testing.T) {
+	ctx, cancel := newCommandContext(0)
+	defer cancel(nil)
+
+	apiErr := errors.New("API error [ANA0005]: SQL compilation error")
+	if err := contextCause(ctx, apiErr); err != apiErr {
+		t.Errorf("contextCause() = %v, want the original error", err)
+	}
+	if err := contextCause(ctx, nil); err != nil {
+		t.Errorf("contextCause(nil) = %v, want nil", err)
+	}
+}
+
+func TestHandleDotCommand(t *testing.T) {
+	client := &Client{format: "table"}
+
+	if !client.handleDotCommand(".FORMAT csv", false) || client.format != "csv" {
+		t.Errorf("format = %s after .FORMAT csv, want csv", client.format)
+	}
+	if !client.handleDotCommand(".timeout 90s", false) || client.timeout != 90*time.Second {
+		t.Errorf("timeout = %s after .timeout 90s, want 1m30s", client.timeout)
+	}
+
+	usage := captureStdout(t, func() error {
+		client.handleDotCommand(".format sideways", false)
+		return nil
+	})
+	if !strings.Contains(usage, "Usage: .format") || client.format != "csv" {
+		t.Errorf(".format sideways printed %q and set format %s, want the usage and csv", usage, client.format)
+	}
+
+	for _, line := range []string{"SELECT 1;", ".unknown", ".formats json"} {
+		if client.handleDotCommand(line, false) {
+			t.Errorf("handleDotCommand(%q) = true, want it left to run as SQL", line)
+		}
+	}
+}
+
+func TestHandleTimeoutCommand(t *testing.T) {
+	client := &Client{}
+
+	client.handleTimeoutCommand([]string{".timeout", "90s"})
+	if client.timeout != 90*time.Second {
+		t.Errorf("timeout = %s, want 1m30s", client.timeout)
+	}
+
+	client.handleTimeoutCommand([]string{".timeout", "bogus"})
+	if client.timeout != 90*time.Second {
+		t.Errorf("invalid duration changed timeout to %s", client.timeout)
+	}
+
+	client.handleTimeoutCommand([]string{".timeout", "off"})
+	if client.timeout != 0 {
+		t.Errorf("timeout = %s after .timeout off, want 0", client.timeout)
+	}
+}
+
+func TestHandleSetCommand(t *testing.T) {
+	client := &Client{pollInterval: 250 * time.Millisecond, maxWait: 30 * time.Minute}
+
+	client.handleSetCommand([]string{".set", "poll-interval", "500ms"})
+	if client.pollInterval != 500*time.Millisecond {
+		t.Errorf("pollInterval = %s, want 500ms", client.pollInterval)
+	}
+
+	client.handleSetCommand([]string{".set", "poll-interval", "-1s"})
+	if client.pollInterval != 500*time.Millisecond {
+		t.Errorf("invalid poll interval changed it to %s", client.pollInterval)
+	}
+
+	client.handleSetCommand([]string{".set", "max-wait", "off"})
+	if client.maxWait != 0 {
+		t.Errorf("maxWait = %s after .set max-wait off, want 0", client.maxWait)
+	}
+
+	client.handleSetCommand([]string{".set", "timeout", "2m;"})
+	if client.timeout != 2*time.Minute {
+		t.Errorf("timeout = %s, want 2m", client.timeout)
+	}
+
+	client.handleSetCommand([]string{".set", "max-column-width", "80"})
+	if client.maxColumnWidth != 80 {
+		t.Errorf("maxColumnWidth = %d, want 80", client.maxColumnWidth)
+	}
+
+	client.handleSetCommand([]string{".set", "max-column-width", "-5"})
+	if client.maxColumnWidth != 80 {
+		t.Errorf("invalid width changed maxColumnWidth to %d", client.maxColumnWidth)
+	}
+
+	client.handleSetCommand([]string{".set", "max-column-width", "off"})
+	if client.maxColumnWidth != 0 {
+		t.Errorf("maxColumnWidth = %d after .set max-column-width off, want 0", client.maxColumnWidth)
 	}
 }
 
+// newAsyncTestClient returns a silent client backed by an API server that
+// reports queryID as COMPLETED with a single gzip JSONL result row.
+func newAsyncTestClient(t *testing.T, queryID string) *Client {
+	t.Helper()
+
+	results := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gz := gzip.NewWriter(w)
+		gz.Write([]byte(`{"imsi":"001010000000001","count":3}` + "\n"))
+		gz.Close()
+	}))
+	t.Cleanup(results.Close)
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.Contains(r.URL.Path, "/auth"):
+			json.NewEncoder(w).Encode(analysis.AuthResponse{ApiKey: "test-key", Token: "test-token"})
+		case r.URL.Path == "/v1/analysis/queries" && r.Method == "POST":
+			json.NewEncoder(w).Encode(analysis.QueryResponse{QueryId: queryID})
+		case r.URL.Path == "/v1/analysis/queries/"+queryID && r.Method == "GET":
+			json.NewEncoder(w).Encode(analysis.QueryStatusResponse{
+				Status:     "COMPLETED",
+				URL:        results.URL + "/async-result.jsonl.gz",
+				ColumnInfo: []analysis.ColumnInfo{{Name: "imsi", Type: "string", DatabaseType: "VARCHAR"}, {Name: "count", Type: "number", DatabaseType: "NUMBER"}},
+			})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	api, err := analysis.New(context.Background(), analysis.Options{
+		Config:     &analysis.Config{AuthKeyId: "keyId-test", AuthKey: "secret-test", Endpoint: server.URL},
+		HTTPClient: server.Client(),
+	})
+	if err != nil {
+		t.Fatalf("analysis.New() error = %v", err)
+	}
+
+	return &Client{api: api, silent: true, format: "csv"}
+}
+
+// captureStdout returns what fn prints to stdout.
+func captureStdout(t *testing.T, fn func() error) string {
+	t.Helper()
+
+	oldStdout := os.Stdout
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+
+	err := fn()
+
+	w.Close()
+	os.Stdout = oldStdout
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	output, _ := io.ReadAll(r)
+	return string(output)
+}
+
+// writeProfiles saves each profile JSON to dir as <name>.json.
+func writeProfiles(t *testing.T, dir string, profiles map[string]string) {
+	t.Helper()
+
+	for name, profile := range profiles {
+		if err := os.WriteFile(filepath.Join(dir, name+".json"), []byte(profile), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSubmitQuery(t *testing.T) {
+	client := newAsyncTestClient(t, "async-query-id")
+	client.submitOnly = true
+
+	output := captureStdout(t, func() error {
+		return client.runQuery("SELECT 1", false)
+	})
+	if output != "async-query-id\n" {
+		t.Errorf("runQuery() with -submit printed %q, want only the query ID", output)
+	}
+}
+
+func TestFetchQuery(t *testing.T) {
+	client := newAsyncTestClient(t, "async-query-id")
+
+	output := captureStdout(t, func() error {
+		return client.fetchQuery("async-query-id", false)
+	})
+	if !strings.Contains(output, "001010000000001") {
+		t.Errorf("fetchQuery() output should contain the result row, got: %s", output)
+	}
+}
+
+func TestShowQueryStatus(t *testing.T) {
+	client := newAsyncTestClient(t, "async-query-id")
+	client.format = "table"
+
+	output := captureStdout(t, func() error {
+		return client.showQueryStatus("async-query-id")
+	})
+	for _, want := range []string{"Status:   COMPLETED", "async-result.jsonl.gz", "imsi (string, VARCHAR)"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("showQueryStatus() output should contain %q, got: %s", want, output)
+		}
+	}
+
+	client.format = "json"
+	output = captureStdout(t, func() error {
+		return client.showQueryStatus("async-query-id")
+	})
+	var status analysis.QueryStatusResponse
+	if err := json.Unmarshal([]byte(output), &status); err != nil {
+		t.Fatalf("showQueryStatus() with -format json printed invalid JSON: %v", err)
+	}
+	if status.Status != "COMPLETED" || len(status.ColumnInfo) != 2 {
+		t.Errorf("showQueryStatus() JSON = %+v", status)
+	}
+}
+
+func TestDisplayRowsStreamingFormats(t *testing.T) {
+	input := `{"imsi":"001010000000001","count":3}` + "\n" + `{"imsi":"001010000000002","count":5}` + "\n"
+	columns := []analysis.ColumnInfo{{Name: "imsi"}, {Name: "count"}}
+
+	testCases := []struct {
+		format string
+		want   string
+	}{
+		{"csv", "imsi,count\n001010000000001,3\n001010000000002,5\n"},
+		{"jsonl", `{"count":3,"imsi":"001010000000001"}` + "\n" + `{"count":5,"imsi":"001010000000002"}` + "\n"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.format, func(t *testing.T) {
+			client := &Client{format: tc.format}
+			rows := analysis.NewRows(io.NopCloser(strings.NewReader(input)), columns)
+			output := captureStdout(t, func() error {
+				return client.displayRows(rows)
+			})
+			if output != tc.want {
+				t.Errorf("displayRows() with -format %s = %q, want %q", tc.format, output, tc.want)
+			}
+		})
+	}
+
+	t.Run("json", func(t *testing.T) {
+		client := &Client{format: "json"}
+		rows := analysis.NewRows(io.NopCloser(strings.NewReader(input)), columns)
+		output := captureStdout(t, func() error {
+			return client.displayRows(rows)
+		})
+		var decoded []map[string]interface{}
+		if err := json.Unmarshal([]byte(output), &decoded); err != nil {
+			t.Fatalf("displayRows() with -format json printed invalid JSON: %v\n%s", err, output)
+		}
+		if len(decoded) != 2 || decoded[1]["imsi"] != "001010000000002" {
+			t.Errorf("displayRows() JSON = %v, want both rows", decoded)
+		}
+	})
+}
+
+func TestDisplayRowsVertical(t *testing.T) {
+	input := `{"imsi":"001010000000001","count":3,"note":"first\nsecond"}` + "\n" + `{"imsi":"001010000000002","count":null}` + "\n"
+	columns := []analysis.ColumnInfo{{Name: "imsi"}, {Name: "count"}, {Name: "note"}}
+	want := `-[ RECORD 1 ]----------
+imsi  | 001010000000001
+count | 3
+note  | first
+      | second
+-[ RECORD 2 ]----------
+imsi  | 001010000000002
+count | NULL
+note  |
+
+(2 rows)
+`
+
+	for _, client := range []*Client{{format: "vertical"}, {format: "table", expanded: "on"}} {
+		rows := analysis.NewRows(io.NopCloser(strings.NewReader(input)), columns)
+		output := captureStdout(t, func() error {
+			return client.displayRows(rows)
+		})
+		if output != want {
+			t.Errorf("displayRows() with -format %s and .expanded %s = %q, want %q", client.format, client.expandedMode(), output, want)
+		}
+	}
+
+	// Output to a pipe has no terminal width, so auto keeps the table
+	client := &Client{format: "table", expanded: "auto"}
+	rows := analysis.NewRows(io.NopCloser(strings.NewReader(input)), columns)
+	output := captureStdout(t, func() error {
+		return client.displayRows(rows)
+	})
+	if !strings.HasPrefix(output, "┌") {
+		t.Errorf("displayRows() with .expanded auto and no terminal = %q, want a table", output)
+	}
+}
+
+func TestHandleExpandedCommand(t *testing.T) {
+	client := &Client{}
+	if mode := client.expandedMode(); mode != "off" {
+		t.Errorf("expandedMode() = %s initially, want off", mode)
+	}
+
+	client.handleExpandedCommand([]string{".expanded", "AUTO"})
+	if client.expanded != "auto" {
+		t.Errorf("expanded = %q, want auto", client.expanded)
+	}
+
+	client.handleExpandedCommand([]string{".expanded", "sideways"})
+	if client.expanded != "auto" {
+		t.Errorf("invalid mode changed expanded to %q", client.expanded)
+	}
+}
+
+func TestDisplayTableMaxColumnWidth(t *testing.T) {
+	columns := []string{"user_agent", "count"}
+	rows := []map[string]interface{}{
+		{"user_agent": "Mozilla/5.0 (X11; Linux x86_64)", "count": float64(12)},
+		{"user_agent": "curl/8.0", "count": float64(3)},
+	}
+	want := `┌────────────┬───────┐
+│ user_agent │ count │
+├────────────┼───────┤
+│ Mozilla/5… │    12 │
+│ curl/8.0   │     3 │
+└────────────┴───────┘
+
+(2 rows)
+`
+
+	var output bytes.Buffer
+	client := &Client{maxColumnWidth: 10}
+	client.displayTable(&output, columns, rows)
+	if output.String() != want {
+		t.Errorf("displayTable() =\n%s\nwant\n%s", output.String(), want)
+	}
+}
+
+func TestTruncateCell(t *testing.T) {
+	testCases := []struct {
+		value string
+		width int
+		want  string
+	}{
+		{"short", 10, "short"},
+		{"exactly10!", 10, "exactly10!"},
+		{"longer than ten", 10, "longer th…"},
+		{"longer than ten", 0, "longer than ten"},
+		{"東京都港区赤坂", 5, "東京…"},
+		{"東京都港区赤坂", 6, "東京…"}, // A full-width character doesn't fit in the last cell
+	}
+
+	for _, tc := range testCases {
+		if got := truncateCell(tc.value, tc.width); got != tc.want {
+			t.Errorf("truncateCell(%q, %d) = %q, want %q", tc.value, tc.width, got, tc.want)
+		}
+	}
+}
+
+func TestCellWidth(t *testing.T) {
+	testCases := []struct {
+		value string
+		want  int
+	}{
+		{"sensor-01", 9},
+		{"東京オフィス", 12},
+		{"ｿﾗｺﾑ", 4}, // Half-width katakana
+		{"温度🌡", 5},
+		{"👨‍👩‍👧", 2},
+		{"─│…", 3},
+	}
+
+	for _, tc := range testCases {
+		if got := cellWidth(tc.value); got != tc.want {
+			t.Errorf("cellWidth(%q) = %d, want %d", tc.value, got, tc.want)
+		}
+	}
+}
+
+func TestDisplayTableFullWidth(t *testing.T) {
+	columns := []string{"operator", "tag", "count"}
+	rows := []map[string]interface{}{
+		{"operator": "ソラコム太郎", "tag": "🚚 配送", "count": float64(12)},
+		{"operator": "ops", "tag": "test", "count": float64(3)},
+	}
+
+	var output bytes.Buffer
+	client := &Client{}
+	client.displayTable(&output, columns, rows)
+
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	for _, line := range lines[:6] {
+		if width := cellWidth(line); width != cellWidth(lines[0]) {
+			t.Errorf("line %q is %d cells wide, want %d like the border\n%s", line, width, cellWidth(lines[0]), output.String())
+		}
+	}
+
+	output.Reset()
+	client.displayVertical(&output, columns, rows[:1])
+	if !strings.Contains(output.String(), "operator | ソラコム太郎\n") || !strings.HasPrefix(output.String(), "-[ RECORD 1 ]----------\n") {
+		t.Errorf("displayVertical() =\n%s", output.String())
+	}
+}
+
+func TestExceedsScreen(t *testing.T) {
+	output := []byte("┌──────┐\n│ abcd │\n└──────┘\n")
+
+	testCases := []struct {
+		width, height int
+		want          bool
+	}{
+		{80, 24, false},
+		{80, 4, false},
+		{80, 3, true}, // No line left for the prompt
+		{7, 24, true},
+		{8, 24, false},
+		{0, 0, false}, // Not a terminal
+	}
+
+	for _, tc := range testCases {
+		if got := exceedsScreen(output, tc.width, tc.height); got != tc.want {
+			t.Errorf("exceedsScreen() on a %dx%d screen = %v, want %v", tc.width, tc.height, got, tc.want)
+		}
+	}
+}
+
+func TestHandlePagerCommand(t *testing.T) {
+	t.Setenv("PAGER", "more -d")
+	if args := pagerCommand().Args; strings.Join(args, " ") != "more -d" {
+		t.Errorf("pagerCommand() = %v, want $PAGER", args)
+	}
+	t.Setenv("PAGER", "")
+	if args := pagerCommand().Args; strings.Join(args, " ") != "less -S" {
+		t.Errorf("pagerCommand() = %v without $PAGER, want less -S", args)
+	}
+
+	client := &Client{pager: true}
+	client.handlePagerCommand([]string{".pager", "off"})
+	if client.pager {
+		t.Error("pager still on after .pager off")
+	}
+	client.handlePagerCommand([]string{".pager", "ON"})
+	if !client.pager {
+		t.Error("pager still off after .pager ON")
+	}
+}
+
+func TestTableWidth(t *testing.T) {
+	columns := []string{"imsi", "count"}
+	rows := []map[string]interface{}{{"imsi": "001010000000001", "count": float64(3)}}
+	client := &Client{}
+	// "│ 001010000000001 │ count │"
+	if width := tableWidth(columns, client.columnWidths(columns, rows)); width != 27 {
+		t.Errorf("tableWidth() = %d, want 27", width)
+	}
+}
+
+func TestWriteParquet(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "results.parquet")
+	client := &Client{format: "parquet", output: output}
+	columns := []analysis.ColumnInfo{{Name: "imsi", Type: "string", DatabaseType: "VARCHAR"}, {Name: "amount", Type: "number", DatabaseType: "NUMBER(10,2)"}}
+
+	rows := analysis.NewRows(io.NopCloser(strings.NewReader(`{"imsi":"001010000000001","amount":1.25}`+"\n")), columns)
+	captureStdout(t, func() error {
+		return client.displayRows(rows)
+	})
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	file, err := parquetgo.OpenFile(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("%s is not a Parquet file: %v", output, err)
+	}
+	var types []string
+	for _, field := range file.Schema().Fields() {
+		types = append(types, field.Name()+" "+field.Type().LogicalType().String())
+	}
+	if got := strings.Join(types, ", "); got != "imsi STRING, amount DECIMAL(10,2)" {
+		t.Errorf("columns = %s, want imsi STRING, amount DECIMAL(10,2)", got)
+	}
+	written, err := parquetgo.Read[struct {
+		IMSI   string `parquet:"imsi"`
+		Amount int64  `parquet:"amount"`
+	}](bytes.NewReader(data), int64(len(data)))
+	if err != nil || len(written) != 1 || written[0].IMSI != "001010000000001" || written[0].Amount != 125 {
+		t.Errorf("rows = %+v, %v, want 001010000000001 with 1.25 stored as 125", written, err)
+	}
+
+	// A value that does not fit its column fails the query and keeps the
+	// previous file
+	rows = analysis.NewRows(io.NopCloser(strings.NewReader(`{"imsi":"001010000000002","amount":1.255}`+"\n")), columns)
+	err = client.displayRows(rows)
+	if err == nil || !strings.Contains(err.Error(), "row 1: column amount: 1.255 has more than 2 digits") {
+		t.Errorf("displayRows() error = %v, want a decimal error", err)
+	}
+	if kept, _ := os.ReadFile(output); !bytes.Equal(kept, data) {
+		t.Error("a failed write replaced the previous file")
+	}
+	if _, err := os.Stat(output + ".partial"); !os.IsNotExist(err) {
+		t.Error("a failed write left the partial file behind")
+	}
+}
+
+func TestParquetColumn(t *testing.T) {
+	testCases := []struct {
+		column analysis.ColumnInfo
+		want   string
+	}{
+		{analysis.ColumnInfo{Name: "a", Type: "number", DatabaseType: "NUMBER(38, 2)"}, "DECIMAL(38,2)"},
+		{analysis.ColumnInfo{Name: "a", Type: "number", DatabaseType: "DECIMAL(9)"}, "DECIMAL(9,0)"},
+		{analysis.ColumnInfo{Name: "a", Type: "number", DatabaseType: "NUMBER"}, "DOUBLE"},
+		{analysis.ColumnInfo{Name: "a", Type: "number", DatabaseType: "BIGINT"}, "INT(64,true)"},
+		{analysis.ColumnInfo{Name: "a", Type: "boolean", DatabaseType: "BOOLEAN"}, "BOOLEAN"},
+		{analysis.ColumnInfo{Name: "a", Type: "string", DatabaseType: "timestamp_ntz(9)"}, "TIMESTAMP(isAdjustedToUTC=false,unit=MICROS)"},
+		{analysis.ColumnInfo{Name: "a", Type: "string", DatabaseType: "TIMESTAMP_TZ"}, "TIMESTAMP(isAdjustedToUTC=true,unit=MICROS)"},
+		{analysis.ColumnInfo{Name: "a", Type: "string", DatabaseType: "DATE"}, "DATE"},
+		{analysis.ColumnInfo{Name: "a", Type: "object", DatabaseType: "VARIANT"}, "JSON"},
+		{analysis.ColumnInfo{Name: "a", Type: "string", DatabaseType: "VARCHAR(16777216)"}, "STRING"},
+		{analysis.ColumnInfo{Name: "a", Type: "object"}, "JSON"},
+	}
+	for _, tc := range testCases {
+		if got := parquetColumn(tc.column).Type().String(); got != tc.want {
+			t.Errorf("parquetColumn(%+v) = %s, want %s", tc.column, got, tc.want)
+		}
+	}
+}
+
+func TestWriteSQLite(t *testing.T) {
+	// '?' and '#' are part of the name, not URI options
+	path := filepath.Join(t.TempDir(), "work?mode=ro#1.db")
+	columns := []analysis.ColumnInfo{
+		{Name: "name", Type: "string", DatabaseType: "VARCHAR"},
+		{Name: "amount", Type: "number", DatabaseType: "NUMBER(38,2)"},
+		{Name: "tags", Type: "object", DatabaseType: "OBJECT"},
+	}
+	input := `{"name":"it's\n.quit\nok","amount":12345678901234567890.10,"tags":{"a":[1]}}` + "\n" +
+		`{"name":"nul\u0000byte","amount":1.5,"tags":null}` + "\n"
+	write := func(ifExists string) error {
+		rows := analysis.NewRows(io.NopCloser(strings.NewReader(input)), columns)
+		return writeSQLite(rows, path, "results", ifExists)
+	}
+	// query returns the rows of a query like the sqlite3 shell prints them
+	query := func(query string) string {
+		db, err := openSQLite(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		rows, err := db.Query(query)
+		if err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		defer rows.Close()
+		columns, _ := rows.Columns()
+		var out strings.Builder
+		for rows.Next() {
+			values := make([]interface{}, len(columns))
+			pointers := make([]interface{}, len(columns))
+			for i := range values {
+				pointers[i] = &values[i]
+			}
+			if err := rows.Scan(pointers...); err != nil {
+				t.Fatal(err)
+			}
+			fields := make([]string, len(values))
+			for i, v := range values {
+				if v != nil {
+					fields[i] = fmt.Sprint(v)
+				}
+			}
+			out.WriteString(strings.Join(fields, "|") + "\n")
+		}
+		if err := rows.Err(); err != nil {
+			t.Fatal(err)
+		}
+		return out.String()
+	}
+
+	if err := write("fail"); err != nil {
+		t.Fatalf("writeSQLite() error = %v", err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("database not created at %s: %v", path, err)
+	}
+	got := query(`SELECT name, length(CAST(name AS BLOB)), amount, typeof(amount), json_extract(tags, '$.a[0]') FROM results`)
+	want := "it's\n.quit\nok|13|12345678901234567890.10|text|1\nnul\x00byte|8|1.5|text|\n"
+	if got != want {
+		t.Errorf("table contents = %q, want %q", got, want)
+	}
+
+	if err := write("fail"); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("writeSQLite() to an existing table error = %v, want already exists", err)
+	}
+	if err := write("append"); err != nil {
+		t.Fatalf("writeSQLite() append error = %v", err)
+	}
+	if got := query("SELECT count(*) FROM results"); got != "4\n" {
+		t.Errorf("rows after append = %s, want 4", got)
+	}
+	if err := write("replace"); err != nil {
+		t.Fatalf("writeSQLite() replace error = %v", err)
+	}
+	if got := query("SELECT count(*) FROM results"); got != "2\n" {
+		t.Errorf("rows after replace = %s, want 2", got)
+	}
+
+	// A download that fails half-way leaves the table as it was
+	rows := analysis.NewRows(io.NopCloser(strings.NewReader(input+`{"name":`)), columns)
+	if err := writeSQLite(rows, path, "results", "replace"); err == nil {
+		t.Error("writeSQLite() of a truncated result succeeded")
+	}
+	if got := query("SELECT count(*) FROM results"); got != "2\n" {
+		t.Errorf("rows after a failed replace = %s, want 2", got)
+	}
+}
+
+func TestSQLiteColumnType(t *testing.T) {
+	testCases := []struct {
+		databaseType, jsonType string
+		want                   string
+	}{
+		{"BIGINT", "number", "INTEGER"},
+		{"NUMBER(38,0)", "number", "INTEGER"},
+		{"number(10, 2)", "number", "TEXT"},
+		{"NUMBER", "number", "REAL"},
+		{"FLOAT", "number", "REAL"},
+		{"BOOLEAN", "boolean", "BOOLEAN"},
+		{"TIMESTAMP_LTZ", "string", "TIMESTAMP"},
+		{"VARIANT", "object", "TEXT"},
+		{"VARCHAR", "string", "TEXT"},
+		{"", "boolean", "BOOLEAN"},
+		{"", "object", "TEXT"},
+	}
+	for _, tc := range testCases {
+		if got := sqliteColumnType(analysis.ColumnInfo{Name: "a", Type: tc.jsonType, DatabaseType: tc.databaseType}); got != tc.want {
+			t.Errorf("sqliteColumnType(%s, %s) = %s, want %s", tc.databaseType, tc.jsonType, got, tc.want)
+		}
+	}
+}
+
+func TestKeepResults(t *testing.T) {
+	client := newAsyncTestClient(t, "async-query-id")
+	client.keepDir = t.TempDir()
+
+	for i := 0; i < 2; i++ {
+		captureStdout(t, func() error {
+			return client.fetchQuery("async-query-id", false)
+		})
+	}
+
+	entries, err := os.ReadDir(client.keepDir)
+	if err != nil {
+		t.Fatalf("ReadDir() error = %v", err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+		info, _ := entry.Info()
+		if perm := info.Mode().Perm(); perm != 0600 {
+			t.Errorf("%s has permissions %o, want 600", entry.Name(), perm)
+		}
+	}
+	if got := strings.Join(names, ","); got != "async-result-1.jsonl,async-result.jsonl" {
+		t.Errorf("kept results = %s, want two files without overwriting", got)
+	}
+}
+
+func TestPrivateResultDir(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	client := &Client{}
+
+	dir, err := client.privateResultDir()
+	if err != nil {
+		t.Fatalf("privateResultDir() error = %v", err)
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0700 {
+		t.Errorf("result directory has permissions %o, want 700", perm)
+	}
+
+	client.cleanupResults()
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("cleanupResults() left %s behind", dir)
+	}
+
+	// A directory with a file handed to an editor outlives the run, and the
+	// next run removes it but not the directory of a running session
+	opened, _ := client.privateResultDir()
+	client.resultOpened = true
+	client.cleanupResults()
+	if _, err := os.Stat(opened); err != nil {
+		t.Fatalf("cleanupResults() removed a file opened in an editor: %v", err)
+	}
+	running, _ := (&Client{}).privateResultDir()
+
+	removeLeftoverResults()
+	if _, err := os.Stat(opened); !os.IsNotExist(err) {
+		t.Errorf("removeLeftoverResults() left %s behind", opened)
+	}
+	if _, err := os.Stat(running); err != nil {
+		t.Errorf("removeLeftoverResults() removed the directory of a running session: %v", err)
+	}
+}
+
+func TestResultFileDiscard(t *testing.T) {
+	dir := t.TempDir()
+
+	result, err := createResultFile(dir, "partial.jsonl")
+	if err != nil {
+		t.Fatalf("createResultFile() error = %v", err)
+	}
+	result.file.WriteString(`{"imsi":`)
+	result.discard()
+
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("discard() left %d files behind", len(entries))
+	}
+}
+
+func TestParseByteSize(t *testing.T) {
+	testCases := []struct {
+		value string
+		want  int64
+	}{
+		{"", 0},
+		{"off", 0},
+		{"1048576", 1048576},
+		{"500MB", 500 << 20},
+		{"1.5g", 3 << 29},
+	}
+
+	for _, tc := range testCases {
+		if got, err := parseByteSize(tc.value); err != nil || got != tc.want {
+			t.Errorf("parseByteSize(%q) = %d, %v, want %d", tc.value, got, err, tc.want)
+		}
+	}
+
+	for _, value := range []string{"lots", "-1MB", "NaN", "inf", "+Inf GB", "9223372036854775807", "8589934592GB", "1e300"} {
+		if got, err := parseByteSize(value); err == nil {
+			t.Errorf("parseByteSize(%q) = %d, want an error", value, got)
+		}
+	}
+}
+
+func TestDebugLogLevel(t *testing.T) {
+	var logs strings.Builder
+	level := new(slog.LevelVar)
+	level.Set(slog.LevelWarn)
+	logger, err := newLogger(&logs, "json", level)
+	if err != nil {
+		t.Fatalf("newLogger() error = %v", err)
+	}
+	client := &Client{logger: logger, logLevel: level, baseLogLevel: slog.LevelWarn}
+
+	client.log().Debug("hidden")
+	client.setDebug(true)
+	client.log().Debug("shown")
+	client.setDebug(false)
+	client.log().Debug("hidden again")
+
+	if strings.Contains(logs.String(), "hidden") || !strings.Contains(logs.String(), `"msg":"shown"`) {
+		t.Errorf(".debug did not switch the log level, got:\n%s", logs.String())
+	}
+
+	if _, err := newLogger(&logs, "xml", level); err == nil {
+		t.Error("newLogger() accepted an unknown format")
+	}
+}
+
+func TestExecuteQueryCancelsAfterMaxWait(t *testing.T) {
+	handler := analysistest.NewHandler(analysistest.DefaultFixtures())
+	handler.Statuses = make([]string, 1000)
+	for i := range handler.Statuses {
+		handler.Statuses[i] = "RUNNING"
+	}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	api, err := analysis.New(context.Background(), analysis.Options{
+		Config: &analysis.Config{AuthKeyId: "keyId-test", AuthKey: "secret-test", Endpoint: server.URL},
+	})
+	if err != nil {
+		t.Fatalf("analysis.New() error = %v", err)
+	}
+	client := &Client{api: api, silent: true, format: "csv", pollInterval: time.Millisecond, maxWait: 20 * time.Millisecond}
+
+	if err := client.executeQuery("SELECT * FROM SIM_SNAPSHOTS", false); err == nil {
+		t.Fatal("executeQuery() succeeded, want a max-wait error")
+	}
+	status, err := api.Status(context.Background(), "mock-query-1")
+	if err != nil {
+		t.Fatalf("Status() error = %v", err)
+	}
+	if status.Status != "CANCELLED" {
+		t.Errorf("query status after -max-wait = %s, want CANCELLED", status.Status)
+	}
+}
+
+func TestProfileCommands(t *testing.T) {
+	server := analysistest.NewServer(analysistest.DefaultFixtures())
+	defer server.Close()
+
+	dir := t.TempDir()
+	t.Setenv(analysis.EnvProfileDir, dir)
+	writeProfiles(t, dir, map[string]string{
+		"prod":    `{"authKeyId": "keyId-prod", "authKey": "secret", "coverageType": "g", "endpoint": "` + server.URL + `"}`,
+		"staging": `{"authKeyId": "keyId-staging", "authKey": "secret", "endpoint": "` + server.URL + `"}`,
+		"broken":  `{"authKeyId": "keyId-broken", "endpoint": "` + server.URL + `"}`,
+	})
+
+	opts := analysis.Options{Profile: "prod"}
+	api, err := analysis.New(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("analysis.New() error = %v", err)
+	}
+	client := &Client{api: api, apiOptions: opts, profileName: "prod", format: "csv", fromTime: 1700000000}
+
+	output := captureStdout(t, func() error {
+		client.showProfiles()
+		return nil
+	})
+	for _, want := range []string{"* prod", "g", "staging", server.URL} {
+		if !strings.Contains(output, want) {
+			t.Errorf(".profiles output missing %q:\n%s", want, output)
+		}
+	}
+
+	captureStdout(t, func() error {
+		client.handleProfileCommand([]string{".profile", "broken"})
+		return nil
+	})
+	if client.profileName != "prod" || client.api != api {
+		t.Errorf("a failed .profile switched to %s", client.profileName)
+	}
+
+	captureStdout(t, func() error {
+		client.handleProfileCommand([]string{".profile", "staging;"})
+		return nil
+	})
+	if client.profileName != "staging" || client.api == api {
+		t.Errorf(".profile staging left the session on %s", client.profileName)
+	}
+	if client.format != "csv" || client.fromTime != 1700000000 {
+		t.Error(".profile did not keep the session settings")
+	}
+	if _, err := client.api.Tables(context.Background()); err != nil {
+		t.Errorf("Tables() after switching profiles: error = %v", err)
+	}
+}
+
+func TestFanoutQuery(t *testing.T) {
+	server := analysistest.NewServer(analysistest.DefaultFixtures())
+	defer server.Close()
+	// This account has no CELL_TOWERS table, so the query fails there
+	other := analysistest.NewServer(&analysistest.Fixtures{Tables: map[string][]map[string]interface{}{}})
+	defer other.Close()
+
+	dir := t.TempDir()
+	t.Setenv(analysis.EnvProfileDir, dir)
+	writeProfiles(t, dir, map[string]string{
+		"prod":    `{"authKeyId": "keyId-prod", "authKey": "secret", "endpoint": "` + server.URL + `"}`,
+		"staging": `{"authKeyId": "keyId-staging", "authKey": "secret", "endpoint": "` + server.URL + `"}`,
+		"other":   `{"authKeyId": "keyId-other", "authKey": "secret", "endpoint": "` + other.URL + `"}`,
+		"broken":  `{"authKeyId": "keyId-broken", "endpoint": "` + server.URL + `"}`,
+	})
+
+	names, err := parseProfileList("prod, staging,other,broken,prod;")
+	if err != nil {
+		t.Fatalf("parseProfileList() error = %v", err)
+	}
+	if strings.Join(names, ",") != "prod,staging,other,broken" {
+		t.Errorf("parseProfileList() = %v", names)
+	}
+	if _, err := parseProfileList("prod,,staging"); err == nil {
+		t.Error("parseProfileList() accepted an empty profile name")
+	}
+
+	targets, err := loginProfiles(context.Background(), analysis.Options{}, names)
+	if err != nil {
+		t.Fatalf("loginProfiles() error = %v", err)
+	}
+	client := &Client{api: targets[0].api, fanout: targets, silent: true, format: "csv", pollInterval: time.Millisecond}
+	if got := client.promptName(); got != "prod,staging,other" {
+		t.Errorf("promptName() = %q, want the profiles that logged in", got)
+	}
+
+	var queryErr error
+	output := captureStdout(t, func() error {
+		queryErr = client.executeQuery("select count(*) from CELL_TOWERS", false)
+		return nil
+	})
+	if queryErr == nil || !strings.Contains(queryErr.Error(), "1 of 3 profiles") {
+		t.Errorf("executeQuery() error = %v, want the failed profile counted", queryErr)
+	}
+	want := "_profile,COUNT(*)\nprod,2\nstaging,2\n"
+	if output != want {
+		t.Errorf("executeQuery() output = %q, want %q", output, want)
+	}
+
+	client.fanout = targets[2:]
+	if err := client.executeQuery("select count(*) from CELL_TOWERS", false); err == nil {
+		t.Error("executeQuery() succeeded although the query failed under every profile")
+	}
+
+	// -submit prints one query ID, so it cannot fan out
+	submitting := &Client{api: targets[0].api, submitOnly: true}
+	output = captureStdout(t, func() error {
+		submitting.handleFanoutCommand([]string{".fanout", "prod,staging"})
+		return nil
+	})
+	if submitting.fanout != nil || !strings.Contains(output, "not available with -submit") {
+		t.Errorf(".fanout with -submit turned fan-out on: %q", output)
+	}
+}
@@ -6,6 +6,8 @@ import (
 	"net/http"
 	"os"
 	"time"
+
+	"soraql/analysis"
 )
 
 // TestConfig represents a test configuration
@@ -60,9 +62,7 @@ func NewHTTPClient() HTTPClient {
 }
 
 // HTTPClient interface for easier mocking
-type HTTPClient interface {
-	Do(req *http.Request) (*http.Response, error)
-}
+type HTTPClient = analysis.HTTPClient
 
 // RealHTTPClient implements HTTPClient
 type RealHTTPClient struct {